@@ -5,7 +5,9 @@ go 1.16
 require (
 	github.com/gin-gonic/gin v1.7.7
 	github.com/go-git/go-git/v5 v5.4.2
-	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
+	github.com/pkg/sftp v1.13.5
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
+	gopkg.in/yaml.v2 v2.3.0
 	gorm.io/driver/mysql v1.3.3
 	gorm.io/gorm v1.23.4
 	k8s.io/klog v1.0.0
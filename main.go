@@ -5,11 +5,14 @@ import (
 	"github.com/kubespace/pipeline-plugin/pkg/conf"
 	"github.com/kubespace/pipeline-plugin/pkg/models"
 	"github.com/kubespace/pipeline-plugin/pkg/models/mysql"
+	"github.com/kubespace/pipeline-plugin/pkg/plugins"
 	"github.com/kubespace/pipeline-plugin/pkg/server"
 	"github.com/kubespace/pipeline-plugin/pkg/utils"
 	"k8s.io/klog"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -21,6 +24,43 @@ var (
 	mysqlUser        = flag.String("mysql-user", LookupEnvOrString("MYSQL_USER", "root"), "mysql db user.")
 	mysqlPassword    = flag.String("mysql-password", LookupEnvOrString("MYSQL_PASSWORD", ""), "mysql password used.")
 	mysqlDbName      = flag.String("mysql-dbname", LookupEnvOrString("MYSQL_DBNAME", "kubespace"), "mysql db used.")
+
+	insecureRegistries    = flag.String("insecureRegistries", LookupEnvOrString("INSECURE_REGISTRIES", ""), "Comma separated registries accessed by http or self-signed certificate")
+	imageBuilder          = flag.String("imageBuilder", LookupEnvOrString("IMAGE_BUILDER", "docker"), "Default image builder: docker, buildah or kaniko")
+	kanikoImage           = flag.String("kanikoImage", LookupEnvOrString("KANIKO_IMAGE", "gcr.io/kaniko-project/executor:v1.23.2"), "Kaniko executor image run by container runtime of kaniko image builder")
+	buildCacheDir         = flag.String("buildCacheDir", LookupEnvOrString("BUILD_CACHE_DIR", ""), "Code build dependency cache dir, default is .build-cache under data dir")
+	buildCacheMaxSize     = flag.Int("buildCacheMaxSize", LookupEnvOrInt("BUILD_CACHE_MAX_SIZE", 10240), "Max total size (MB) of code build dependency caches, 0 is unlimited")
+	buildCacheLockTimeout = flag.Int("buildCacheLockTimeout", LookupEnvOrInt("BUILD_CACHE_LOCK_TIMEOUT", 30), "Max minutes to wait for a dependency cache used by another job")
+	maxContainerCpus      = flag.String("maxContainerCpus", LookupEnvOrString("MAX_CONTAINER_CPUS", ""), "Max cpus of build and script containers, such as 2.5, empty is unlimited")
+	maxContainerMemory    = flag.String("maxContainerMemory", LookupEnvOrString("MAX_CONTAINER_MEMORY", ""), "Max memory of build and script containers, such as 4g, empty is unlimited")
+	maxContainerPids      = flag.Int("maxContainerPids", LookupEnvOrInt("MAX_CONTAINER_PIDS", 0), "Max pids of build and script containers, 0 is unlimited")
+	containerNetworks     = flag.String("containerNetworks", LookupEnvOrString("CONTAINER_NETWORKS", "host,bridge,none"), "Comma separated network modes allowed for build and script containers")
+	defaultNetwork        = flag.String("defaultContainerNetwork", LookupEnvOrString("DEFAULT_CONTAINER_NETWORK", "host"), "Network mode of build and script containers if job not specified")
+	containerUser         = flag.String("containerUser", LookupEnvOrString("CONTAINER_USER", ""), "Default uid[:gid] of build and script containers, empty is the user of plugin server")
+	cleanupImage          = flag.String("cleanupImage", LookupEnvOrString("CLEANUP_IMAGE", "busybox:latest"), "Helper image to remove workspace files that plugin server has no permission to delete")
+	containerRuntime      = flag.String("containerRuntime", LookupEnvOrString("CONTAINER_RUNTIME", "docker"), "Default container runtime of build and script containers: docker, podman or containerd")
+	podmanHost            = flag.String("podmanHost", LookupEnvOrString("PODMAN_HOST", "unix:///run/podman/podman.sock"), "Docker compatible api address of podman runtime")
+	podmanCertPath        = flag.String("podmanCertPath", LookupEnvOrString("PODMAN_CERT_PATH", ""), "Dir of ca.pem, cert.pem and key.pem to access podman api over tcp with tls")
+	nerdctlPath           = flag.String("nerdctlPath", LookupEnvOrString("NERDCTL_PATH", "nerdctl"), "Nerdctl path used by containerd runtime")
+	containerdAddress     = flag.String("containerdAddress", LookupEnvOrString("CONTAINERD_ADDRESS", "/run/containerd/containerd.sock"), "Containerd socket address of containerd runtime")
+	containerdNamespace   = flag.String("containerdNamespace", LookupEnvOrString("CONTAINERD_NAMESPACE", "default"), "Containerd namespace of containerd runtime")
+	dockerHost            = flag.String("dockerHost", LookupEnvOrString("DOCKER_HOST", "unix:///var/run/docker.sock"), "Docker engine api address of container runtime, such as unix:///var/run/docker.sock or tcp://127.0.0.1:2375, tcp address of other hosts requires dockerCertPath")
+	dockerCertPath        = flag.String("dockerCertPath", LookupEnvOrString("DOCKER_CERT_PATH", ""), "Dir of ca.pem, cert.pem and key.pem to access docker engine api over tcp with tls")
+	dockerfileTemplateDir = flag.String("dockerfileTemplateDir", LookupEnvOrString("DOCKERFILE_TEMPLATE_DIR", ""), "Dir of <project type>.Dockerfile templates overriding builtin templates to generate Dockerfile: go, maven, gradle, node, python, static")
+	artifactStore         = flag.String("artifactStore", LookupEnvOrString("ARTIFACT_STORE", "local"), "Build artifact store: local or s3")
+	artifactDir           = flag.String("artifactDir", LookupEnvOrString("ARTIFACT_DIR", ""), "Local build artifact store dir, default is .artifacts under data dir")
+	artifactMaxSize       = flag.Int("artifactMaxSize", LookupEnvOrInt("ARTIFACT_MAX_SIZE", 1024), "Max size (MB) of a build artifact archive, 0 is unlimited")
+	artifactS3Endpoint    = flag.String("artifactS3Endpoint", LookupEnvOrString("ARTIFACT_S3_ENDPOINT", ""), "S3 compatible endpoint of build artifact store, such as https://s3.us-east-1.amazonaws.com or http://minio:9000")
+	artifactS3Region      = flag.String("artifactS3Region", LookupEnvOrString("ARTIFACT_S3_REGION", "us-east-1"), "S3 region of build artifact store")
+	artifactS3Bucket      = flag.String("artifactS3Bucket", LookupEnvOrString("ARTIFACT_S3_BUCKET", ""), "S3 bucket of build artifact store")
+	artifactS3AccessKey   = flag.String("artifactS3AccessKey", LookupEnvOrString("ARTIFACT_S3_ACCESS_KEY", ""), "S3 access key of build artifact store")
+	artifactS3SecretKey   = flag.String("artifactS3SecretKey", LookupEnvOrString("ARTIFACT_S3_SECRET_KEY", ""), "S3 secret key of build artifact store")
+	artifactS3PathStyle   = flag.Bool("artifactS3PathStyle", LookupEnvOrString("ARTIFACT_S3_PATH_STYLE", "true") == "true", "Use path style s3 url endpoint/bucket/key, required by most self-hosted s3 services")
+	imageScanImage        = flag.String("imageScanImage", LookupEnvOrString("IMAGE_SCAN_IMAGE", "aquasec/trivy:0.45.1"), "Trivy image to scan vulnerabilities of built images")
+	sbomImage             = flag.String("sbomImage", LookupEnvOrString("SBOM_IMAGE", "anchore/syft:v0.98.0"), "Syft image to generate SBOM of built images and source code")
+	imageScanDbDir        = flag.String("imageScanDbDir", LookupEnvOrString("IMAGE_SCAN_DB_DIR", ""), "Offline trivy database dir containing db/trivy.db and optional java-db, default is .trivy under data dir")
+	signingKeyDir         = flag.String("signingKeyDir", LookupEnvOrString("SIGNING_KEY_DIR", ""), "Dir of image signing keys: <name>.key cosign private key, <name>.password key password and <name>.pub public key, default is .signing-keys under data dir")
+	imageCacheRepo        = flag.String("imageCacheRepo", LookupEnvOrString("IMAGE_CACHE_REPO", ""), "Registry repository prefix to export image build cache, default is the buildcache tag of built image")
 )
 
 func LookupEnvOrString(key string, defaultVal string) string {
@@ -51,6 +91,62 @@ func main() {
 	conf.AppConfig.DataDir = *dataDir
 	conf.AppConfig.CallbackEndpoint = *callbackEndpoint
 	conf.AppConfig.CallbackUrl = *callbackUrl
+	conf.AppConfig.ImageBuilder = *imageBuilder
+	conf.AppConfig.KanikoImage = *kanikoImage
+	conf.AppConfig.ImageCacheRepo = *imageCacheRepo
+	conf.AppConfig.DockerfileTemplateDir = *dockerfileTemplateDir
+	conf.AppConfig.ImageScanImage = *imageScanImage
+	conf.AppConfig.ImageScanDbDir = *imageScanDbDir
+	conf.AppConfig.SbomImage = *sbomImage
+	conf.AppConfig.SigningKeyDir = *signingKeyDir
+	conf.AppConfig.BuildCacheDir = *buildCacheDir
+	conf.AppConfig.BuildCacheMaxSize = int64(*buildCacheMaxSize) * 1024 * 1024
+	conf.AppConfig.BuildCacheLockTimeout = time.Duration(*buildCacheLockTimeout) * time.Minute
+	conf.AppConfig.ContainerLimits = conf.ContainerLimits{
+		Pids:           int64(*maxContainerPids),
+		Networks:       strings.Split(*containerNetworks, ","),
+		DefaultNetwork: *defaultNetwork,
+	}
+	if *maxContainerCpus != "" {
+		if conf.AppConfig.ContainerLimits.Cpus, err = strconv.ParseFloat(*maxContainerCpus, 64); err != nil {
+			panic(err)
+		}
+	}
+	if *maxContainerMemory != "" {
+		if conf.AppConfig.ContainerLimits.Memory, err = utils.ParseMemorySize(*maxContainerMemory); err != nil {
+			panic(err)
+		}
+	}
+	conf.AppConfig.ContainerUser = *containerUser
+	conf.AppConfig.CleanupImage = *cleanupImage
+	if *insecureRegistries != "" {
+		conf.AppConfig.InsecureRegistries = strings.Split(*insecureRegistries, ",")
+	}
+	conf.AppConfig.ContainerRuntime = *containerRuntime
+	conf.AppConfig.DockerHost = *dockerHost
+	conf.AppConfig.DockerCertPath = *dockerCertPath
+	conf.AppConfig.PodmanHost = *podmanHost
+	conf.AppConfig.PodmanCertPath = *podmanCertPath
+	conf.AppConfig.NerdctlPath = *nerdctlPath
+	conf.AppConfig.ContainerdAddress = *containerdAddress
+	conf.AppConfig.ContainerdNamespace = *containerdNamespace
+	conf.AppConfig.Artifact = conf.ArtifactConf{
+		Store:       *artifactStore,
+		Dir:         *artifactDir,
+		MaxSize:     int64(*artifactMaxSize) * 1024 * 1024,
+		S3Endpoint:  *artifactS3Endpoint,
+		S3Region:    *artifactS3Region,
+		S3Bucket:    *artifactS3Bucket,
+		S3AccessKey: *artifactS3AccessKey,
+		S3SecretKey: *artifactS3SecretKey,
+		S3PathStyle: *artifactS3PathStyle,
+	}
+	if err = plugins.InitContainerRuntimes(); err != nil {
+		panic(err)
+	}
+	if err = plugins.InitArtifactStore(); err != nil {
+		panic(err)
+	}
 	conf.AppConfig.CallbackClient, err = utils.NewHttpClient(*callbackEndpoint)
 	if err != nil {
 		panic(err)
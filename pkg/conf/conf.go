@@ -1,12 +1,100 @@
 package conf
 
-import "github.com/kubespace/pipeline-plugin/pkg/utils"
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"time"
+)
 
 type GlobalConf struct {
 	DataDir          string
 	CallbackEndpoint string
 	CallbackUrl      string
 	CallbackClient   *utils.HttpClient
+	// InsecureRegistries 使用 http 或自签名证书访问的镜像仓库
+	InsecureRegistries []string
+	// ImageBuilder 默认的镜像构建后端：docker、buildah、kaniko
+	ImageBuilder string
+	// KanikoImage kaniko 构建后端在容器运行时中运行的 executor 镜像
+	KanikoImage string
+	// ImageCacheRepo 镜像构建缓存默认推送的仓库，为空时推送到构建镜像的 buildcache tag
+	ImageCacheRepo string
+	// BuildCacheDir 代码构建依赖缓存目录，为空时为数据目录下的 .build-cache
+	BuildCacheDir string
+	// BuildCacheMaxSize 依赖缓存总大小上限，单位字节，小于等于 0 时不限制
+	BuildCacheMaxSize int64
+	// BuildCacheLockTimeout 等待其它任务释放缓存的超时时间
+	BuildCacheLockTimeout time.Duration
+	// ContainerLimits 构建及脚本容器的资源上限，任务参数不能超过该上限
+	ContainerLimits ContainerLimits
+	// ContainerUser 构建及脚本容器默认的运行用户，为空时使用插件服务的用户
+	ContainerUser string
+	// CleanupImage 清理工作目录的辅助容器镜像
+	CleanupImage string
+	// ContainerRuntime 任务未指定时使用的容器运行时：docker、podman、containerd
+	ContainerRuntime string
+	// DockerHost docker 运行时的 engine api 地址
+	DockerHost string
+	// DockerCertPath 通过 tcp 访问 docker 时使用的 TLS 证书目录
+	DockerCertPath string
+	// PodmanHost podman 运行时兼容 docker engine api 的地址
+	PodmanHost string
+	// PodmanCertPath 通过 tcp 访问 podman 时使用的 TLS 证书目录
+	PodmanCertPath string
+	// NerdctlPath containerd 运行时使用的 nerdctl 可执行文件路径
+	NerdctlPath string
+	// ContainerdAddress containerd 运行时的 socket 地址
+	ContainerdAddress string
+	// ContainerdNamespace containerd 运行时的命名空间
+	ContainerdNamespace string
+	// Artifact 构建产物存储配置
+	Artifact ArtifactConf
+	// DockerfileTemplateDir 自动生成 Dockerfile 的模板目录，目录中的 <项目类型>.Dockerfile 覆盖内置模板
+	DockerfileTemplateDir string
+	// ImageScanImage 镜像漏洞扫描使用的 trivy 镜像
+	ImageScanImage string
+	// ImageScanDbDir trivy 离线漏洞库目录，包含 db/trivy.db 及可选的 java-db，为空时为数据目录下的 .trivy
+	ImageScanDbDir string
+	// SbomImage 生成软件物料清单使用的 syft 镜像
+	SbomImage string
+	// SigningKeyDir 镜像签名密钥目录，任务参数只指定密钥名称，密钥不经过接口传递，为空时为数据目录下的 .signing-keys
+	SigningKeyDir string
+}
+
+// ContainerLimits 容器资源上限，为 0 时不限制
+type ContainerLimits struct {
+	Cpus   float64
+	Memory int64
+	Pids   int64
+	// Networks 允许任务使用的网络模式
+	Networks []string
+	// DefaultNetwork 任务未指定时使用的网络模式
+	DefaultNetwork string
+}
+
+// ArtifactConf 构建产物存储配置，Store 为 local 时保存在本地目录，为 s3 时保存在兼容 s3 的对象存储
+type ArtifactConf struct {
+	Store string
+	// Dir 本地存储目录，为空时为数据目录下的 .artifacts
+	Dir string
+	// MaxSize 单个产物归档的大小上限，单位字节，小于等于 0 时不限制
+	MaxSize     int64
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	// S3PathStyle 使用 endpoint/bucket/key 格式的地址，minio 等自建对象存储通常需要开启
+	S3PathStyle bool
 }
 
 var AppConfig = &GlobalConf{}
+
+// IsInsecureRegistry 判断镜像仓库是否配置为非安全仓库
+func (c *GlobalConf) IsInsecureRegistry(registry string) bool {
+	for _, r := range c.InsecureRegistries {
+		if r == registry {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package manager
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"gorm.io/gorm"
+	"time"
+)
+
+type Artifact struct {
+	DB *gorm.DB
+}
+
+func NewArtifactManager(db *gorm.DB) *Artifact {
+	return &Artifact{DB: db}
+}
+
+// Save 保存任务的构建产物，任务重新执行时覆盖同名产物
+func (a *Artifact) Save(artifact *types.PipelineJobArtifact) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_run_id = ? and name = ?", artifact.JobRunId, artifact.Name).Delete(&types.PipelineJobArtifact{}).Error; err != nil {
+			return err
+		}
+		artifact.CreateTime = time.Now()
+		artifact.UpdateTime = time.Now()
+		return tx.Create(artifact).Error
+	})
+}
+
+func (a *Artifact) List(jobRunId uint) ([]*types.PipelineJobArtifact, error) {
+	var artifacts []*types.PipelineJobArtifact
+	if err := a.DB.Where("job_run_id = ?", jobRunId).Order("id").Find(&artifacts).Error; err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+func (a *Artifact) Get(jobRunId uint, name string) (*types.PipelineJobArtifact, error) {
+	var artifact types.PipelineJobArtifact
+	if err := a.DB.Where("job_run_id = ? and name = ?", jobRunId, name).First(&artifact).Error; err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
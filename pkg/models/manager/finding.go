@@ -0,0 +1,41 @@
+package manager
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"gorm.io/gorm"
+	"time"
+)
+
+type Finding struct {
+	DB *gorm.DB
+}
+
+func NewFindingManager(db *gorm.DB) *Finding {
+	return &Finding{DB: db}
+}
+
+// Save 保存任务的代码扫描问题，任务重新执行时覆盖之前的结果
+func (f *Finding) Save(jobRunId uint, findings []*types.PipelineJobFinding) error {
+	return f.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_run_id = ?", jobRunId).Delete(&types.PipelineJobFinding{}).Error; err != nil {
+			return err
+		}
+		if len(findings) == 0 {
+			return nil
+		}
+		now := time.Now()
+		for _, finding := range findings {
+			finding.JobRunId = jobRunId
+			finding.CreateTime = now
+		}
+		return tx.CreateInBatches(findings, 100).Error
+	})
+}
+
+func (f *Finding) List(jobRunId uint) ([]*types.PipelineJobFinding, error) {
+	var findings []*types.PipelineJobFinding
+	if err := f.DB.Where("job_run_id = ?", jobRunId).Order("id").Find(&findings).Error; err != nil {
+		return nil, err
+	}
+	return findings, nil
+}
@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"fmt"
 	"github.com/kubespace/pipeline-plugin/pkg/models/types"
 	"gorm.io/gorm"
 	"time"
@@ -14,23 +15,39 @@ func NewReleaseManager(db *gorm.DB) *Release {
 	return &Release{DB: db}
 }
 
+// Add 记录空间发布的版本，同一任务重复执行时不重复记录，版本已被其它任务发布时返回错误，
+// 并发发布同一版本时由 (workspace_id, release_version) 唯一索引保证只有一个任务成功
 func (l *Release) Add(workspaceId uint, version string, jobRunId uint) error {
-	var cnt int64
-	if err := l.DB.Model(types.PipelineWorkspaceRelease{}).Where("job_run_id = ? and release_version = ?", jobRunId, version).Count(&cnt).Error; err != nil {
-		return err
-	}
-	if cnt > 0 {
+	return l.DB.Transaction(func(tx *gorm.DB) error {
+		var releases []types.PipelineWorkspaceRelease
+		if err := tx.Where("workspace_id = ? and release_version = ?", workspaceId, version).Find(&releases).Error; err != nil {
+			return err
+		}
+		if len(releases) > 0 {
+			if releases[0].JobRunId == jobRunId {
+				return nil
+			}
+			return fmt.Errorf("版本%s已发布", version)
+		}
+		var release = types.PipelineWorkspaceRelease{
+			WorkspaceId:    workspaceId,
+			ReleaseVersion: version,
+			JobRunId:       jobRunId,
+			CreateTime:     time.Now(),
+			UpdateTime:     time.Now(),
+		}
+		if err := tx.Create(&release).Error; err != nil {
+			return fmt.Errorf("记录发布版本%s失败：%v", version, err)
+		}
 		return nil
+	})
+}
+
+// Versions 空间已发布的所有版本号，不包含 excludeJobRunId 任务发布的版本，任务重新执行时可以重新计算版本
+func (l *Release) Versions(workspaceId uint, excludeJobRunId uint) ([]string, error) {
+	var versions []string
+	if err := l.DB.Model(types.PipelineWorkspaceRelease{}).Where("workspace_id = ? and job_run_id != ?", workspaceId, excludeJobRunId).Pluck("release_version", &versions).Error; err != nil {
+		return nil, err
 	}
-	var release = types.PipelineWorkspaceRelease{
-		WorkspaceId:    workspaceId,
-		ReleaseVersion: version,
-		JobRunId:       jobRunId,
-		CreateTime:     time.Now(),
-		UpdateTime:     time.Now(),
-	}
-	if err := l.DB.Create(&release).Error; err != nil {
-		return err
-	}
-	return nil
+	return versions, nil
 }
@@ -0,0 +1,57 @@
+package manager
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"gorm.io/gorm"
+	"time"
+)
+
+type TestReport struct {
+	DB *gorm.DB
+}
+
+func NewTestReportManager(db *gorm.DB) *TestReport {
+	return &TestReport{DB: db}
+}
+
+// Save 保存任务的测试报告及失败的测试用例，任务重新执行时覆盖之前的报告
+func (t *TestReport) Save(report *types.PipelineJobTestReport, cases []*types.PipelineJobTestCase) error {
+	return t.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_run_id = ?", report.JobRunId).Delete(&types.PipelineJobTestReport{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("job_run_id = ?", report.JobRunId).Delete(&types.PipelineJobTestCase{}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		report.CreateTime = now
+		report.UpdateTime = now
+		if err := tx.Create(report).Error; err != nil {
+			return err
+		}
+		if len(cases) == 0 {
+			return nil
+		}
+		for _, c := range cases {
+			c.JobRunId = report.JobRunId
+			c.CreateTime = now
+		}
+		return tx.CreateInBatches(cases, 100).Error
+	})
+}
+
+func (t *TestReport) Get(jobRunId uint) (*types.PipelineJobTestReport, error) {
+	var report types.PipelineJobTestReport
+	if err := t.DB.Where("job_run_id = ?", jobRunId).First(&report).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (t *TestReport) ListCases(jobRunId uint) ([]*types.PipelineJobTestCase, error) {
+	var cases []*types.PipelineJobTestCase
+	if err := t.DB.Where("job_run_id = ?", jobRunId).Order("id").Find(&cases).Error; err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
@@ -0,0 +1,41 @@
+package manager
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"gorm.io/gorm"
+	"time"
+)
+
+type Vulnerability struct {
+	DB *gorm.DB
+}
+
+func NewVulnerabilityManager(db *gorm.DB) *Vulnerability {
+	return &Vulnerability{DB: db}
+}
+
+// Save 保存任务镜像扫描发现的漏洞，任务重新执行时覆盖之前的结果
+func (v *Vulnerability) Save(jobRunId uint, vulns []*types.PipelineJobVulnerability) error {
+	return v.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_run_id = ?", jobRunId).Delete(&types.PipelineJobVulnerability{}).Error; err != nil {
+			return err
+		}
+		if len(vulns) == 0 {
+			return nil
+		}
+		now := time.Now()
+		for _, vuln := range vulns {
+			vuln.JobRunId = jobRunId
+			vuln.CreateTime = now
+		}
+		return tx.CreateInBatches(vulns, 100).Error
+	})
+}
+
+func (v *Vulnerability) List(jobRunId uint) ([]*types.PipelineJobVulnerability, error) {
+	var vulns []*types.PipelineJobVulnerability
+	if err := v.DB.Where("job_run_id = ?", jobRunId).Order("id").Find(&vulns).Error; err != nil {
+		return nil, err
+	}
+	return vulns, nil
+}
@@ -8,6 +8,10 @@ import (
 type models struct {
 	JobLogManager          *manager.JobLog
 	PipelineReleaseManager *manager.Release
+	ArtifactManager        *manager.Artifact
+	TestReportManager      *manager.TestReport
+	FindingManager         *manager.Finding
+	VulnerabilityManager   *manager.Vulnerability
 }
 
 var Models *models
@@ -19,8 +23,16 @@ func NewModels(mysqlOptions *mysql.Options) (*models, error) {
 	}
 	jobLog := manager.NewJobLogManager(db)
 	release := manager.NewReleaseManager(db)
+	artifact := manager.NewArtifactManager(db)
+	testReport := manager.NewTestReportManager(db)
+	finding := manager.NewFindingManager(db)
+	vulnerability := manager.NewVulnerabilityManager(db)
 	return &models{
 		JobLogManager:          jobLog,
 		PipelineReleaseManager: release,
+		ArtifactManager:        artifact,
+		TestReportManager:      testReport,
+		FindingManager:         finding,
+		VulnerabilityManager:   vulnerability,
 	}, nil
 }
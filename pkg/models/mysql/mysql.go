@@ -32,6 +32,11 @@ func DbMigrate(db *gorm.DB) error {
 	migrateTypes := []interface{}{
 		&types.PipelineRunJobLog{},
 		&types.PipelineWorkspaceRelease{},
+		&types.PipelineJobArtifact{},
+		&types.PipelineJobTestReport{},
+		&types.PipelineJobTestCase{},
+		&types.PipelineJobFinding{},
+		&types.PipelineJobVulnerability{},
 	}
 	for _, model := range migrateTypes {
 		err = db.AutoMigrate(model)
@@ -18,3 +18,79 @@ type PipelineWorkspaceRelease struct {
 	CreateTime     time.Time `gorm:"column:create_time;not null;autoCreateTime" json:"create_time"`
 	UpdateTime     time.Time `gorm:"column:update_time;not null;autoUpdateTime" json:"update_time"`
 }
+
+// PipelineJobArtifact 任务归档的构建产物，StoreKey 为产物在产物存储中的路径
+type PipelineJobArtifact struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	JobRunId   uint      `gorm:"not null;uniqueIndex:idx_job_artifact" json:"job_run_id"`
+	Name       string    `gorm:"size:255;not null;uniqueIndex:idx_job_artifact" json:"name"`
+	Store      string    `gorm:"size:50;not null" json:"store"`
+	StoreKey   string    `gorm:"size:500;not null" json:"-"`
+	Size       int64     `gorm:"not null" json:"size"`
+	Files      int       `gorm:"not null" json:"files"`
+	Sha256     string    `gorm:"size:64;not null" json:"sha256"`
+	CreateTime time.Time `gorm:"column:create_time;not null;autoCreateTime" json:"create_time"`
+	UpdateTime time.Time `gorm:"column:update_time;not null;autoUpdateTime" json:"update_time"`
+}
+
+// PipelineJobTestReport 代码构建的测试及覆盖率报告汇总，Coverage 为空表示没有覆盖率报告
+type PipelineJobTestReport struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	JobRunId       uint      `gorm:"not null;uniqueIndex" json:"job_run_id"`
+	Tests          int       `gorm:"not null" json:"tests"`
+	Failures       int       `gorm:"not null" json:"failures"`
+	Errors         int       `gorm:"not null" json:"errors"`
+	Skipped        int       `gorm:"not null" json:"skipped"`
+	Duration       float64   `gorm:"not null" json:"duration"`
+	CoverageFormat string    `gorm:"size:100" json:"coverage_format"`
+	LinesCovered   int64     `gorm:"not null" json:"lines_covered"`
+	LinesValid     int64     `gorm:"not null" json:"lines_valid"`
+	Coverage       *float64  `json:"coverage"`
+	CreateTime     time.Time `gorm:"column:create_time;not null;autoCreateTime" json:"create_time"`
+	UpdateTime     time.Time `gorm:"column:update_time;not null;autoUpdateTime" json:"update_time"`
+}
+
+// PipelineJobTestCase 代码构建测试报告中失败的测试用例
+type PipelineJobTestCase struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	JobRunId   uint      `gorm:"not null;index" json:"job_run_id"`
+	Suite      string    `gorm:"size:500" json:"suite"`
+	ClassName  string    `gorm:"size:500" json:"class_name"`
+	Name       string    `gorm:"size:500;not null" json:"name"`
+	Status     string    `gorm:"size:20;not null" json:"status"`
+	Message    string    `gorm:"type:text" json:"message"`
+	Output     string    `gorm:"type:mediumtext" json:"output"`
+	Duration   float64   `gorm:"not null" json:"duration"`
+	CreateTime time.Time `gorm:"column:create_time;not null;autoCreateTime" json:"create_time"`
+}
+
+// PipelineJobFinding 代码扫描任务发现的问题，IsNew 表示基线任务中不存在该问题
+type PipelineJobFinding struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	JobRunId    uint      `gorm:"not null;index" json:"job_run_id"`
+	Tool        string    `gorm:"size:255" json:"tool"`
+	RuleId      string    `gorm:"size:255" json:"rule_id"`
+	Severity    string    `gorm:"size:20;not null" json:"severity"`
+	File        string    `gorm:"size:1000" json:"file"`
+	Line        int       `gorm:"not null" json:"line"`
+	Message     string    `gorm:"type:text" json:"message"`
+	Fingerprint string    `gorm:"size:64;not null" json:"fingerprint"`
+	IsNew       bool      `gorm:"not null" json:"is_new"`
+	CreateTime  time.Time `gorm:"column:create_time;not null;autoCreateTime" json:"create_time"`
+}
+
+// PipelineJobVulnerability 代码构建任务镜像漏洞扫描发现的漏洞
+type PipelineJobVulnerability struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	JobRunId         uint      `gorm:"not null;index" json:"job_run_id"`
+	Image            string    `gorm:"size:500;not null" json:"image"`
+	Target           string    `gorm:"size:500" json:"target"`
+	VulnerabilityId  string    `gorm:"size:100;not null" json:"vulnerability_id"`
+	PkgName          string    `gorm:"size:255" json:"pkg_name"`
+	InstalledVersion string    `gorm:"size:255" json:"installed_version"`
+	FixedVersion     string    `gorm:"size:255" json:"fixed_version"`
+	Severity         string    `gorm:"size:20;not null" json:"severity"`
+	Title            string    `gorm:"type:text" json:"title"`
+	PrimaryUrl       string    `gorm:"size:1000" json:"primary_url"`
+	CreateTime       time.Time `gorm:"column:create_time;not null;autoCreateTime" json:"create_time"`
+}
@@ -0,0 +1,376 @@
+package plugins
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"k8s.io/klog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	artifactNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+	// artifactPathRe 产物路径只允许使用不需要转义的字符，主机资源通过远程 shell 展开 glob
+	artifactPathRe = regexp.MustCompile(`^[A-Za-z0-9._/*?@+=,-]+$`)
+)
+
+var errArtifactTooLarge = errors.New("artifact too large")
+
+// artifactExcludes 任务工作目录顶层由插件生成的文件，不会被归档，避免泄露镜像仓库认证等信息
+var artifactExcludes = map[string]bool{
+	".docker":    true,
+	".klog":      true,
+	".metadata":  true,
+	".artifacts": true,
+	".matrix":    true,
+	".inputs":    true,
+	".env":       true,
+	".script.sh": true,
+}
+
+// ArtifactResult 归档的构建产物信息
+type ArtifactResult struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Files  int    `json:"files"`
+	Sha256 string `json:"sha256"`
+}
+
+// validateArtifacts 校验产物参数
+func validateArtifacts(artifacts []serializers.Artifact) error {
+	names := make(map[string]bool)
+	for _, artifact := range artifacts {
+		if !artifactNameRe.MatchString(artifact.Name) {
+			return fmt.Errorf("产物名称%s不合法，只能包含字母、数字及._-", artifact.Name)
+		}
+		if names[artifact.Name] {
+			return fmt.Errorf("产物名称%s重复", artifact.Name)
+		}
+		names[artifact.Name] = true
+		if len(artifact.Paths) == 0 {
+			return fmt.Errorf("产物%s路径为空", artifact.Name)
+		}
+		for _, p := range artifact.Paths {
+			if !artifactPathRe.MatchString(p) {
+				return fmt.Errorf("产物%s路径%s不合法，只能包含字母、数字、glob 通配符及./_-@+=,", artifact.Name, p)
+			}
+			if err := checkRepoPath(p); err != nil {
+				return fmt.Errorf("产物%s路径错误：%v", artifact.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// artifactPatternRegexp 将 glob 路径转换为正则，* 与 ? 不匹配 /，** 匹配任意层目录，匹配目录时包含目录下的所有文件
+func artifactPatternRegexp(pattern string) *regexp.Regexp {
+	pattern = strings.TrimPrefix(path.Clean("/"+pattern), "/")
+	b := &strings.Builder{}
+	b.WriteString("^")
+	segments := strings.Split(pattern, "/")
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		if seg == "**" {
+			if last {
+				b.WriteString(".*")
+			} else {
+				b.WriteString("(?:.*/)?")
+			}
+			continue
+		}
+		for _, c := range seg {
+			switch c {
+			case '*':
+				b.WriteString("[^/]*")
+			case '?':
+				b.WriteString("[^/]")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		if !last {
+			b.WriteString("/")
+		}
+	}
+	b.WriteString("(?:/.*)?$")
+	return regexp.MustCompile(b.String())
+}
+
+// matchArtifactFiles 返回 baseDir 下匹配任一路径的普通文件，为 / 分隔的相对路径，
+// 不包含 .git 目录、插件生成的文件及符号链接，避免归档工作目录之外的文件
+func matchArtifactFiles(baseDir string, patterns []string) ([]string, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		res = append(res, artifactPatternRegexp(p))
+	}
+	var files []string
+	err := filepath.Walk(baseDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(baseDir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if artifactExcludes[rel] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		for _, re := range res {
+			if re.MatchString(rel) {
+				files = append(files, rel)
+				break
+			}
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// limitWriter 写入超过上限时返回 errArtifactTooLarge
+type limitWriter struct {
+	w     io.Writer
+	limit int64
+	n     int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	l.n += int64(len(p))
+	if l.limit > 0 && l.n > l.limit {
+		return 0, errArtifactTooLarge
+	}
+	return l.w.Write(p)
+}
+
+// writeArtifactArchive 将文件打包为 tar.gz，返回文件大小及 sha256
+func writeArtifactArchive(archive string, baseDir string, files []string) (int64, string, error) {
+	f, err := os.Create(archive)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	lw := &limitWriter{w: io.MultiWriter(f, hash), limit: conf.AppConfig.Artifact.MaxSize}
+	gw := gzip.NewWriter(lw)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		if err = addArtifactFile(tw, filepath.Join(baseDir, filepath.FromSlash(file)), file); err != nil {
+			return 0, "", err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return 0, "", err
+	}
+	if err = gw.Close(); err != nil {
+		return 0, "", err
+	}
+	return lw.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func addArtifactFile(tw *tar.Writer, file string, name string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// artifactKey 产物在产物存储中的路径
+func artifactKey(jobId uint, name string) string {
+	return fmt.Sprintf("jobs/%d/%s.tar.gz", jobId, name)
+}
+
+// archiveArtifact 归档一个产物并保存到产物存储，没有匹配的文件时返回 nil
+func (b *BasePlugin) archiveArtifact(baseDir string, artifact *serializers.Artifact) (*ArtifactResult, error) {
+	files, err := matchArtifactFiles(baseDir, artifact.Paths)
+	if err != nil {
+		return nil, fmt.Errorf("查找产物%s文件失败：%v", artifact.Name, err)
+	}
+	if len(files) == 0 {
+		if artifact.Optional {
+			b.Log("产物%s没有匹配的文件，跳过", artifact.Name)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("产物%s没有匹配的文件：%s", artifact.Name, strings.Join(artifact.Paths, ", "))
+	}
+	if Artifacts == nil {
+		return nil, fmt.Errorf("产物存储未初始化")
+	}
+	dir := filepath.Join(b.RootDir, ".artifacts")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	archive := filepath.Join(dir, artifact.Name+".tar.gz")
+	defer os.Remove(archive)
+	size, sum, err := writeArtifactArchive(archive, baseDir, files)
+	if errors.Is(err, errArtifactTooLarge) {
+		return nil, fmt.Errorf("产物%s超过大小上限%d字节", artifact.Name, conf.AppConfig.Artifact.MaxSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("打包产物%s失败：%v", artifact.Name, err)
+	}
+	key := artifactKey(b.JobId, artifact.Name)
+	if err = Artifacts.Put(key, archive, sum); err != nil {
+		klog.Errorf("job=%d put artifact %s error: %v", b.JobId, key, err)
+		return nil, fmt.Errorf("保存产物%s失败：%v", artifact.Name, err)
+	}
+	record := &types.PipelineJobArtifact{
+		JobRunId: b.JobId,
+		Name:     artifact.Name,
+		Store:    Artifacts.Name(),
+		StoreKey: key,
+		Size:     size,
+		Files:    len(files),
+		Sha256:   sum,
+	}
+	if err = models.Models.ArtifactManager.Save(record); err != nil {
+		klog.Errorf("job=%d save artifact %s error: %v", b.JobId, artifact.Name, err)
+		return nil, fmt.Errorf("保存产物%s记录失败：%v", artifact.Name, err)
+	}
+	b.Log("归档产物%s：%d个文件，%d字节，sha256:%s", artifact.Name, len(files), size, sum)
+	return &ArtifactResult{Name: artifact.Name, Size: size, Files: len(files), Sha256: sum}, nil
+}
+
+// collectArtifacts 归档 baseDir 下的所有产物，单个产物失败时继续归档其它产物，返回第一个错误
+func (b *BasePlugin) collectArtifacts(baseDir string, artifacts []serializers.Artifact) ([]*ArtifactResult, error) {
+	var results []*ArtifactResult
+	var firstErr error
+	for i := range artifacts {
+		result, err := b.archiveArtifact(baseDir, &artifacts[i])
+		if err != nil {
+			b.Log("%v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if result != nil {
+			results = append(results, result)
+		}
+	}
+	return results, firstErr
+}
+
+// extractArtifactTar 将产物 tar 解压到 dir 目录，只解压普通文件及目录，拒绝解压到 dir 之外或经过符号链接的路径，
+// 顶层目录在 reserved 中的文件同样拒绝解压
+func extractArtifactTar(r io.Reader, dir string, reserved map[string]bool) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file path %s in artifact tar", hdr.Name)
+		}
+		if reserved[strings.SplitN(name, "/", 2)[0]] {
+			return fmt.Errorf("reserved file path %s in artifact tar", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = checkNoSymlink(dir, name); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if hdr.Typeflag == tar.TypeDir {
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkNoSymlink 检查 dir 下 / 分隔的相对路径 name 中已存在的各级路径都不是符号链接，
+// 避免通过代码仓库中的符号链接写入 dir 之外的文件
+func checkNoSymlink(dir, name string) error {
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		current := filepath.Join(dir, filepath.FromSlash(strings.Join(segments[:i+1], "/")))
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("file path %s is a symlink", strings.Join(segments[:i+1], "/"))
+		}
+	}
+	return nil
+}
+
+// OpenArtifact 读取任务的构建产物
+func OpenArtifact(jobId uint, name string) (*types.PipelineJobArtifact, io.ReadCloser, int64, error) {
+	artifact, err := models.Models.ArtifactManager.Get(jobId, name)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if Artifacts == nil || Artifacts.Name() != artifact.Store {
+		return nil, nil, 0, fmt.Errorf("产物保存在%s存储中，当前服务未使用该存储", artifact.Store)
+	}
+	reader, size, err := Artifacts.Get(artifact.StoreKey)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return artifact, reader, size, nil
+}
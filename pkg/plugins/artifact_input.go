@@ -0,0 +1,142 @@
+package plugins
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"k8s.io/klog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// validateArtifactInputs 校验依赖的产物参数，jobId 为当前任务 id
+func validateArtifactInputs(jobId uint, inputs []serializers.ArtifactInput) error {
+	for _, input := range inputs {
+		if input.JobId == 0 {
+			return fmt.Errorf("依赖产物%s的任务 id 为空", input.Name)
+		}
+		if input.JobId == jobId {
+			return fmt.Errorf("不能依赖当前任务的产物%s", input.Name)
+		}
+		if !artifactNameRe.MatchString(input.Name) {
+			return fmt.Errorf("依赖产物名称%s不合法", input.Name)
+		}
+		if input.Path == "" {
+			continue
+		}
+		if err := checkRepoPath(input.Path); err != nil {
+			return fmt.Errorf("依赖产物%s解压路径错误：%v", input.Name, err)
+		}
+		if artifactExcludes[strings.SplitN(path.Clean(input.Path), "/", 2)[0]] {
+			return fmt.Errorf("依赖产物%s不能解压到%s", input.Name, input.Path)
+		}
+	}
+	return nil
+}
+
+// fetchArtifactInput 下载产物并解压到 dir 目录下的 input.Path 中，解压后校验产物的 sha256
+func fetchArtifactInput(input *serializers.ArtifactInput, dir string) (int, error) {
+	artifact, reader, _, err := OpenArtifact(input.JobId, input.Name)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	reserved := artifactExcludes
+	if input.Path != "" {
+		if err = checkNoSymlink(dir, path.Clean(input.Path)); err != nil {
+			return 0, err
+		}
+		dir = filepath.Join(dir, filepath.FromSlash(path.Clean(input.Path)))
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return 0, err
+		}
+		reserved = nil
+	}
+	hash := sha256.New()
+	tee := io.TeeReader(reader, hash)
+	gr, err := gzip.NewReader(tee)
+	if err != nil {
+		return 0, err
+	}
+	if err = extractArtifactTar(gr, dir, reserved); err != nil {
+		return 0, err
+	}
+	// 读取 tar 结束标记之后的剩余内容，保证摘要覆盖整个文件
+	if _, err = io.Copy(ioutil.Discard, tee); err != nil {
+		return 0, err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != artifact.Sha256 {
+		return 0, fmt.Errorf("sha256 mismatch, expected %s, got %s", artifact.Sha256, sum)
+	}
+	return artifact.Files, nil
+}
+
+// fetchInputs 任务执行前下载依赖的产物并解压到 dir 目录
+func (b *BasePlugin) fetchInputs(dir string, inputs []serializers.ArtifactInput) error {
+	for i := range inputs {
+		input := &inputs[i]
+		files, err := fetchArtifactInput(input, dir)
+		if err != nil {
+			klog.Errorf("job=%d fetch artifact %s of job %d error: %v", b.JobId, input.Name, input.JobId, err)
+			b.Log("获取任务%d的产物%s失败：%v", input.JobId, input.Name, err)
+			return fmt.Errorf("获取任务%d的产物%s失败：%v", input.JobId, input.Name, err)
+		}
+		dest := "."
+		if input.Path != "" {
+			dest = path.Clean(input.Path)
+		}
+		b.Log("获取任务%d的产物%s：%d个文件，解压到%s", input.JobId, input.Name, files, dest)
+	}
+	return nil
+}
+
+// uploadInputs 通过 sftp 将本地 localDir 目录下解压的依赖产物上传到远程主机 workDir 目录
+func (b *ExecShellPlugin) uploadInputs(client *ssh.Client, localDir, workDir string) error {
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("建立 sftp 连接失败：%v", err)
+	}
+	defer sftpClient.Close()
+	return filepath.Walk(localDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		remote := path.Join(workDir, filepath.ToSlash(rel))
+		if info.IsDir() {
+			return sftpClient.MkdirAll(remote)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return sftpUploadFile(sftpClient, file, remote, info.Mode().Perm())
+	})
+}
+
+func sftpUploadFile(client *sftp.Client, local, remote string, mode os.FileMode) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := client.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("create remote file %s error: %v", remote, err)
+	}
+	defer dst.Close()
+	if _, err = dst.ReadFrom(src); err != nil {
+		return fmt.Errorf("upload file %s error: %v", remote, err)
+	}
+	return client.Chmod(remote, mode)
+}
@@ -0,0 +1,252 @@
+package plugins
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ArtifactStoreLocal = "local"
+	ArtifactStoreS3    = "s3"
+)
+
+// ArtifactStore 构建产物存储，key 为 / 分隔的相对路径
+type ArtifactStore interface {
+	Name() string
+	// Put 保存文件，sha256 为文件内容的十六进制摘要
+	Put(key string, file string, sha256 string) error
+	// Get 读取产物内容，返回内容及大小
+	Get(key string) (io.ReadCloser, int64, error)
+}
+
+// Artifacts 服务使用的构建产物存储
+var Artifacts ArtifactStore
+
+// InitArtifactStore 根据服务配置初始化构建产物存储
+func InitArtifactStore() error {
+	c := conf.AppConfig.Artifact
+	switch c.Store {
+	case "", ArtifactStoreLocal:
+		dir := c.Dir
+		if dir == "" {
+			dir = conf.AppConfig.DataDir + "/.artifacts"
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("create artifact dir %s error: %v", dir, err)
+		}
+		Artifacts = &localArtifactStore{root: dir}
+	case ArtifactStoreS3:
+		if c.S3Endpoint == "" || c.S3Bucket == "" || c.S3AccessKey == "" || c.S3SecretKey == "" {
+			return fmt.Errorf("s3 artifact store requires endpoint, bucket, access key and secret key")
+		}
+		endpoint, err := url.Parse(strings.TrimSuffix(c.S3Endpoint, "/"))
+		if err != nil || endpoint.Host == "" {
+			return fmt.Errorf("s3 artifact store endpoint %s error", c.S3Endpoint)
+		}
+		region := c.S3Region
+		if region == "" {
+			region = "us-east-1"
+		}
+		Artifacts = &s3ArtifactStore{
+			endpoint:  endpoint,
+			region:    region,
+			bucket:    c.S3Bucket,
+			accessKey: c.S3AccessKey,
+			secretKey: c.S3SecretKey,
+			pathStyle: c.S3PathStyle,
+			client:    &http.Client{Timeout: 30 * time.Minute},
+		}
+	default:
+		return fmt.Errorf("unknown artifact store %s, available: local, s3", c.Store)
+	}
+	return nil
+}
+
+// localArtifactStore 将产物保存在本地目录
+type localArtifactStore struct {
+	root string
+}
+
+func (s *localArtifactStore) Name() string {
+	return ArtifactStoreLocal
+}
+
+func (s *localArtifactStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *localArtifactStore) Put(key string, file string, _ string) error {
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// 先写入临时文件再重命名，下载时不会读到写入一半的产物
+	tmp := dst + ".tmp"
+	if err := copyFile(file, tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (s *localArtifactStore) Get(key string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// s3ArtifactStore 将产物保存在兼容 s3 的对象存储中，请求使用 AWS Signature V4 签名
+type s3ArtifactStore struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+func (s *s3ArtifactStore) Name() string {
+	return ArtifactStoreS3
+}
+
+func (s *s3ArtifactStore) objectUrl(key string) *url.URL {
+	u := *s.endpoint
+	var segments []string
+	for _, seg := range strings.Split(key, "/") {
+		segments = append(segments, s3Escape(seg))
+	}
+	escaped := strings.Join(segments, "/")
+	if s.pathStyle {
+		u.Path = u.Path + "/" + s.bucket + "/" + key
+		u.RawPath = u.Path[:len(u.Path)-len(key)] + escaped
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = u.Path + "/" + key
+		u.RawPath = u.Path[:len(u.Path)-len(key)] + escaped
+	}
+	return &u
+}
+
+func (s *s3ArtifactStore) Put(key string, file string, sum string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectUrl(key).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+	s.sign(req, sum)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("put s3 object %s error: %s %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *s3ArtifactStore) Get(key string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectUrl(key).String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.sign(req, emptySha256)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, 0, os.ErrNotExist
+		}
+		return nil, 0, fmt.Errorf("get s3 object %s error: %s %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+const emptySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign 使用 AWS Signature V4 为请求签名，payloadHash 为请求体的 sha256
+func (s *s3ArtifactStore) sign(req *http.Request, payloadHash string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSha256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape 按 AWS 签名规则编码路径中的一段，只保留非保留字符
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
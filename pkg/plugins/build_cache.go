@@ -0,0 +1,505 @@
+package plugins
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"io/fs"
+	"k8s.io/klog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+const (
+	buildCacheDataDir  = "data"
+	buildCacheLockFile = ".lock"
+	buildCacheMetaFile = ".meta"
+)
+
+var invalidCacheKeyRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// BuildCacheManager 管理代码构建容器的依赖缓存目录，如 maven ~/.m2、npm、go mod、pip 缓存
+// 缓存按 key 保存在数据目录下，通过文件锁保证同一缓存同时只被一个任务使用，超过容量上限时按最近使用时间淘汰
+type BuildCacheManager struct {
+	mu sync.Mutex
+}
+
+var BuildCaches = &BuildCacheManager{}
+
+// BuildCacheInfo 缓存信息
+type BuildCacheInfo struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+	InUse    bool      `json:"in_use"`
+}
+
+// BuildCacheMount 任务使用的缓存，构建结束后需调用 Release 释放
+type BuildCacheMount struct {
+	Key     string
+	HostDir string
+	Path    string
+	lock    *os.File
+}
+
+func (m *BuildCacheManager) rootDir() string {
+	if conf.AppConfig.BuildCacheDir != "" {
+		return conf.AppConfig.BuildCacheDir
+	}
+	return conf.AppConfig.DataDir + "/.build-cache"
+}
+
+// RenderCacheKey 渲染缓存 key 模板，如：maven-{{ hashFiles "pom.xml" }}，hashFiles 路径相对于代码目录，
+// 支持 * 等通配符及匹配任意层目录的 **，如：{{ hashFiles "**/package-lock.json" }}。
+// scope 为缓存所属的空间等隔离范围，作为 key 的前缀，不同范围的任务不共享缓存
+func RenderCacheKey(scope string, keyTemplate string, codeDir string) (string, error) {
+	tmpl, err := template.New("key").Funcs(template.FuncMap{
+		"hashFiles": func(patterns ...string) (string, error) {
+			return hashFiles(codeDir, patterns...)
+		},
+	}).Parse(keyTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parse cache key %s error: %v", keyTemplate, err)
+	}
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, nil); err != nil {
+		return "", fmt.Errorf("render cache key %s error: %v", keyTemplate, err)
+	}
+	key := strings.Trim(invalidCacheKeyRe.ReplaceAllString(buf.String(), "_"), "._")
+	if key == "" {
+		return "", fmt.Errorf("cache key %s is empty", keyTemplate)
+	}
+	if len(key) > 128 {
+		key = key[:128]
+	}
+	return scope + "-" + key, nil
+}
+
+// hashFiles 计算匹配文件内容的 sha256，文件不存在时返回空字符串，
+// 路径不能是绝对路径或包含 ..，匹配的文件经符号链接解析后需位于代码目录中
+func hashFiles(dir string, patterns ...string) (string, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	matched := make(map[string]bool)
+	for _, pattern := range patterns {
+		segments, err := splitFilePattern(pattern)
+		if err != nil {
+			return "", err
+		}
+		files, err := matchFiles(root, segments)
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			matched[file] = true
+		}
+	}
+	if len(matched) == 0 {
+		return "", nil
+	}
+	var files []string
+	for file := range matched {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:16], nil
+}
+
+// splitFilePattern 校验 hashFiles 路径并按目录拆分
+func splitFilePattern(pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(pattern)
+	if pattern == "" || path.IsAbs(pattern) {
+		return nil, fmt.Errorf("hashFiles 路径%s错误，需为代码目录中的相对路径", pattern)
+	}
+	var segments []string
+	for _, segment := range strings.Split(pattern, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return nil, fmt.Errorf("hashFiles 路径%s错误，不能包含 ..", pattern)
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("hashFiles 路径%s错误：%v", pattern, err)
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("hashFiles 路径%s错误，需为代码目录中的相对路径", pattern)
+	}
+	return segments, nil
+}
+
+// matchFiles 返回代码目录中与路径匹配的普通文件，包含 ** 时遍历代码目录，不进入 .git 目录
+func matchFiles(root string, segments []string) ([]string, error) {
+	var candidates []string
+	recursive := false
+	for _, segment := range segments {
+		recursive = recursive || segment == "**"
+	}
+	if !recursive {
+		matches, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(path.Join(segments...))))
+		if err != nil {
+			return nil, err
+		}
+		candidates = matches
+	} else {
+		err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, err := filepath.Rel(root, file)
+			if err == nil && matchSegments(segments, strings.Split(filepath.ToSlash(rel), "/")) {
+				candidates = append(candidates, file)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var files []string
+	for _, candidate := range candidates {
+		file, err := filepath.EvalSymlinks(candidate)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("hashFiles 文件%s不在代码目录中", candidate)
+		}
+		if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// matchSegments 按目录逐级匹配路径，** 匹配零或多级目录
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Acquire 获取缓存目录并加锁，缓存被其它任务使用时等待，超时后返回错误
+func (m *BuildCacheManager) Acquire(key string, timeout time.Duration, waitLog func()) (*BuildCacheMount, error) {
+	cacheDir := filepath.Join(m.rootDir(), key)
+	deadline := time.Now().Add(timeout)
+	logged := false
+	for {
+		lock, err := m.tryLock(cacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("lock cache %s error: %v", key, err)
+		}
+		if lock != nil {
+			return &BuildCacheMount{Key: key, HostDir: filepath.Join(cacheDir, buildCacheDataDir), lock: lock}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("等待缓存%s释放超时", key)
+		}
+		if !logged && waitLog != nil {
+			waitLog()
+			logged = true
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// tryLock 创建缓存目录并尝试对锁文件加锁，缓存被其它任务使用时返回 nil。
+// 持有 m.mu 与 purge 互斥，避免锁文件在打开与加锁之间被删除；
+// 加锁后校验锁文件仍是路径上的文件，锁文件已被其它进程删除时同样返回 nil 等待重试
+func (m *BuildCacheManager) tryLock(cacheDir string) (*os.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(filepath.Join(cacheDir, buildCacheDataDir), 0755); err != nil {
+		return nil, fmt.Errorf("mkdir cache dir %s error: %v", cacheDir, err)
+	}
+	lockPath := filepath.Join(cacheDir, buildCacheLockFile)
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open cache lock error: %v", err)
+	}
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !sameFile(lock, lockPath) {
+		syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		lock.Close()
+		return nil, nil
+	}
+	return lock, nil
+}
+
+// sameFile 打开的文件是否仍是 path 指向的文件
+func sameFile(f *os.File, path string) bool {
+	openInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(openInfo, pathInfo)
+}
+
+// Release 更新缓存使用信息并释放锁
+func (m *BuildCacheManager) Release(mount *BuildCacheMount) {
+	meta := &BuildCacheInfo{Key: mount.Key, Size: dirSize(mount.HostDir), LastUsed: time.Now()}
+	if metaBytes, err := json.Marshal(meta); err == nil {
+		metaFile := filepath.Join(filepath.Dir(mount.HostDir), buildCacheMetaFile)
+		if err = os.WriteFile(metaFile, metaBytes, 0644); err != nil {
+			klog.Errorf("write cache %s meta error: %v", mount.Key, err)
+		}
+	}
+	syscall.Flock(int(mount.lock.Fd()), syscall.LOCK_UN)
+	mount.lock.Close()
+}
+
+// List 列出所有缓存
+func (m *BuildCacheManager) List() ([]*BuildCacheInfo, error) {
+	entries, err := os.ReadDir(m.rootDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var caches []*BuildCacheInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		cacheDir := filepath.Join(m.rootDir(), entry.Name())
+		info := &BuildCacheInfo{Key: entry.Name()}
+		if metaBytes, err := os.ReadFile(filepath.Join(cacheDir, buildCacheMetaFile)); err == nil {
+			_ = json.Unmarshal(metaBytes, info)
+		} else {
+			info.Size = dirSize(filepath.Join(cacheDir, buildCacheDataDir))
+		}
+		info.InUse = m.inUse(cacheDir)
+		caches = append(caches, info)
+	}
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].LastUsed.After(caches[j].LastUsed)
+	})
+	return caches, nil
+}
+
+// inUse 判断缓存是否正在被任务使用
+func (m *BuildCacheManager) inUse(cacheDir string) bool {
+	lock, err := os.OpenFile(filepath.Join(cacheDir, buildCacheLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return true
+	}
+	syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+// Purge 删除指定缓存，缓存正在使用时返回错误
+func (m *BuildCacheManager) Purge(key string) error {
+	if key == "" || key != invalidCacheKeyRe.ReplaceAllString(key, "_") {
+		return fmt.Errorf("invalid cache key %s", key)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.purge(key, nil)
+}
+
+// purge 删除缓存，rt 为辅助容器使用的容器运行时，为空时使用默认运行时
+func (m *BuildCacheManager) purge(key string, rt ContainerRuntime) error {
+	cacheDir := filepath.Join(m.rootDir(), key)
+	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
+		return fmt.Errorf("cache %s not found", key)
+	}
+	lock, err := os.OpenFile(filepath.Join(cacheDir, buildCacheLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return fmt.Errorf("cache %s is in use", key)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	if err = os.RemoveAll(cacheDir); err != nil {
+		// 缓存文件由容器内 root 用户创建时，通过辅助容器删除
+		if helperErr := removeByHelperContainer(rt, cacheDir, buildCacheLockFile); helperErr != nil {
+			return fmt.Errorf("remove cache %s error: %v; %v", key, err, helperErr)
+		}
+		os.RemoveAll(cacheDir)
+	}
+	klog.Infof("purged build cache %s", key)
+	return nil
+}
+
+// PurgeAll 删除所有未被使用的缓存
+func (m *BuildCacheManager) PurgeAll() error {
+	caches, err := m.List()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, cache := range caches {
+		if cache.InUse {
+			continue
+		}
+		if err = m.purge(cache.Key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evict 缓存总大小超过上限时，按最近使用时间从旧到新淘汰未被使用的缓存，rt 为任务使用的容器运行时
+func (m *BuildCacheManager) Evict(rt ContainerRuntime) {
+	maxSize := conf.AppConfig.BuildCacheMaxSize
+	if maxSize <= 0 {
+		return
+	}
+	caches, err := m.List()
+	if err != nil {
+		klog.Errorf("list build caches error: %v", err)
+		return
+	}
+	var total int64
+	for _, cache := range caches {
+		total += cache.Size
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(caches) - 1; i >= 0 && total > maxSize; i-- {
+		if caches[i].InUse {
+			continue
+		}
+		if err = m.purge(caches[i].Key, rt); err != nil {
+			klog.Errorf("evict build cache %s error: %v", caches[i].Key, err)
+			continue
+		}
+		total -= caches[i].Size
+	}
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// mountBuildCaches 获取任务声明的所有缓存目录，返回挂载到构建容器的缓存
+func (b *CodeBuilderPlugin) mountBuildCaches(caches []serializers.BuildCache) ([]*BuildCacheMount, error) {
+	var mounts []*BuildCacheMount
+	// 同一任务中的缓存 key 及目录不能重复，相同 key 会等待任务自身持有的缓存锁直到超时
+	keys := make(map[string]string)
+	paths := make(map[string]bool)
+	for _, cache := range caches {
+		if !filepath.IsAbs(cache.Path) || filepath.Clean(cache.Path) == "/" || filepath.Clean(cache.Path) == "/app" {
+			b.releaseBuildCaches(mounts)
+			return nil, fmt.Errorf("缓存目录%s错误，需为容器内的绝对路径", cache.Path)
+		}
+		if paths[filepath.Clean(cache.Path)] {
+			b.releaseBuildCaches(mounts)
+			return nil, fmt.Errorf("缓存目录%s重复", cache.Path)
+		}
+		paths[filepath.Clean(cache.Path)] = true
+		key, err := RenderCacheKey(b.buildCacheScope(), cache.Key, b.CodeDir)
+		if err != nil {
+			b.releaseBuildCaches(mounts)
+			return nil, err
+		}
+		if existing, ok := keys[key]; ok {
+			b.releaseBuildCaches(mounts)
+			return nil, fmt.Errorf("缓存目录%s与%s的缓存 key 相同：%s，请使用不同的 key", cache.Path, existing, key)
+		}
+		keys[key] = cache.Path
+		mount, err := BuildCaches.Acquire(key, conf.AppConfig.BuildCacheLockTimeout, func() {
+			b.Log("缓存 %s 正在被其它任务使用，等待释放", key)
+		})
+		if err != nil {
+			b.releaseBuildCaches(mounts)
+			return nil, err
+		}
+		mount.Path = cache.Path
+		b.Log("使用缓存 %s -> %s", key, cache.Path)
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
+
+// buildCacheScope 构建缓存的隔离范围，按空间隔离，未指定空间时按代码仓库隔离
+func (b *CodeBuilderPlugin) buildCacheScope() string {
+	if b.Params.WorkspaceId > 0 {
+		return fmt.Sprintf("ws%d", b.Params.WorkspaceId)
+	}
+	return fmt.Sprintf("repo%x", sha256.Sum256([]byte(b.Params.CodeUrl)))[:16]
+}
+
+func (b *CodeBuilderPlugin) releaseBuildCaches(mounts []*BuildCacheMount) {
+	if len(mounts) == 0 {
+		return
+	}
+	for _, mount := range mounts {
+		BuildCaches.Release(mount)
+	}
+	BuildCaches.Evict(b.Runtime)
+}
@@ -0,0 +1,454 @@
+package plugins
+
+import (
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultBuildDefinition 代码仓库中默认的构建定义文件
+	DefaultBuildDefinition = ".kubespace/build.yaml"
+
+	DefinitionPrecedencePipeline   = "pipeline"
+	DefinitionPrecedenceRepository = "repository"
+
+	buildDefinitionVersion = 1
+	// maxBuildDefinitionSize 构建定义文件大小上限
+	maxBuildDefinitionSize = 1 << 20
+)
+
+var platformRe = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// buildDefinition 代码仓库中的构建定义，如：
+//
+//	version: 1
+//	build:
+//	  image: golang:1.16
+//	  script: |
+//	    go test -coverprofile=coverage.out ./... && go build -o app .
+//	  caches:
+//	    - path: /root/go/pkg/mod
+//	      key: go-{{ hashFiles "go.sum" }}
+//	  reports:
+//	    coverage: [coverage.out]
+//	    coverage_threshold: 60
+//	images:
+//	  - image: kubespace/app
+//	    dockerfile: Dockerfile
+//	    platforms: [linux/amd64, linux/arm64]
+//	artifacts:
+//	  - name: reports
+//	    paths: ["target/surefire-reports/**"]
+type buildDefinition struct {
+	Version int                    `yaml:"version"`
+	Build   *buildDefinitionBuild  `yaml:"build,omitempty"`
+	Images  []buildDefinitionImage `yaml:"images,omitempty"`
+	// Artifacts 代码构建结束后归档的产物
+	Artifacts []buildDefinitionArtifact `yaml:"artifacts,omitempty"`
+}
+
+type buildDefinitionArtifact struct {
+	Name     string   `yaml:"name"`
+	Paths    []string `yaml:"paths"`
+	Optional bool     `yaml:"optional,omitempty"`
+}
+
+type buildDefinitionBuild struct {
+	Image     string                    `yaml:"image,omitempty"`
+	Exec      string                    `yaml:"exec,omitempty"`
+	Script    string                    `yaml:"script,omitempty"`
+	File      string                    `yaml:"file,omitempty"`
+	Caches    []buildDefinitionCache    `yaml:"caches,omitempty"`
+	Resources *buildDefinitionResources `yaml:"resources,omitempty"`
+	Services  []buildDefinitionService  `yaml:"services,omitempty"`
+	Matrix    *buildDefinitionMatrix    `yaml:"matrix,omitempty"`
+	Reports   *buildDefinitionReports   `yaml:"reports,omitempty"`
+}
+
+type buildDefinitionReports struct {
+	JUnit             []string `yaml:"junit,omitempty"`
+	Coverage          []string `yaml:"coverage,omitempty"`
+	CoverageFormat    string   `yaml:"coverage_format,omitempty"`
+	CoverageThreshold float64  `yaml:"coverage_threshold,omitempty"`
+}
+
+type buildDefinitionCache struct {
+	Path string `yaml:"path"`
+	Key  string `yaml:"key"`
+}
+
+type buildDefinitionResources struct {
+	Cpus     string `yaml:"cpus,omitempty"`
+	Memory   string `yaml:"memory,omitempty"`
+	Pids     int64  `yaml:"pids,omitempty"`
+	Network  string `yaml:"network,omitempty"`
+	ReadOnly bool   `yaml:"read_only,omitempty"`
+	User     string `yaml:"user,omitempty"`
+}
+
+type buildDefinitionService struct {
+	Image       string                      `yaml:"image"`
+	Alias       string                      `yaml:"alias,omitempty"`
+	Env         map[string]string           `yaml:"env,omitempty"`
+	Command     []string                    `yaml:"command,omitempty"`
+	HealthCheck *buildDefinitionHealthCheck `yaml:"health_check,omitempty"`
+}
+
+type buildDefinitionHealthCheck struct {
+	Cmd         string `yaml:"cmd"`
+	Interval    int    `yaml:"interval,omitempty"`
+	Timeout     int    `yaml:"timeout,omitempty"`
+	Retries     int    `yaml:"retries,omitempty"`
+	StartPeriod int    `yaml:"start_period,omitempty"`
+}
+
+type buildDefinitionMatrix struct {
+	Images      []string            `yaml:"images,omitempty"`
+	Envs        []map[string]string `yaml:"envs,omitempty"`
+	FailFast    bool                `yaml:"fail_fast,omitempty"`
+	MaxParallel int                 `yaml:"max_parallel,omitempty"`
+}
+
+type buildDefinitionImage struct {
+	Image      string                     `yaml:"image"`
+	Dockerfile string                     `yaml:"dockerfile,omitempty"`
+	Platforms  []string                   `yaml:"platforms,omitempty"`
+	Cache      *buildDefinitionImageCache `yaml:"cache,omitempty"`
+}
+
+type buildDefinitionImageCache struct {
+	Mode string   `yaml:"mode"`
+	Ref  string   `yaml:"ref,omitempty"`
+	From []string `yaml:"from,omitempty"`
+}
+
+// validateDefinitionParams 校验构建定义文件相关的请求参数
+func validateDefinitionParams(ser *serializers.BuildCodeToImageSerializer) error {
+	switch ser.CodeBuildDefinitionPrecedence {
+	case "", DefinitionPrecedencePipeline, DefinitionPrecedenceRepository:
+	default:
+		return fmt.Errorf("构建定义优先级%s错误，只支持 pipeline、repository", ser.CodeBuildDefinitionPrecedence)
+	}
+	if ser.CodeBuildDefinition != "" {
+		if err := checkRepoPath(ser.CodeBuildDefinition); err != nil {
+			return fmt.Errorf("构建定义文件路径错误：%v", err)
+		}
+	}
+	return nil
+}
+
+// checkRepoPath 校验代码仓库中的文件路径，只允许使用仓库内的相对路径
+func checkRepoPath(path string) error {
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("%s 不能为绝对路径", path)
+	}
+	clean := filepath.Clean(path)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("%s 不能指向代码仓库之外", path)
+	}
+	return nil
+}
+
+// parseBuildDefinition 解析并按 schema 校验构建定义，不允许出现未定义的字段
+func parseBuildDefinition(content []byte) (*buildDefinition, error) {
+	def := &buildDefinition{}
+	if err := yaml.UnmarshalStrict(content, def); err != nil {
+		return nil, err
+	}
+	if def.Version != buildDefinitionVersion {
+		return nil, fmt.Errorf("version 必须为 %d", buildDefinitionVersion)
+	}
+	if build := def.Build; build != nil {
+		if build.Script != "" && build.File != "" {
+			return nil, fmt.Errorf("build.script 与 build.file 不能同时配置")
+		}
+		if build.File != "" {
+			if err := checkRepoPath(build.File); err != nil {
+				return nil, fmt.Errorf("build.file: %v", err)
+			}
+		}
+		for i, cache := range build.Caches {
+			if cache.Path == "" || cache.Key == "" {
+				return nil, fmt.Errorf("build.caches[%d] 的 path 与 key 不能为空", i)
+			}
+		}
+		for i, service := range build.Services {
+			if service.Image == "" {
+				return nil, fmt.Errorf("build.services[%d].image 不能为空", i)
+			}
+			if service.HealthCheck != nil && service.HealthCheck.Cmd == "" {
+				return nil, fmt.Errorf("build.services[%d].health_check.cmd 不能为空", i)
+			}
+		}
+		if matrix := build.Matrix; matrix != nil {
+			for i, image := range matrix.Images {
+				if image == "" {
+					return nil, fmt.Errorf("build.matrix.images[%d] 不能为空", i)
+				}
+			}
+		}
+	}
+	for i, image := range def.Images {
+		if image.Image == "" {
+			return nil, fmt.Errorf("images[%d].image 不能为空", i)
+		}
+		if image.Dockerfile != "" {
+			if err := checkRepoPath(image.Dockerfile); err != nil {
+				return nil, fmt.Errorf("images[%d].dockerfile: %v", i, err)
+			}
+		}
+		for _, platform := range image.Platforms {
+			if !platformRe.MatchString(platform) {
+				return nil, fmt.Errorf("images[%d].platforms: %s 格式错误，需为 os/arch[/variant]", i, platform)
+			}
+		}
+		if cache := image.Cache; cache != nil && cache.Mode != ImageBuildCacheInline && cache.Mode != ImageBuildCacheRegistry {
+			return nil, fmt.Errorf("images[%d].cache.mode 只支持 inline、registry", i)
+		}
+	}
+	return def, nil
+}
+
+// loadBuildDefinition 读取代码仓库中的构建定义文件，与流水线配置合并后重新校验，并输出生效的构建配置
+func (b *CodeBuilderPlugin) loadBuildDefinition() error {
+	path := b.Params.CodeBuildDefinition
+	if path == "" {
+		path = DefaultBuildDefinition
+	}
+	file := filepath.Join(b.CodeDir, path)
+	info, err := os.Lstat(file)
+	if os.IsNotExist(err) {
+		b.Log("代码仓库中未找到构建定义文件%s，使用流水线配置", path)
+		return nil
+	}
+	if err != nil {
+		b.Log("读取构建定义文件%s失败：%v", path, err)
+		return fmt.Errorf("读取构建定义文件%s失败：%v", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		b.Log("构建定义文件%s不是普通文件", path)
+		return fmt.Errorf("构建定义文件%s不是普通文件", path)
+	}
+	if info.Size() > maxBuildDefinitionSize {
+		b.Log("构建定义文件%s超过大小上限%d字节", path, maxBuildDefinitionSize)
+		return fmt.Errorf("构建定义文件%s超过大小上限", path)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		b.Log("读取构建定义文件%s失败：%v", path, err)
+		return fmt.Errorf("读取构建定义文件%s失败：%v", path, err)
+	}
+	def, err := parseBuildDefinition(content)
+	if err != nil {
+		b.Log("构建定义文件%s校验失败：%v", path, err)
+		klog.Errorf("job=%d parse build definition %s error: %v", b.JobId, path, err)
+		return fmt.Errorf("构建定义文件%s校验失败：%v", path, err)
+	}
+	precedence := b.Params.CodeBuildDefinitionPrecedence
+	if precedence == "" {
+		precedence = DefinitionPrecedencePipeline
+	}
+	b.Log("读取构建定义文件%s，优先级：%s", path, precedence)
+	b.mergeBuildDefinition(def, precedence == DefinitionPrecedenceRepository)
+	if err = b.validateParams(); err != nil {
+		b.Log("合并构建定义后参数校验失败：%v", err)
+		return err
+	}
+	effective, err := yaml.Marshal(b.effectiveDefinition())
+	if err == nil {
+		b.Log("生效的构建配置：\n%s", effective)
+	}
+	return nil
+}
+
+// mergeBuildDefinition 按配置项合并构建定义，preferRepo 为 true 时构建定义文件中已配置的项覆盖流水线配置，
+// 否则只使用构建定义文件补充流水线未配置的项
+func (b *CodeBuilderPlugin) mergeBuildDefinition(def *buildDefinition, preferRepo bool) {
+	p := b.Params
+	useRepo := func(pipelineSet, repoSet bool) bool {
+		return repoSet && (preferRepo || !pipelineSet)
+	}
+	if build := def.Build; build != nil {
+		if useRepo(p.CodeBuildImage.Value != "", build.Image != "") {
+			p.CodeBuildImage = serializers.PipelineResource{Type: ResourceTypeImage, Value: build.Image}
+		}
+		if useRepo(p.CodeBuildExec != "", build.Exec != "") {
+			p.CodeBuildExec = build.Exec
+		}
+		// 流水线显式配置不构建代码时同样视为已配置
+		pipelineScript := p.CodeBuildType == CodeBuildTypeNone ||
+			p.CodeBuild && (p.CodeBuildScript != "" && p.CodeBuildType != CodeBuildTypeFile || p.CodeBuildFile != "" && p.CodeBuildType == CodeBuildTypeFile)
+		if useRepo(pipelineScript, build.Script != "" || build.File != "") {
+			p.CodeBuild = true
+			if build.Script != "" {
+				p.CodeBuildType, p.CodeBuildScript = CodeBuildTypeScript, build.Script
+			} else {
+				p.CodeBuildType, p.CodeBuildFile = CodeBuildTypeFile, build.File
+			}
+		}
+		if useRepo(len(p.CodeBuildCaches) > 0, len(build.Caches) > 0) {
+			p.CodeBuildCaches = nil
+			for _, cache := range build.Caches {
+				p.CodeBuildCaches = append(p.CodeBuildCaches, serializers.BuildCache{Path: cache.Path, Key: cache.Key})
+			}
+		}
+		if res := build.Resources; res != nil {
+			r := &p.CodeBuildResources
+			if useRepo(r.Cpus != "", res.Cpus != "") {
+				r.Cpus = res.Cpus
+			}
+			if useRepo(r.Memory != "", res.Memory != "") {
+				r.Memory = res.Memory
+			}
+			if useRepo(r.Pids != 0, res.Pids != 0) {
+				r.Pids = res.Pids
+			}
+			if useRepo(r.Network != "", res.Network != "") {
+				r.Network = res.Network
+			}
+			if useRepo(r.User != "", res.User != "") {
+				r.User = res.User
+			}
+			// 只读根文件系统只能开启，不能被另一方关闭
+			r.ReadOnly = r.ReadOnly || res.ReadOnly
+		}
+		if useRepo(len(p.Services) > 0, len(build.Services) > 0) {
+			p.Services = nil
+			for _, service := range build.Services {
+				s := serializers.BuildService{Image: service.Image, Alias: service.Alias, Env: service.Env, Command: service.Command}
+				if hc := service.HealthCheck; hc != nil {
+					s.HealthCheck = &serializers.ServiceHealthCheck{
+						Cmd: hc.Cmd, Interval: hc.Interval, Timeout: hc.Timeout, Retries: hc.Retries, StartPeriod: hc.StartPeriod,
+					}
+				}
+				p.Services = append(p.Services, s)
+			}
+		}
+		if useRepo(p.CodeBuildMatrix != nil, build.Matrix != nil) {
+			matrix := &serializers.CodeBuildMatrix{
+				Envs:        build.Matrix.Envs,
+				FailFast:    build.Matrix.FailFast,
+				MaxParallel: build.Matrix.MaxParallel,
+			}
+			for _, image := range build.Matrix.Images {
+				matrix.Images = append(matrix.Images, serializers.PipelineResource{Type: ResourceTypeImage, Value: image})
+			}
+			p.CodeBuildMatrix = matrix
+		}
+		if useRepo(p.CodeBuildReports != nil, build.Reports != nil) {
+			r := build.Reports
+			p.CodeBuildReports = &serializers.CodeBuildReports{
+				JUnit:             r.JUnit,
+				Coverage:          r.Coverage,
+				CoverageFormat:    r.CoverageFormat,
+				CoverageThreshold: r.CoverageThreshold,
+			}
+		}
+	}
+	if useRepo(len(p.Artifacts) > 0, len(def.Artifacts) > 0) {
+		p.Artifacts = nil
+		for _, artifact := range def.Artifacts {
+			p.Artifacts = append(p.Artifacts, serializers.Artifact{Name: artifact.Name, Paths: artifact.Paths, Optional: artifact.Optional})
+		}
+	}
+	if useRepo(len(p.ImageBuilds) > 0, len(def.Images) > 0) {
+		p.ImageBuilds = nil
+		for _, image := range def.Images {
+			build := serializers.ImageBuilds{Image: image.Image, Dockerfile: image.Dockerfile, Platforms: image.Platforms}
+			if cache := image.Cache; cache != nil {
+				build.Cache = &serializers.ImageBuildCache{Mode: cache.Mode, Ref: cache.Ref, From: cache.From}
+			}
+			p.ImageBuilds = append(p.ImageBuilds, build)
+		}
+	}
+}
+
+// effectiveDefinition 将合并后的构建参数转换为构建定义格式用于日志输出，不包含任何密钥
+func (b *CodeBuilderPlugin) effectiveDefinition() *buildDefinition {
+	p := b.Params
+	def := &buildDefinition{Version: buildDefinitionVersion}
+	if p.CodeBuild && p.CodeBuildType != CodeBuildTypeNone {
+		build := &buildDefinitionBuild{Image: p.CodeBuildImage.Value, Exec: p.CodeBuildExec}
+		if p.CodeBuildType == CodeBuildTypeFile {
+			build.File = p.CodeBuildFile
+		} else {
+			build.Script = p.CodeBuildScript
+		}
+		for _, cache := range p.CodeBuildCaches {
+			build.Caches = append(build.Caches, buildDefinitionCache{Path: cache.Path, Key: cache.Key})
+		}
+		r := b.Resources
+		build.Resources = &buildDefinitionResources{
+			Pids:     r.Pids,
+			Network:  r.Network,
+			ReadOnly: r.ReadOnly,
+			User:     r.User,
+		}
+		if r.Cpus > 0 {
+			build.Resources.Cpus = fmt.Sprintf("%v", r.Cpus)
+		}
+		if r.Memory > 0 {
+			build.Resources.Memory = fmt.Sprintf("%d", r.Memory)
+		}
+		for _, service := range p.Services {
+			s := buildDefinitionService{Image: service.Image, Alias: service.Alias, Command: service.Command}
+			// 服务环境变量中可能包含密码，只输出变量名
+			s.Env = maskEnv(service.Env)
+			if hc := service.HealthCheck; hc != nil {
+				s.HealthCheck = &buildDefinitionHealthCheck{
+					Cmd: hc.Cmd, Interval: hc.Interval, Timeout: hc.Timeout, Retries: hc.Retries, StartPeriod: hc.StartPeriod,
+				}
+			}
+			build.Services = append(build.Services, s)
+		}
+		if matrix := p.CodeBuildMatrix; matrix != nil {
+			build.Matrix = &buildDefinitionMatrix{FailFast: matrix.FailFast, MaxParallel: matrix.MaxParallel}
+			// 矩阵环境变量同样可能包含令牌等密钥，只输出变量名
+			for _, env := range matrix.Envs {
+				build.Matrix.Envs = append(build.Matrix.Envs, maskEnv(env))
+			}
+			for _, image := range matrix.Images {
+				build.Matrix.Images = append(build.Matrix.Images, image.Value)
+			}
+		}
+		if r := p.CodeBuildReports; r != nil {
+			build.Reports = &buildDefinitionReports{
+				JUnit:             r.JUnit,
+				Coverage:          r.Coverage,
+				CoverageFormat:    r.CoverageFormat,
+				CoverageThreshold: r.CoverageThreshold,
+			}
+		}
+		def.Build = build
+	}
+	for _, image := range p.ImageBuilds {
+		i := buildDefinitionImage{Image: image.Image, Dockerfile: image.Dockerfile, Platforms: image.Platforms}
+		if cache := image.Cache; cache != nil {
+			i.Cache = &buildDefinitionImageCache{Mode: cache.Mode, Ref: cache.Ref, From: cache.From}
+		}
+		def.Images = append(def.Images, i)
+	}
+	for _, artifact := range p.Artifacts {
+		def.Artifacts = append(def.Artifacts, buildDefinitionArtifact{Name: artifact.Name, Paths: artifact.Paths, Optional: artifact.Optional})
+	}
+	return def
+}
+
+// maskEnv 隐藏环境变量的值，用于在任务日志中输出配置
+func maskEnv(env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return nil
+	}
+	masked := make(map[string]string)
+	for name := range env {
+		masked[name] = "***"
+	}
+	return masked
+}
@@ -0,0 +1,185 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"k8s.io/klog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// serviceStartTimeout 等待服务容器健康的最长时间
+const serviceStartTimeout = 5 * time.Minute
+
+var serviceAliasRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// runningService 已启动的服务容器
+type runningService struct {
+	Alias string
+	Id    string
+}
+
+// serviceAlias 服务默认别名为镜像名称的最后一段，如 bitnami/redis:6.2 -> redis
+func serviceAlias(image string) string {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return ""
+	}
+	repository := ref.Repository
+	return strings.ToLower(repository[strings.LastIndex(repository, "/")+1:])
+}
+
+// validateBuildServices 校验服务容器参数，并为未指定别名的服务设置默认别名
+func validateBuildServices(services []serializers.BuildService) error {
+	if len(services) == 0 {
+		return nil
+	}
+	if !networkAllowed(NetworkBridge) {
+		return fmt.Errorf("服务容器需要使用 bridge 网络，服务端未允许任务使用 bridge 网络")
+	}
+	aliases := make(map[string]bool)
+	for i := range services {
+		service := &services[i]
+		if service.Image == "" {
+			return fmt.Errorf("服务容器镜像参数为空")
+		}
+		if service.Alias == "" {
+			service.Alias = serviceAlias(service.Image)
+		}
+		if !serviceAliasRe.MatchString(service.Alias) {
+			return fmt.Errorf("服务容器别名%s不合法，只能包含小写字母、数字及-", service.Alias)
+		}
+		if aliases[service.Alias] {
+			return fmt.Errorf("服务容器别名%s重复", service.Alias)
+		}
+		aliases[service.Alias] = true
+		for name := range service.Env {
+			if !utils.IsEnvName(name) {
+				return fmt.Errorf("服务容器%s环境变量名%s不合法", service.Alias, name)
+			}
+		}
+		if hc := service.HealthCheck; hc != nil {
+			if hc.Cmd == "" {
+				return fmt.Errorf("服务容器%s健康检查命令为空", service.Alias)
+			}
+			if hc.Interval < 0 || hc.Timeout < 0 || hc.Retries < 0 || hc.StartPeriod < 0 {
+				return fmt.Errorf("服务容器%s健康检查参数不能为负数", service.Alias)
+			}
+		}
+	}
+	return nil
+}
+
+// startServices 创建任务网络并启动服务容器，等待所有服务健康，返回已启动的服务，
+// 启动失败时同样返回已启动的服务，由调用方通过 stopServices 清理
+func (b *CodeBuilderPlugin) startServices(network string) ([]*runningService, error) {
+	rt, err := b.containerRuntime()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if err = rt.CreateNetwork(ctx, network); err != nil {
+		b.Log("创建任务网络%s失败：%v", network, err)
+		return nil, fmt.Errorf("创建任务网络%s失败：%v", network, err)
+	}
+	resources, err := NewContainerResources(&serializers.ContainerResources{})
+	if err != nil {
+		return nil, err
+	}
+	// 服务容器使用镜像中定义的用户运行
+	resources.User = ""
+	var services []*runningService
+	for _, service := range b.Params.Services {
+		var envs []string
+		for name, val := range service.Env {
+			envs = append(envs, name+"="+val)
+		}
+		sort.Strings(envs)
+		spec := &ContainerSpec{
+			Name:      b.containerName("service-" + service.Alias),
+			Image:     service.Image,
+			Cmd:       service.Command,
+			Env:       envs,
+			Resources: resources,
+			Network:   network,
+			Aliases:   []string{service.Alias},
+			Auth:      b.registryAuth(service.Image),
+		}
+		if hc := service.HealthCheck; hc != nil {
+			spec.HealthCheck = &ContainerHealthCheck{
+				Cmd:         hc.Cmd,
+				Interval:    time.Duration(hc.Interval) * time.Second,
+				Timeout:     time.Duration(hc.Timeout) * time.Second,
+				StartPeriod: time.Duration(hc.StartPeriod) * time.Second,
+				Retries:     hc.Retries,
+			}
+		}
+		b.Log("启动服务容器%s：%s", service.Alias, service.Image)
+		id, err := rt.StartContainer(ctx, spec, b.Logger)
+		if err != nil {
+			b.Log("启动服务容器%s失败：%v", service.Alias, err)
+			return services, fmt.Errorf("启动服务容器%s失败：%v", service.Alias, err)
+		}
+		services = append(services, &runningService{Alias: service.Alias, Id: id})
+	}
+	for _, service := range services {
+		if err = b.waitServiceHealthy(rt, service); err != nil {
+			b.Log("%v", err)
+			return services, err
+		}
+	}
+	return services, nil
+}
+
+// waitServiceHealthy 等待服务容器健康，未配置健康检查的服务容器运行后即可用
+func (b *CodeBuilderPlugin) waitServiceHealthy(rt ContainerRuntime, service *runningService) error {
+	deadline := time.Now().Add(serviceStartTimeout)
+	for {
+		status, err := rt.ContainerStatus(context.Background(), service.Id)
+		if err != nil {
+			return fmt.Errorf("获取服务容器%s状态失败：%v", service.Alias, err)
+		}
+		if !status.Running {
+			return fmt.Errorf("服务容器%s已退出，退出码：%d", service.Alias, status.ExitCode)
+		}
+		switch status.Health {
+		case "", HealthHealthy:
+			b.Log("服务容器%s已就绪", service.Alias)
+			return nil
+		case HealthUnhealthy:
+			return fmt.Errorf("服务容器%s健康检查失败", service.Alias)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待服务容器%s健康超时", service.Alias)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// stopServices 将服务容器日志写入任务日志，删除服务容器及任务网络
+func (b *CodeBuilderPlugin) stopServices(network string, services []*runningService) {
+	rt, err := b.containerRuntime()
+	if err != nil {
+		return
+	}
+	ctx := context.Background()
+	for _, service := range services {
+		b.Log("========== 服务容器%s日志 ==========", service.Alias)
+		writer := newPrefixWriter(b.Logger, "["+service.Alias+"] ", nil)
+		if err = rt.ContainerLogs(ctx, service.Id, writer); err != nil {
+			b.Log("获取服务容器%s日志失败：%v", service.Alias, err)
+		}
+		_ = writer.Flush()
+		if err = rt.RemoveContainer(ctx, service.Id); err != nil {
+			klog.Errorf("job=%d remove service container %s error: %v", b.JobId, service.Alias, err)
+		}
+	}
+	if err = rt.RemoveNetwork(ctx, network); err != nil {
+		klog.Errorf("job=%d remove network %s error: %v", b.JobId, network, err)
+	}
+}
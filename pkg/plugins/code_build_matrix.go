@@ -0,0 +1,253 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMatrixCells 矩阵构建最多的单元数
+const maxMatrixCells = 64
+
+const (
+	MatrixCellSuccess  = "success"
+	MatrixCellFailed   = "failed"
+	MatrixCellCanceled = "canceled"
+)
+
+// MatrixCellResult 矩阵构建单元的执行结果
+type MatrixCellResult struct {
+	Name   string            `json:"name"`
+	Image  string            `json:"image"`
+	Env    map[string]string `json:"env,omitempty"`
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	// Duration 执行时长，单位秒
+	Duration int64 `json:"duration"`
+}
+
+// validateBuildMatrix 校验矩阵构建参数
+func validateBuildMatrix(matrix *serializers.CodeBuildMatrix) error {
+	if matrix == nil {
+		return nil
+	}
+	for _, image := range matrix.Images {
+		if image.Value == "" {
+			return fmt.Errorf("矩阵构建镜像参数为空")
+		}
+	}
+	for _, env := range matrix.Envs {
+		for name := range env {
+			if !utils.IsEnvName(name) {
+				return fmt.Errorf("矩阵构建环境变量名%s不合法", name)
+			}
+		}
+	}
+	cells := len(matrix.Images)
+	if cells == 0 {
+		cells = 1
+	}
+	if len(matrix.Envs) > 0 {
+		cells *= len(matrix.Envs)
+	}
+	if cells > maxMatrixCells {
+		return fmt.Errorf("矩阵构建单元数%d超过上限%d", cells, maxMatrixCells)
+	}
+	if matrix.MaxParallel < 0 {
+		return fmt.Errorf("矩阵构建最大并行数不能为负数")
+	}
+	return nil
+}
+
+// matrixCells 展开矩阵的所有单元，镜像为外层循环
+func (b *CodeBuilderPlugin) matrixCells() []*MatrixCellResult {
+	matrix := b.Params.CodeBuildMatrix
+	images := matrix.Images
+	if len(images) == 0 {
+		images = []serializers.PipelineResource{b.Params.CodeBuildImage}
+	}
+	envs := matrix.Envs
+	if len(envs) == 0 {
+		envs = []map[string]string{nil}
+	}
+	var cells []*MatrixCellResult
+	for _, image := range images {
+		for _, env := range envs {
+			name := []string{image.Value}
+			var names []string
+			for k := range env {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				name = append(name, k+"="+env[k])
+			}
+			cells = append(cells, &MatrixCellResult{Name: strings.Join(name, " "), Image: image.Value, Env: env})
+		}
+	}
+	return cells
+}
+
+// buildMatrix 并行执行矩阵的所有单元，第一个单元在代码目录中执行，构建产物用于后续的镜像构建，
+// 其它单元在代码目录的副本中执行，互不影响
+func (b *CodeBuilderPlugin) buildMatrix(shExec, codeBuildFile string, mounts []ContainerMount, network string) error {
+	cells := b.matrixCells()
+	b.Result.Matrix = cells
+	matrix := b.Params.CodeBuildMatrix
+	parallel := matrix.MaxParallel
+	if parallel <= 0 || parallel > len(cells) {
+		parallel = len(cells)
+	}
+	b.Log("矩阵构建共%d个单元，最大并行数%d", len(cells), parallel)
+
+	// 在任何单元开始执行前复制代码目录，避免副本中包含第一个单元的构建产物
+	matrixDir := filepath.Join(b.RootDir, ".matrix")
+	defer os.RemoveAll(matrixDir)
+	cellDirs := []string{b.CodeDir}
+	for i := 1; i < len(cells); i++ {
+		cellDir := filepath.Join(matrixDir, fmt.Sprintf("%d", i+1))
+		if err := copyDir(b.CodeDir, cellDir); err != nil {
+			b.Log("复制代码目录失败：%v", err)
+			return fmt.Errorf("复制代码目录失败：%v", err)
+		}
+		cellDirs = append(cellDirs, cellDir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logMu := &sync.Mutex{}
+	sem := make(chan struct{}, parallel)
+	wg := &sync.WaitGroup{}
+	for i, cell := range cells {
+		wg.Add(1)
+		go func(i int, cell *MatrixCellResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				cell.Status = MatrixCellCanceled
+				return
+			}
+			start := time.Now()
+			out := newPrefixWriter(b.Logger, fmt.Sprintf("[%d %s] ", i+1, cell.Name), logMu)
+			cellMounts := append([]ContainerMount{}, mounts...)
+			cellMounts[0].Source = cellDirs[i]
+			err := b.buildMatrixCell(ctx, i, cell, shExec, codeBuildFile, cellMounts, network, out)
+			_ = out.Flush()
+			cell.Duration = int64(time.Since(start).Seconds())
+			switch {
+			case err == nil:
+				cell.Status = MatrixCellSuccess
+			case ctx.Err() != nil && !errors.Is(err, errMatrixCellFailed):
+				cell.Status = MatrixCellCanceled
+			default:
+				cell.Status = MatrixCellFailed
+				cell.Error = err.Error()
+				if matrix.FailFast {
+					cancel()
+				}
+			}
+			b.Log("矩阵单元[%d %s]执行结束：%s", i+1, cell.Name, cell.Status)
+		}(i, cell)
+	}
+	wg.Wait()
+	b.collectReports(cellDirs)
+
+	var failed []string
+	for _, cell := range cells {
+		b.Log("矩阵单元 %s：%s，耗时%ds", cell.Name, cell.Status, cell.Duration)
+		if cell.Status != MatrixCellSuccess {
+			failed = append(failed, cell.Name)
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("矩阵构建失败的单元：%s", strings.Join(failed, "; "))
+		klog.Errorf("job=%d %v", b.JobId, err)
+		// 失败时回调结果中同样包含各单元的执行状态
+		return &PluginError{Code: code.ExecError, Err: err, Data: b.Result}
+	}
+	return nil
+}
+
+// errMatrixCellFailed 单元自身执行失败，用于区分 fail-fast 终止的单元
+var errMatrixCellFailed = errors.New("matrix cell failed")
+
+// buildMatrixCell 执行矩阵的一个单元
+func (b *CodeBuilderPlugin) buildMatrixCell(ctx context.Context, index int, cell *MatrixCellResult,
+	shExec, codeBuildFile string, mounts []ContainerMount, network string, out io.Writer) error {
+	var envs []string
+	for k, v := range cell.Env {
+		envs = append(envs, k+"="+v)
+	}
+	sort.Strings(envs)
+	err := b.runContainer(ctx, &ContainerSpec{
+		Name:       b.containerName(fmt.Sprintf("build-%d", index+1)),
+		Image:      cell.Image,
+		Entrypoint: []string{shExec},
+		Cmd:        []string{"-ex", "/app/" + codeBuildFile},
+		Env:        envs,
+		WorkingDir: "/app",
+		Mounts:     mounts,
+		Resources:  b.Resources,
+		Network:    network,
+	}, out)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%w: %v", errMatrixCellFailed, err)
+	}
+	return err
+}
+
+// copyDir 复制目录，保留文件权限及符号链接
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
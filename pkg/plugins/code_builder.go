@@ -1,20 +1,17 @@
 package plugins
 
 import (
+	"context"
 	"fmt"
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
-	sshgit "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
 	"github.com/kubespace/pipeline-plugin/pkg/utils"
 	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
 	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
-	"golang.org/x/crypto/ssh"
 	"k8s.io/klog"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -44,16 +41,42 @@ func (b *CodeBuilder) BuildCodeToImage(ser *serializers.BuildCodeToImageSerializ
 
 type CodeBuilderPlugin struct {
 	*BasePlugin
-	Params  *serializers.BuildCodeToImageSerializer
-	CodeDir string
-	Images  []string
-	Result  *CodeBuilderPluginResult
+	Params       *serializers.BuildCodeToImageSerializer
+	CodeDir      string
+	Images       []string
+	ImageBuilder ImageBuilder
+	Resources    *ContainerResources
+	Result       *CodeBuilderPluginResult
 }
 
 type CodeBuilderPluginResult struct {
-	ImageUrl        string `json:"images"`
-	ImageRegistry   string `json:"image_registry"`
-	ImageRegistryId int    `json:"image_registry_id"`
+	ImageUrl        string              `json:"images"`
+	ImageRegistry   string              `json:"image_registry"`
+	ImageRegistryId int                 `json:"image_registry_id"`
+	Builds          []*ImageBuildResult `json:"builds"`
+	// Matrix 矩阵构建各单元的执行结果
+	Matrix []*MatrixCellResult `json:"matrix,omitempty"`
+	// Artifacts 归档的构建产物
+	Artifacts []*ArtifactResult `json:"artifacts,omitempty"`
+	// Reports 测试及覆盖率报告汇总
+	Reports *TestReportResult `json:"reports,omitempty"`
+	// DockerfileViolations Dockerfile 检查发现的问题
+	DockerfileViolations []*DockerfileViolation `json:"dockerfile_violations,omitempty"`
+	// ImageScans 推送镜像的漏洞扫描结果
+	ImageScans []*ImageScanResult `json:"image_scans,omitempty"`
+	// ImageScanViolations 超过阈值的漏洞级别
+	ImageScanViolations []string `json:"image_scan_violations,omitempty"`
+	// Sboms 代码目录及推送镜像的物料清单
+	Sboms []*SbomResult `json:"sboms,omitempty"`
+	// Signatures 推送镜像的签名
+	Signatures []*SignatureResult `json:"signatures,omitempty"`
+}
+
+// ImageBuildResult 镜像构建推送结果，多平台构建时包含各平台镜像的 digest
+type ImageBuildResult struct {
+	Image     string            `json:"image"`
+	Digest    string            `json:"digest"`
+	Platforms map[string]string `json:"platforms,omitempty"`
 }
 
 func NewCodeBuilderPlugin(ser *serializers.BuildCodeToImageSerializer) (*CodeBuilderPlugin, error) {
@@ -77,69 +100,141 @@ func NewCodeBuilderPlugin(ser *serializers.BuildCodeToImageSerializer) (*CodeBui
 	absCodeDir, _ := filepath.Abs(buildCodePlugin.RootDir + "/" + codeDir)
 	buildCodePlugin.CodeDir = absCodeDir
 	buildCodePlugin.Executor = buildCodePlugin
+	imageBuilder, err := NewImageBuilder(ser.ImageBuilder, buildCodePlugin.BasePlugin)
+	if err != nil {
+		klog.Errorf("job=%d new image builder error: %v", ser.JobId, err)
+		return nil, err
+	}
+	buildCodePlugin.ImageBuilder = imageBuilder
+	if err = validateDefinitionParams(ser); err != nil {
+		klog.Errorf("job=%d code build definition error: %v", ser.JobId, err)
+		return nil, err
+	}
+	if err = validateDockerfilePolicy(ser.DockerfilePolicy); err != nil {
+		klog.Errorf("job=%d dockerfile policy error: %v", ser.JobId, err)
+		return nil, err
+	}
+	if err = validateImageScan(ser.ImageScan); err != nil {
+		klog.Errorf("job=%d image scan error: %v", ser.JobId, err)
+		return nil, err
+	}
+	if ser.Signing != nil {
+		if err = validateSigningKey(ser.Signing.Key, true); err != nil {
+			klog.Errorf("job=%d image signing error: %v", ser.JobId, err)
+			return nil, err
+		}
+	}
+	if err = buildCodePlugin.validateParams(); err != nil {
+		return nil, err
+	}
 
 	return buildCodePlugin, nil
 }
 
+// validateParams 校验构建参数并选择容器运行时，合并代码仓库中的构建定义后需要重新校验
+func (b *CodeBuilderPlugin) validateParams() error {
+	ser := b.Params
+	var err error
+	if b.Resources, err = NewContainerResources(&ser.CodeBuildResources); err != nil {
+		klog.Errorf("job=%d code build resources error: %v", ser.JobId, err)
+		return err
+	}
+	if err = validateBuildMatrix(ser.CodeBuildMatrix); err != nil {
+		klog.Errorf("job=%d code build matrix error: %v", ser.JobId, err)
+		return err
+	}
+	if err = validateBuildServices(ser.Services); err != nil {
+		klog.Errorf("job=%d code build services error: %v", ser.JobId, err)
+		return err
+	}
+	if err = validateArtifacts(ser.Artifacts); err != nil {
+		klog.Errorf("job=%d code build artifacts error: %v", ser.JobId, err)
+		return err
+	}
+	if err = validateSbom(ser.Sbom, ser.Artifacts); err != nil {
+		klog.Errorf("job=%d sbom error: %v", ser.JobId, err)
+		return err
+	}
+	if err = validateBuildReports(ser.CodeBuildReports); err != nil {
+		klog.Errorf("job=%d code build reports error: %v", ser.JobId, err)
+		return err
+	}
+	if err = validateArtifactInputs(ser.JobId, ser.Inputs); err != nil {
+		klog.Errorf("job=%d code build inputs error: %v", ser.JobId, err)
+		return err
+	}
+	requirements := &RuntimeRequirements{Resources: b.Resources, Services: len(ser.Services) > 0}
+	if _, ok := b.ImageBuilder.(*dockerImageBuilder); ok {
+		requirements.Build = true
+		for _, build := range ser.ImageBuilds {
+			if len(build.Platforms) > 0 || build.Cache != nil && build.Cache.Mode == ImageBuildCacheRegistry {
+				requirements.BuildKit = true
+			}
+		}
+	}
+	if err = b.UseContainerRuntime(ser.ContainerRuntime, requirements); err != nil {
+		klog.Errorf("job=%d container runtime error: %v", ser.JobId, err)
+		return err
+	}
+	return nil
+}
+
 func (b *CodeBuilderPlugin) execute() (interface{}, error) {
+	registries := []*serializers.ImageRegistry{&b.Params.ImageBuildRegistry, resourceRegistry(&b.Params.CodeBuildImage)}
+	if matrix := b.Params.CodeBuildMatrix; matrix != nil {
+		for i := range matrix.Images {
+			registries = append(registries, resourceRegistry(&matrix.Images[i]))
+		}
+	}
+	if err := b.InitDockerConfig(registries...); err != nil {
+		return nil, err
+	}
 	if err := b.clone(); err != nil {
 		return nil, err
 	}
-	if err := b.buildCode(); err != nil {
+	if err := b.loadBuildDefinition(); err != nil {
 		return nil, err
 	}
-	if err := b.buildImages(); err != nil {
+	if err := b.fetchInputs(b.CodeDir, b.Params.Inputs); err != nil {
 		return nil, err
 	}
-	return b.Result, nil
-}
-
-func (b *CodeBuilderPlugin) clone() error {
-	os.RemoveAll(b.CodeDir)
-	b.Log("git clone %v", b.Params.CodeUrl)
-	time.Sleep(1)
-	var auth transport.AuthMethod
-	var err error
-	if b.Params.CodeSecret.Type == "key" {
-		privateKey, err := sshgit.NewPublicKeys("git", []byte(b.Params.CodeSecret.PrivateKey), "")
-		if err != nil {
-			return fmt.Errorf("生成代码密钥失败：" + err.Error())
-		}
-		privateKey.HostKeyCallbackHelper = sshgit.HostKeyCallbackHelper{
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		}
-		auth = privateKey
-	} else if b.Params.CodeSecret.Type == "password" {
-		auth = &http.BasicAuth{
-			Username: b.Params.CodeSecret.User,
-			Password: b.Params.CodeSecret.Password,
+	err := b.buildCode()
+	if len(b.Params.Artifacts) > 0 {
+		// 构建失败时同样归档产物，便于查看测试报告等文件
+		artifacts, artifactErr := b.collectArtifacts(b.CodeDir, b.Params.Artifacts)
+		b.Result.Artifacts = artifacts
+		if err == nil {
+			err = artifactErr
 		}
 	}
-	r, err := git.PlainClone(b.CodeDir, false, &git.CloneOptions{
-		Auth:     auth,
-		URL:      b.Params.CodeUrl,
-		Progress: b.Logger,
-	})
-	if err != nil {
-		b.Log("克隆代码仓库失败：%v", err)
-		klog.Errorf("job=%d clone %s error: %v", b.BasePlugin.JobId, b.Params.CodeUrl, err)
-		return fmt.Errorf("git clone %s error: %v", b.Params.CodeUrl, err)
+	if err == nil {
+		err = b.checkCoverageThreshold()
 	}
-	w, err := r.Worktree()
 	if err != nil {
-		b.Log("克隆代码仓库失败：%v", err)
-		klog.Errorf("job=%d clone %s error: %v", b.BasePlugin.JobId, b.Params.CodeUrl, err)
-		return fmt.Errorf("git clone %s error: %v", b.Params.CodeUrl, err)
+		return nil, err
 	}
-	err = w.Checkout(&git.CheckoutOptions{
-		Hash: plumbing.NewHash(b.Params.CodeCommitId),
-	})
-	if err != nil {
-		b.Log("git checkout %s 失败：%v", b.Params.CodeCommitId, err)
-		klog.Errorf("job=%d git checkout %s error: %v", b.BasePlugin.JobId, b.Params.CodeCommitId, err)
-		return fmt.Errorf("git checkout %s error: %v", b.Params.CodeCommitId, err)
+	if err := b.buildImages(); err != nil {
+		return nil, err
 	}
-	return nil
+	if err := b.generateSboms(); err != nil {
+		return nil, err
+	}
+	// 漏洞数超过警告上限时镜像仍然签名，任务标记为不稳定
+	scanErr := b.scanImages()
+	if pluginErr, ok := scanErr.(*PluginError); scanErr != nil && (!ok || pluginErr.Code != code.Unstable) {
+		return nil, scanErr
+	}
+	if err := b.signImages(); err != nil {
+		return nil, err
+	}
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return b.Result, nil
+}
+
+func (b *CodeBuilderPlugin) clone() error {
+	return b.gitClone(b.CodeDir, b.Params.CodeUrl, &b.Params.CodeSecret, b.Params.CodeCommitId)
 }
 
 func (b *CodeBuilderPlugin) buildCode() error {
@@ -147,7 +242,7 @@ func (b *CodeBuilderPlugin) buildCode() error {
 		b.Log("跳过代码构建")
 		return nil
 	}
-	if b.Params.CodeBuildImage.Value == "" {
+	if b.Params.CodeBuildImage.Value == "" && (b.Params.CodeBuildMatrix == nil || len(b.Params.CodeBuildMatrix.Images) == 0) {
 		b.Log("构建代码镜像为空，请检查流水线配置")
 		return fmt.Errorf("build code image is empty")
 	}
@@ -188,21 +283,58 @@ func (b *CodeBuilderPlugin) buildCode() error {
 		shExec = "bash"
 	}
 
-	dockerRunCmd := fmt.Sprintf("docker run --net=host --rm -i -v %s:/app -w /app --entrypoint sh %s -c \"%s -ex /app/%s 2>&1\"", b.CodeDir, b.Params.CodeBuildImage.Value, shExec, codeBuildFile)
-	klog.Infof("job=%d code build cmd: %s", b.JobId, dockerRunCmd)
-	cmd := exec.Command("bash", "-xc", dockerRunCmd)
-	cmd.Stdout = b.Logger
-	cmd.Stderr = b.Logger
-	if err := cmd.Run(); err != nil {
+	cacheMounts, err := b.mountBuildCaches(b.Params.CodeBuildCaches)
+	if err != nil {
+		b.Log("获取构建缓存错误：%v", err)
+		return err
+	}
+	defer b.releaseBuildCaches(cacheMounts)
+	mounts := []ContainerMount{{Source: b.CodeDir, Target: "/app"}}
+	for _, mount := range cacheMounts {
+		mounts = append(mounts, ContainerMount{Source: mount.HostDir, Target: mount.Path})
+	}
+	network := ""
+	if len(b.Params.Services) > 0 {
+		// 构建容器与服务容器加入同一任务网络，通过服务别名访问
+		network = b.containerName("network")
+		var services []*runningService
+		defer func() { b.stopServices(network, services) }()
+		if services, err = b.startServices(network); err != nil {
+			return err
+		}
+	}
+	if b.Params.CodeBuildMatrix != nil {
+		return b.buildMatrix(shExec, codeBuildFile, mounts, network)
+	}
+	err = b.runContainer(context.Background(), &ContainerSpec{
+		Name:       b.containerName("build"),
+		Image:      b.Params.CodeBuildImage.Value,
+		Entrypoint: []string{shExec},
+		Cmd:        []string{"-ex", "/app/" + codeBuildFile},
+		WorkingDir: "/app",
+		Mounts:     mounts,
+		Resources:  b.Resources,
+		Network:    network,
+	}, b.Logger)
+	// 构建失败时同样解析报告，便于查看失败的测试用例
+	b.collectReports([]string{b.CodeDir})
+	if err != nil {
 		klog.Errorf("job=%d build error: %v", b.JobId, err)
+		if _, ok := err.(*PluginError); ok {
+			return err
+		}
 		return fmt.Errorf("build code error: %v", err)
 	}
 	return nil
 }
 
 func (b *CodeBuilderPlugin) buildImages() error {
+	dockerfiles, err := b.checkDockerfiles()
+	if err != nil {
+		return err
+	}
 	timeStr := fmt.Sprintf("%d", time.Now().Unix())
-	for _, buildImage := range b.Params.ImageBuilds {
+	for i, buildImage := range b.Params.ImageBuilds {
 		imageName := buildImage.Image
 		if imageName == "" {
 			b.Log("not found build image parameter")
@@ -214,68 +346,92 @@ func (b *CodeBuilderPlugin) buildImages() error {
 		} else {
 			imageName = "docker.io/" + imageName + ":" + timeStr
 		}
-		dockerfile := buildImage.Dockerfile
-		if dockerfile == "" {
-			dockerfile = "Dockerfile"
+		cache, err := b.imageBuildCache(buildImage.Cache, imageName)
+		if err != nil {
+			b.Log("%v", err)
+			return err
 		}
-		if err := b.buildAndPushImage(dockerfile, imageName); err != nil {
+		digest, err := b.ImageBuilder.BuildAndPush(&ImageBuildOptions{
+			Image:      imageName,
+			Dockerfile: dockerfiles[i],
+			Context:    b.CodeDir,
+			Platforms:  buildImage.Platforms,
+			Cache:      cache,
+		})
+		if err != nil {
 			return err
 		}
+		b.Images = append(b.Images, imageName)
+		b.Result.Builds = append(b.Result.Builds, b.imageBuildResult(imageName, digest))
 	}
 	b.Result.ImageUrl = strings.Join(b.Images, ",")
 	return nil
 }
 
-func (b *CodeBuilderPlugin) buildAndPushImage(dockerfilePath string, imageName string) error {
-	dockerfile := b.CodeDir + "/" + dockerfilePath
-	//baseDockerfile := filepath.Dir(dockerfile)
-	dockerBuildCmd := fmt.Sprintf("docker build -t %s -f %s %s", imageName, dockerfile, b.CodeDir)
-	cmd := exec.Command("bash", "-xc", dockerBuildCmd)
-	cmd.Stdout = b.Logger
-	cmd.Stderr = b.Logger
-	if err := cmd.Run(); err != nil {
-		b.Log("构建镜像%s错误：%v", imageName, err)
-		klog.Errorf("build image error: %v", err)
-		return fmt.Errorf("构建镜像%s错误：%v", imageName, err)
-	}
-	if err := b.pushImage(imageName); err != nil {
-		return err
+// imageBuildCache 生成镜像构建缓存参数，未指定缓存地址时使用镜像同名仓库的 buildcache tag
+func (b *CodeBuilderPlugin) imageBuildCache(cache *serializers.ImageBuildCache, imageName string) (*ImageBuildCacheOptions, error) {
+	if cache == nil || cache.Mode == "" {
+		return nil, nil
 	}
-	b.Images = append(b.Images, imageName)
-	cmd = exec.Command("bash", "-xc", "docker rmi "+imageName)
-	cmd.Stdout = b.Logger
-	cmd.Stderr = b.Logger
-	if err := cmd.Run(); err != nil {
-		b.Log("删除本地镜像%s错误：%v", imageName, err)
-		klog.Errorf("remove image %s error: %v", imageName, err)
-		//return fmt.Errorf("删除本地构建镜像%s错误：%v", imageName, err)
+	if cache.Mode != ImageBuildCacheInline && cache.Mode != ImageBuildCacheRegistry {
+		return nil, fmt.Errorf("镜像构建缓存方式%s错误，只支持 inline、registry", cache.Mode)
 	}
-	return nil
-}
-
-func (b *CodeBuilderPlugin) loginDocker(user string, password string, server string) error {
-	b.Log("docker login %s", server)
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("docker login -u %s -p %s %s", user, password, server))
-	cmd.Stdout = b.Logger
-	cmd.Stderr = b.Logger
-	return cmd.Run()
+	cacheRef := cache.Ref
+	if cacheRef == "" {
+		ref, err := registry.ParseReference(imageName)
+		if err != nil {
+			return nil, err
+		}
+		if conf.AppConfig.ImageCacheRepo != "" {
+			cacheRef = strings.TrimSuffix(conf.AppConfig.ImageCacheRepo, "/") + "/" + ref.Repository + ":" + ImageBuildCacheTag
+		} else {
+			cacheRef = ref.WithTag(ImageBuildCacheTag).String()
+		}
+	}
+	return &ImageBuildCacheOptions{
+		Mode: cache.Mode,
+		Ref:  cacheRef,
+		From: append([]string{cacheRef}, cache.From...),
+	}, nil
 }
 
-func (b *CodeBuilderPlugin) pushImage(imageUrl string) error {
-	if b.Params.ImageBuildRegistry.User != "" && b.Params.ImageBuildRegistry.Password != "" {
-		if err := b.loginDocker(b.Params.ImageBuildRegistry.User, b.Params.ImageBuildRegistry.Password, b.Params.ImageBuildRegistry.Registry); err != nil {
-			b.Log("docker login %s error: %v", b.Params.ImageBuildRegistry.Registry, err)
-			klog.Errorf("docker login %s error: %v", b.Params.ImageBuildRegistry.Registry, err)
+// imageBuildResult 从镜像仓库获取推送后镜像的 digest，多平台镜像同时获取各平台镜像的 digest
+func (b *CodeBuilderPlugin) imageBuildResult(imageName string, digest string) *ImageBuildResult {
+	result := &ImageBuildResult{Image: imageName, Digest: digest}
+	ref, err := registry.ParseReference(imageName)
+	if err != nil {
+		return result
+	}
+	client := newRegistryClient(ref.Registry, &b.Params.ImageBuildRegistry)
+	content, err := client.GetManifest(ref.Repository, ref.Identifier())
+	if err != nil {
+		b.Log("获取镜像%s digest 失败：%v", imageName, err)
+		klog.Errorf("job=%d get manifest of %s error: %v", b.JobId, imageName, err)
+		return result
+	}
+	result.Digest = content.Digest
+	if !registry.IsIndexMediaType(content.MediaType) {
+		return result
+	}
+	index, err := content.Manifest()
+	if err != nil {
+		return result
+	}
+	result.Platforms = make(map[string]string)
+	for _, desc := range index.Manifests {
+		// buildx 生成的 attestation 清单平台为 unknown/unknown
+		if desc.Platform != nil && desc.Platform.OS != "unknown" {
+			result.Platforms[desc.Platform.String()] = desc.Digest
 		}
 	}
-	pushCmd := fmt.Sprintf("docker push %s", imageUrl)
-	cmd := exec.Command("bash", "-xc", pushCmd)
-	cmd.Stdout = b.Logger
-	cmd.Stderr = b.Logger
-	if err := cmd.Run(); err != nil {
-		b.Log("docker push %s：%v", imageUrl, err)
-		klog.Errorf("push image error: %v", err)
-		return fmt.Errorf("推送镜像%s错误：%v", imageUrl, err)
+	b.Log("镜像 %s digest: %s", imageName, result.Digest)
+	var platforms []string
+	for platform := range result.Platforms {
+		platforms = append(platforms, platform)
 	}
-	return nil
+	sort.Strings(platforms)
+	for _, platform := range platforms {
+		b.Log("  %s: %s", platform, result.Platforms[platform])
+	}
+	return result
 }
@@ -0,0 +1,383 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/report"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// scanMountDir 分析器容器中挂载扫描脚本及 SARIF 输出目录的路径
+	scanMountDir = "/kubespace"
+	// maxResultFindings 回调结果中包含的问题数上限
+	maxResultFindings = 50
+)
+
+type CodeScanner struct{}
+
+func NewCodeScanner() *CodeScanner {
+	return &CodeScanner{}
+}
+
+func (s *CodeScanner) CodeScan(ser *serializers.CodeScanSerializer) *utils.Response {
+	scanPlugin, err := NewCodeScanPlugin(ser)
+	if err != nil {
+		return &utils.Response{Code: code.InitError, Msg: err.Error()}
+	}
+
+	go scanPlugin.Execute(ser)
+
+	return &utils.Response{Code: code.Success}
+}
+
+type CodeScanPlugin struct {
+	*BasePlugin
+	Params    *serializers.CodeScanSerializer
+	CodeDir   string
+	Resources *ContainerResources
+	Result    *CodeScanPluginResult
+}
+
+type CodeScanPluginResult struct {
+	Total         int                       `json:"total"`
+	New           int                       `json:"new"`
+	BaselineJobId uint                      `json:"baseline_job_id,omitempty"`
+	Severities    map[string]*SeverityCount `json:"severities"`
+	// Findings 新增问题优先、按严重程度排序的问题，最多包含 maxResultFindings 个，完整列表保存在数据库中
+	Findings []*CodeScanFinding `json:"findings,omitempty"`
+	// GateViolations 未通过的门禁
+	GateViolations []string `json:"gate_violations,omitempty"`
+}
+
+// SeverityCount 一个级别的问题数，New 为相对基线任务新增的问题数
+type SeverityCount struct {
+	Total int `json:"total"`
+	New   int `json:"new"`
+}
+
+type CodeScanFinding struct {
+	*report.Finding
+	New bool `json:"new"`
+}
+
+func NewCodeScanPlugin(ser *serializers.CodeScanSerializer) (*CodeScanPlugin, error) {
+	scanPlugin := &CodeScanPlugin{
+		BasePlugin: NewBasePlugin(ser.JobId, PluginCodeScan),
+		Params:     ser,
+		Result:     &CodeScanPluginResult{BaselineJobId: ser.BaselineJobId, Severities: make(map[string]*SeverityCount)},
+	}
+	scanPlugin.Executor = scanPlugin
+	codeDir := utils.GetCodeRepoName(ser.CodeUrl)
+	if codeDir == "" {
+		klog.Errorf("job=%d get empty code repo name", ser.JobId)
+		return nil, fmt.Errorf("get empty code repo name")
+	}
+	scanPlugin.CodeDir, _ = filepath.Abs(scanPlugin.RootDir + "/" + codeDir)
+	if err := validateCodeScanParams(ser); err != nil {
+		klog.Errorf("job=%d code scan params error: %v", ser.JobId, err)
+		return nil, err
+	}
+	resources, err := NewContainerResources(&ser.Resources)
+	if err != nil {
+		klog.Errorf("job=%d code scan resources error: %v", ser.JobId, err)
+		return nil, err
+	}
+	scanPlugin.Resources = resources
+	if err = scanPlugin.UseContainerRuntime(ser.ContainerRuntime, &RuntimeRequirements{Resources: resources}); err != nil {
+		klog.Errorf("job=%d container runtime error: %v", ser.JobId, err)
+		return nil, err
+	}
+	return scanPlugin, nil
+}
+
+// validateCodeScanParams 校验代码扫描参数
+func validateCodeScanParams(ser *serializers.CodeScanSerializer) error {
+	if ser.AnalyzerImage.Value == "" {
+		return fmt.Errorf("分析器镜像为空")
+	}
+	if strings.TrimSpace(ser.AnalyzerScript) == "" {
+		return fmt.Errorf("扫描脚本为空")
+	}
+	for name := range ser.Env {
+		if !utils.IsEnvName(name) {
+			return fmt.Errorf("环境变量名%s不合法", name)
+		}
+	}
+	for _, p := range ser.SarifPaths {
+		if !artifactPathRe.MatchString(p) {
+			return fmt.Errorf("SARIF 文件路径%s不合法，只能包含字母、数字、glob 通配符及./_-@+=,", p)
+		}
+		if err := checkRepoPath(p); err != nil {
+			return fmt.Errorf("SARIF 文件路径错误：%v", err)
+		}
+	}
+	if ser.BaselineJobId != 0 && ser.BaselineJobId == ser.JobId {
+		return fmt.Errorf("基线任务不能为当前任务")
+	}
+	if ser.Gate != nil {
+		for severity, max := range ser.Gate.Thresholds {
+			if report.SeverityRank(severity) == len(report.Severities) {
+				return fmt.Errorf("门禁问题级别%s错误，只支持 %s", severity, strings.Join(report.Severities, "、"))
+			}
+			if max < 0 {
+				return fmt.Errorf("门禁%s级别问题数上限不能小于 0", severity)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *CodeScanPlugin) execute() (interface{}, error) {
+	if err := s.InitDockerConfig(resourceRegistry(&s.Params.AnalyzerImage)); err != nil {
+		return nil, err
+	}
+	if err := s.gitClone(s.CodeDir, s.Params.CodeUrl, &s.Params.CodeSecret, s.Params.CodeCommitId); err != nil {
+		return nil, err
+	}
+	files, err := s.runAnalyzer()
+	if err != nil {
+		return nil, err
+	}
+	findings, err := s.parseFindings(files)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.compareBaseline(findings); err != nil {
+		return nil, err
+	}
+	if err = s.saveFindings(); err != nil {
+		return nil, err
+	}
+	s.summarize()
+	if err = s.checkGate(); err != nil {
+		return nil, err
+	}
+	return s.Result, nil
+}
+
+// runAnalyzer 在分析器容器中执行扫描脚本，返回 SARIF 结果文件。分析器发现问题时通常以非 0 退出码退出，
+// 因此退出码非 0 但生成了 SARIF 结果时继续解析，没有结果时返回执行错误
+func (s *CodeScanPlugin) runAnalyzer() ([]string, error) {
+	scanDir := filepath.Join(s.RootDir, ".scan")
+	outputDir := filepath.Join(scanDir, "sarif")
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	// 分析器镜像可能以非 root 用户运行
+	if err := os.Chmod(outputDir, 0777); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(scanDir, "scan.sh"), []byte(s.Params.AnalyzerScript), 0755); err != nil {
+		s.Log("写入扫描脚本错误：%v", err)
+		return nil, err
+	}
+	shExec := s.Params.AnalyzerExec
+	if shExec == "" {
+		shExec = "sh"
+	}
+	var envs []string
+	for name, val := range s.Params.Env {
+		envs = append(envs, name+"="+val)
+	}
+	sort.Strings(envs)
+	envs = append(envs, "SARIF_OUTPUT_DIR="+scanMountDir+"/sarif")
+	runErr := s.runContainer(context.Background(), &ContainerSpec{
+		Name:       s.containerName("scan"),
+		Image:      s.Params.AnalyzerImage.Value,
+		Entrypoint: []string{shExec},
+		Cmd:        []string{"-x", scanMountDir + "/scan.sh"},
+		Env:        envs,
+		WorkingDir: "/app",
+		Mounts: []ContainerMount{
+			{Source: s.CodeDir, Target: "/app"},
+			{Source: scanDir, Target: scanMountDir},
+		},
+		Resources: s.Resources,
+	}, s.Logger)
+
+	var files []string
+	var err error
+	if len(s.Params.SarifPaths) > 0 {
+		files, err = findReports([]string{s.CodeDir}, s.Params.SarifPaths)
+	} else {
+		files, err = findReports([]string{outputDir}, []string{"**/*.sarif"})
+	}
+	if err != nil {
+		s.Log("查找 SARIF 结果失败：%v", err)
+		return nil, err
+	}
+	if len(files) == 0 {
+		if runErr != nil {
+			klog.Errorf("job=%d run analyzer error: %v", s.JobId, runErr)
+			if _, ok := runErr.(*PluginError); ok {
+				return nil, runErr
+			}
+			return nil, fmt.Errorf("执行扫描失败：%v", runErr)
+		}
+		s.Log("没有找到 SARIF 扫描结果")
+		return nil, fmt.Errorf("没有找到 SARIF 扫描结果")
+	}
+	if runErr != nil {
+		s.Log("分析器执行返回错误：%v，继续解析扫描结果", runErr)
+	}
+	return files, nil
+}
+
+// parseFindings 解析所有 SARIF 文件，任一文件解析失败时返回错误
+func (s *CodeScanPlugin) parseFindings(files []string) ([]*report.Finding, error) {
+	var findings []*report.Finding
+	for _, file := range files {
+		name := file
+		if rel, err := filepath.Rel(s.RootDir, file); err == nil {
+			name = rel
+		}
+		content, err := readReport(file)
+		if err == nil {
+			var fs []*report.Finding
+			if fs, err = report.ParseSarif(content, "/app"); err == nil {
+				s.Log("解析 SARIF 结果%s：%d个问题", name, len(fs))
+				findings = append(findings, fs...)
+			}
+		}
+		if err != nil {
+			s.Log("解析 SARIF 结果%s失败：%v", name, err)
+			return nil, fmt.Errorf("解析 SARIF 结果%s失败：%v", name, err)
+		}
+	}
+	return findings, nil
+}
+
+// compareBaseline 与基线任务的问题按指纹比较，同一指纹的问题数多于基线时多出的部分为新增问题，
+// 没有基线任务时所有问题都为新增问题
+func (s *CodeScanPlugin) compareBaseline(findings []*report.Finding) error {
+	baseline := make(map[string]int)
+	if s.Params.BaselineJobId != 0 {
+		records, err := models.Models.FindingManager.List(s.Params.BaselineJobId)
+		if err != nil {
+			klog.Errorf("job=%d list baseline job %d findings error: %v", s.JobId, s.Params.BaselineJobId, err)
+			return fmt.Errorf("获取基线任务%d扫描结果失败：%v", s.Params.BaselineJobId, err)
+		}
+		for _, record := range records {
+			baseline[record.Fingerprint]++
+		}
+		s.Log("基线任务%d共%d个问题", s.Params.BaselineJobId, len(records))
+	}
+	for _, finding := range findings {
+		result := &CodeScanFinding{Finding: finding, New: true}
+		if baseline[finding.Fingerprint] > 0 {
+			baseline[finding.Fingerprint]--
+			result.New = false
+		}
+		s.Result.Findings = append(s.Result.Findings, result)
+	}
+	return nil
+}
+
+func (s *CodeScanPlugin) saveFindings() error {
+	var records []*types.PipelineJobFinding
+	for _, f := range s.Result.Findings {
+		records = append(records, &types.PipelineJobFinding{
+			Tool:        truncate(f.Tool, 255),
+			RuleId:      truncate(f.RuleId, 255),
+			Severity:    f.Severity,
+			File:        truncate(f.File, 1000),
+			Line:        f.Line,
+			Message:     truncate(f.Message, maxCaseMessageSize),
+			Fingerprint: f.Fingerprint,
+			IsNew:       f.New,
+		})
+	}
+	if err := models.Models.FindingManager.Save(s.JobId, records); err != nil {
+		klog.Errorf("job=%d save findings error: %v", s.JobId, err)
+		s.Log("保存扫描结果失败：%v", err)
+		return fmt.Errorf("保存扫描结果失败：%v", err)
+	}
+	return nil
+}
+
+// summarize 统计各级别问题数，回调结果中只保留排序后的前 maxResultFindings 个问题
+func (s *CodeScanPlugin) summarize() {
+	all := s.Result.Findings
+	for _, severity := range report.Severities {
+		s.Result.Severities[severity] = &SeverityCount{}
+	}
+	for _, f := range all {
+		count := s.Result.Severities[f.Severity]
+		count.Total++
+		s.Result.Total++
+		if f.New {
+			count.New++
+			s.Result.New++
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].New != all[j].New {
+			return all[i].New
+		}
+		if ri, rj := report.SeverityRank(all[i].Severity), report.SeverityRank(all[j].Severity); ri != rj {
+			return ri < rj
+		}
+		if all[i].File != all[j].File {
+			return all[i].File < all[j].File
+		}
+		return all[i].Line < all[j].Line
+	})
+	s.Log("扫描共发现%d个问题，新增%d个", s.Result.Total, s.Result.New)
+	for _, severity := range report.Severities {
+		count := s.Result.Severities[severity]
+		s.Log("  %s：%d个，新增%d个", severity, count.Total, count.New)
+	}
+	if len(all) > maxResultFindings {
+		s.Result.Findings = all[:maxResultFindings]
+	}
+	for _, f := range s.Result.Findings {
+		if !f.New {
+			break
+		}
+		s.Log("  [%s] %s:%d %s %s", f.Severity, f.File, f.Line, f.RuleId, firstLine(f.Message))
+	}
+}
+
+// checkGate 问题数超过门禁上限时返回 QualityGateError
+func (s *CodeScanPlugin) checkGate() error {
+	gate := s.Params.Gate
+	if gate == nil {
+		return nil
+	}
+	for _, severity := range report.Severities {
+		max, ok := gate.Thresholds[severity]
+		if !ok {
+			continue
+		}
+		count := s.Result.Severities[severity]
+		if gate.NewOnly && count.New > max {
+			s.Result.GateViolations = append(s.Result.GateViolations,
+				fmt.Sprintf("%s级别新增问题%d个，超过上限%d个", severity, count.New, max))
+		} else if !gate.NewOnly && count.Total > max {
+			s.Result.GateViolations = append(s.Result.GateViolations,
+				fmt.Sprintf("%s级别问题%d个，超过上限%d个", severity, count.Total, max))
+		}
+	}
+	if len(s.Result.GateViolations) == 0 {
+		s.Log("代码扫描门禁检查通过")
+		return nil
+	}
+	for _, v := range s.Result.GateViolations {
+		s.Log("代码扫描门禁未通过：%s", v)
+	}
+	return &PluginError{
+		Code: code.QualityGateError,
+		Err:  fmt.Errorf("代码扫描门禁未通过：%s", strings.Join(s.Result.GateViolations, "；")),
+		Data: s.Result,
+	}
+}
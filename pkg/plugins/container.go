@@ -0,0 +1,171 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"k8s.io/klog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var containerUserRe = regexp.MustCompile(`^[a-z0-9_][a-z0-9_.-]*(:[a-z0-9_][a-z0-9_.-]*)?$`)
+
+const (
+	NetworkNone   = "none"
+	NetworkBridge = "bridge"
+	NetworkHost   = "host"
+)
+
+// ContainerResources 构建及脚本容器的资源限制，已按服务端上限校验
+type ContainerResources struct {
+	Cpus     float64
+	Memory   int64
+	Pids     int64
+	Network  string
+	ReadOnly bool
+	// User 容器运行用户，格式为 uid[:gid]
+	User string
+}
+
+// NewContainerResources 校验任务的资源参数，未指定的参数使用服务端上限，超过上限时返回错误
+func NewContainerResources(res *serializers.ContainerResources) (*ContainerResources, error) {
+	limits := conf.AppConfig.ContainerLimits
+	resources := &ContainerResources{
+		Cpus:     limits.Cpus,
+		Memory:   limits.Memory,
+		Pids:     limits.Pids,
+		Network:  limits.DefaultNetwork,
+		ReadOnly: res.ReadOnly,
+		User:     conf.AppConfig.ContainerUser,
+	}
+	if resources.User == "" {
+		// 默认与插件服务使用相同用户，保证插件可以删除容器在工作目录中创建的文件
+		resources.User = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+	if res.User != "" {
+		if !containerUserRe.MatchString(res.User) {
+			return nil, fmt.Errorf("容器运行用户%s格式错误，需为 uid[:gid]", res.User)
+		}
+		resources.User = res.User
+	}
+	if res.Cpus != "" {
+		cpus, err := strconv.ParseFloat(res.Cpus, 64)
+		if err != nil || cpus <= 0 {
+			return nil, fmt.Errorf("容器cpu参数%s错误", res.Cpus)
+		}
+		if limits.Cpus > 0 && cpus > limits.Cpus {
+			return nil, fmt.Errorf("容器cpu参数%s超过上限%v", res.Cpus, limits.Cpus)
+		}
+		resources.Cpus = cpus
+	}
+	if res.Memory != "" {
+		memory, err := utils.ParseMemorySize(res.Memory)
+		if err != nil || memory <= 0 {
+			return nil, fmt.Errorf("容器内存参数%s错误", res.Memory)
+		}
+		if limits.Memory > 0 && memory > limits.Memory {
+			return nil, fmt.Errorf("容器内存参数%s超过上限%d字节", res.Memory, limits.Memory)
+		}
+		resources.Memory = memory
+	}
+	if res.Pids != 0 {
+		if res.Pids < 0 || limits.Pids > 0 && res.Pids > limits.Pids {
+			return nil, fmt.Errorf("容器进程数参数%d错误，上限为%d", res.Pids, limits.Pids)
+		}
+		resources.Pids = res.Pids
+	}
+	if res.Network != "" {
+		resources.Network = res.Network
+	}
+	if resources.Network == "" {
+		resources.Network = NetworkHost
+	}
+	if !networkAllowed(resources.Network) {
+		return nil, fmt.Errorf("容器网络模式%s不允许使用，可选：%s", resources.Network, strings.Join(limits.Networks, ","))
+	}
+	return resources, nil
+}
+
+// networkAllowed 判断服务端是否允许任务使用该网络模式
+func networkAllowed(network string) bool {
+	for _, allowed := range conf.AppConfig.ContainerLimits.Networks {
+		if strings.TrimSpace(allowed) == network {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoot 容器是否以 root 用户运行
+func (r *ContainerResources) isRoot() bool {
+	uid := strings.SplitN(r.User, ":", 2)[0]
+	return uid == "" || uid == "0" || uid == "root"
+}
+
+// removeByHelperContainer 通过辅助容器以 root 用户删除目录下的所有文件，keep 为保留的顶层文件名，
+// rt 为创建这些文件的任务使用的容器运行时，为空时使用默认运行时
+func removeByHelperContainer(rt ContainerRuntime, dir string, keep string) error {
+	if rt == nil {
+		rt = defaultRuntime
+	}
+	if rt == nil {
+		return fmt.Errorf("容器运行时未初始化")
+	}
+	cmd := []string{"find", "/workspace", "-mindepth", "1"}
+	if keep != "" {
+		cmd = append(cmd, "!", "-path", "/workspace/"+keep)
+	}
+	cmd = append(cmd, "-delete")
+	output := &bytes.Buffer{}
+	exit, err := rt.RunContainer(context.Background(), &ContainerSpec{
+		Image:      conf.AppConfig.CleanupImage,
+		Entrypoint: cmd[:1],
+		Cmd:        cmd[1:],
+		Mounts:     []ContainerMount{{Source: dir, Target: "/workspace"}},
+		Resources:  &ContainerResources{Network: NetworkNone, User: "0:0"},
+	}, output)
+	if err != nil {
+		return err
+	}
+	if exit.ExitCode != 0 {
+		return fmt.Errorf("exit code %d: %s", exit.ExitCode, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// containerName 任务容器名称，同一任务重复执行时删除上次残留的容器
+func (b *BasePlugin) containerName(suffix string) string {
+	return fmt.Sprintf("kubespace-job-%d-%s", b.JobId, suffix)
+}
+
+// runContainer 运行任务容器并将输出写入 out，容器因内存超限被杀时返回 OOMKilledError，ctx 结束时终止容器
+func (b *BasePlugin) runContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) error {
+	rt, err := b.containerRuntime()
+	if err != nil {
+		return err
+	}
+	spec.Auth = b.registryAuth(spec.Image)
+	fmt.Fprintf(out, "+ %s run %s %s\n", rt.Name(), spec.Image, utils.ShellJoin(append(append([]string{}, spec.Entrypoint...), spec.Cmd...)))
+	klog.Infof("job=%d run container %s image %s cmd %v %v", b.JobId, spec.Name, spec.Image, spec.Entrypoint, spec.Cmd)
+	exit, err := rt.RunContainer(ctx, spec, out)
+	if err != nil {
+		return fmt.Errorf("运行容器错误：%v", err)
+	}
+	if exit.OOMKilled {
+		fmt.Fprintln(out, "容器内存超过上限，已被系统终止（OOMKilled）")
+		return &PluginError{Code: code.OOMKilledError, Err: errors.New("容器内存超过上限被终止（OOMKilled）")}
+	}
+	if exit.ExitCode != 0 {
+		return fmt.Errorf("容器退出码：%d", exit.ExitCode)
+	}
+	return nil
+}
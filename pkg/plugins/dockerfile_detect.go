@@ -0,0 +1,298 @@
+package plugins
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// DockerfileAuto 镜像构建的 Dockerfile 配置为 auto 时根据代码仓库的项目类型自动生成 Dockerfile
+const DockerfileAuto = "auto"
+
+const (
+	ProjectGo     = "go"
+	ProjectMaven  = "maven"
+	ProjectGradle = "gradle"
+	ProjectNode   = "node"
+	ProjectPython = "python"
+	ProjectStatic = "static"
+)
+
+// dockerfileTemplates 内置的 Dockerfile 模板，服务端可通过 DockerfileTemplateDir 中同名文件覆盖
+//
+//go:embed dockerfiles/*.Dockerfile
+var dockerfileTemplates embed.FS
+
+var (
+	goVersionRe     = regexp.MustCompile(`(?m)^go\s+(\d+\.\d+)`)
+	goMainRe        = regexp.MustCompile(`(?m)^package\s+main\b`)
+	mavenJavaRe     = regexp.MustCompile(`<(?:java\.version|maven\.compiler\.(?:source|release))>\s*(?:1\.)?(\d+)`)
+	gradleJavaRe    = regexp.MustCompile(`(?:sourceCompatibility|languageVersion)\s*[=.(]\s*(?:JavaVersion\.VERSION_|JavaLanguageVersion\.of\()?['"]?(?:1[._])?(\d+)`)
+	majorVersionRe  = regexp.MustCompile(`\d+`)
+	pythonVersionRe = regexp.MustCompile(`(\d+\.\d+)`)
+)
+
+// projectInfo 检测到的项目类型及生成 Dockerfile 使用的模板参数
+type projectInfo struct {
+	Language string
+
+	// GoVersion go.mod 中声明的 go 版本，Main 为 main 包路径
+	GoVersion string
+	Main      string
+
+	JavaVersion   string
+	GradleWrapper bool
+
+	NodeVersion    string
+	PackageManager string
+	LockFile       bool
+	BuildScript    bool
+	StartScript    bool
+
+	PythonVersion string
+	Requirements  string
+
+	// Entry 程序入口文件，StaticDir 为静态站点目录
+	Entry     string
+	StaticDir string
+}
+
+type packageJson struct {
+	Main            string            `json:"main"`
+	Scripts         map[string]string `json:"scripts"`
+	Engines         map[string]string `json:"engines"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// detectProject 根据代码目录中的文件检测项目类型，依次检测 go、maven、gradle、node、python 及静态站点
+func detectProject(dir string) (*projectInfo, error) {
+	exists := func(name string) bool {
+		return fileExists(filepath.Join(dir, name))
+	}
+	switch {
+	case exists("go.mod"):
+		return detectGoProject(dir)
+	case exists("pom.xml"):
+		info := &projectInfo{Language: ProjectMaven, JavaVersion: "11"}
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "pom.xml"))
+		if m := mavenJavaRe.FindSubmatch(content); m != nil {
+			info.JavaVersion = string(m[1])
+		}
+		return info, nil
+	case exists("build.gradle") || exists("build.gradle.kts"):
+		info := &projectInfo{Language: ProjectGradle, JavaVersion: "11", GradleWrapper: exists("gradlew")}
+		for _, name := range []string{"build.gradle", "build.gradle.kts"} {
+			content, _ := ioutil.ReadFile(filepath.Join(dir, name))
+			if m := gradleJavaRe.FindSubmatch(content); m != nil {
+				info.JavaVersion = string(m[1])
+			}
+		}
+		return info, nil
+	case exists("package.json"):
+		return detectNodeProject(dir)
+	case exists("requirements.txt") || exists("pyproject.toml") || exists("setup.py"):
+		return detectPythonProject(dir)
+	case exists("index.html"):
+		return &projectInfo{Language: ProjectStatic, StaticDir: "."}, nil
+	}
+	return nil, fmt.Errorf("未能识别项目类型，支持 go、maven、gradle、node、python 及包含 index.html 的静态站点")
+}
+
+func detectGoProject(dir string) (*projectInfo, error) {
+	info := &projectInfo{Language: ProjectGo, GoVersion: "1.17"}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	if m := goVersionRe.FindSubmatch(content); m != nil {
+		info.GoVersion = string(m[1])
+	}
+	if hasGoMain(dir) {
+		info.Main = "."
+		return info, nil
+	}
+	// 根目录不是 main 包时使用 cmd 下唯一的 main 包
+	var mains []string
+	entries, _ := ioutil.ReadDir(filepath.Join(dir, "cmd"))
+	for _, entry := range entries {
+		if entry.IsDir() && hasGoMain(filepath.Join(dir, "cmd", entry.Name())) {
+			mains = append(mains, "./cmd/"+entry.Name())
+		}
+	}
+	if len(mains) != 1 {
+		return nil, fmt.Errorf("go 项目需要在根目录或 cmd 下有且只有一个 main 包，检测到：%v", mains)
+	}
+	info.Main = mains[0]
+	return info, nil
+}
+
+func hasGoMain(dir string) bool {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		content, err := ioutil.ReadFile(file)
+		if err == nil && goMainRe.Match(content) {
+			return true
+		}
+	}
+	return false
+}
+
+func detectNodeProject(dir string) (*projectInfo, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+	pkg := &packageJson{}
+	if err = json.Unmarshal(content, pkg); err != nil {
+		return nil, fmt.Errorf("解析 package.json 失败：%v", err)
+	}
+	info := &projectInfo{
+		Language:       ProjectNode,
+		NodeVersion:    "16",
+		PackageManager: "npm",
+		LockFile:       fileExists(filepath.Join(dir, "package-lock.json")),
+		BuildScript:    pkg.Scripts["build"] != "",
+		StartScript:    pkg.Scripts["start"] != "",
+		Entry:          pkg.Main,
+	}
+	if fileExists(filepath.Join(dir, "yarn.lock")) {
+		info.PackageManager, info.LockFile = "yarn", true
+	}
+	if v := majorVersionRe.FindString(pkg.Engines["node"]); v != "" {
+		info.NodeVersion = v
+	}
+	if !info.StartScript && info.BuildScript {
+		// 只有 build 脚本的前端项目构建为静态站点，create-react-app 输出到 build 目录，其它工具通常为 dist
+		info.StaticDir = "dist"
+		if pkg.Dependencies["react-scripts"] != "" || pkg.DevDependencies["react-scripts"] != "" {
+			info.StaticDir = "build"
+		}
+		return info, nil
+	}
+	if !info.StartScript {
+		if info.Entry == "" {
+			info.Entry = "index.js"
+		}
+		if !fileExists(filepath.Join(dir, info.Entry)) {
+			return nil, fmt.Errorf("node 项目 package.json 中没有 start 脚本，且入口文件%s不存在", info.Entry)
+		}
+	}
+	return info, nil
+}
+
+func detectPythonProject(dir string) (*projectInfo, error) {
+	info := &projectInfo{Language: ProjectPython, PythonVersion: "3.9"}
+	if fileExists(filepath.Join(dir, "requirements.txt")) {
+		info.Requirements = "requirements.txt"
+	}
+	for _, name := range []string{".python-version", "runtime.txt"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if m := pythonVersionRe.FindSubmatch(content); m != nil {
+			info.PythonVersion = string(m[1])
+			break
+		}
+	}
+	for _, entry := range []string{"app.py", "main.py", "server.py"} {
+		if fileExists(filepath.Join(dir, entry)) {
+			info.Entry = entry
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("python 项目未找到入口文件 app.py、main.py 或 server.py")
+}
+
+// dockerfileTemplate 获取项目类型的 Dockerfile 模板，优先使用服务端模板目录中的同名文件
+func dockerfileTemplate(language string) (*template.Template, string, error) {
+	name := language + ".Dockerfile"
+	var content []byte
+	var err error
+	source := "builtin:" + name
+	if dir := conf.AppConfig.DockerfileTemplateDir; dir != "" {
+		path := filepath.Join(dir, name)
+		content, err = ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, "", err
+		}
+		if err == nil {
+			source = path
+		}
+	}
+	if content == nil {
+		if content, err = dockerfileTemplates.ReadFile("dockerfiles/" + name); err != nil {
+			return nil, "", err
+		}
+	}
+	tmpl, err := template.New(name).Parse(string(content))
+	if err != nil {
+		return nil, "", fmt.Errorf("解析 Dockerfile 模板%s失败：%v", source, err)
+	}
+	return tmpl, source, nil
+}
+
+// generateDockerfile 检测代码仓库的项目类型并生成 Dockerfile，返回生成的 Dockerfile 路径，
+// 生成的文件位于代码目录之外，不会影响构建上下文
+func (b *CodeBuilderPlugin) generateDockerfile(index int) (string, error) {
+	info, err := detectProject(b.CodeDir)
+	if err != nil {
+		b.Log("自动生成 Dockerfile 失败：%v", err)
+		return "", err
+	}
+	tmpl, source, err := dockerfileTemplate(info.Language)
+	if err != nil {
+		b.Log("自动生成 Dockerfile 失败：%v", err)
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, info); err != nil {
+		b.Log("生成 Dockerfile 失败：%v", err)
+		return "", fmt.Errorf("生成 Dockerfile 失败：%v", err)
+	}
+	dockerfile := filepath.Join(b.RootDir, fmt.Sprintf("Dockerfile.auto-%d", index))
+	if err = ioutil.WriteFile(dockerfile, buf.Bytes(), 0644); err != nil {
+		b.Log("写入 Dockerfile 失败：%v", err)
+		return "", fmt.Errorf("写入 Dockerfile 失败：%v", err)
+	}
+	b.Log("检测到项目类型：%s，使用模板 %s 生成 Dockerfile：\n%s", info.Language, source, buf.String())
+	return dockerfile, nil
+}
+
+// resolveDockerfile 获取镜像构建使用的 Dockerfile 路径，配置为 auto 或未配置且代码仓库中没有 Dockerfile 时自动生成
+func (b *CodeBuilderPlugin) resolveDockerfile(index int, dockerfile string) (string, error) {
+	if dockerfile == DockerfileAuto {
+		return b.generateDockerfile(index)
+	}
+	explicit := dockerfile != ""
+	if !explicit {
+		dockerfile = "Dockerfile"
+	}
+	path := filepath.Join(b.CodeDir, dockerfile)
+	if fileExists(path) {
+		return path, nil
+	}
+	if explicit {
+		b.Log("代码仓库中不存在 Dockerfile：%s", dockerfile)
+		return "", fmt.Errorf("代码仓库中不存在 Dockerfile：%s", dockerfile)
+	}
+	b.Log("代码仓库中没有 Dockerfile，根据项目类型自动生成")
+	return b.generateDockerfile(index)
+}
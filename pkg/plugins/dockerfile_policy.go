@@ -0,0 +1,262 @@
+package plugins
+
+import (
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/docker"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	PolicyActionWarn = "warn"
+	PolicyActionFail = "fail"
+)
+
+// Dockerfile 检查规则名称
+const (
+	PolicyRuleBaseImage        = "base-image"
+	PolicyRuleAllowedRegistry  = "allowed-registry"
+	PolicyRuleLatestTag        = "latest-tag"
+	PolicyRuleDigestPinning    = "digest-pinning"
+	PolicyRuleNonRootUser      = "non-root-user"
+	PolicyRuleAddUrl           = "add-url"
+	policyRuleDockerfileSyntax = "dockerfile"
+)
+
+// DockerfileViolation 违反 Dockerfile 检查规则的指令
+type DockerfileViolation struct {
+	// Image 构建的镜像，Dockerfile 为代码仓库中的相对路径
+	Image       string `json:"image"`
+	Dockerfile  string `json:"dockerfile"`
+	Line        int    `json:"line"`
+	Rule        string `json:"rule"`
+	Instruction string `json:"instruction"`
+	Message     string `json:"message"`
+}
+
+// dockerfileStage Dockerfile 中的一个构建阶段
+type dockerfileStage struct {
+	name string
+	// internal 基础镜像为之前的构建阶段
+	internal bool
+	// user 阶段最后设置的用户，未设置时为空
+	user     string
+	userLine int
+	from     *docker.Instruction
+}
+
+// validateDockerfilePolicy 校验 Dockerfile 检查规则参数
+func validateDockerfilePolicy(policy *serializers.DockerfilePolicy) error {
+	if policy == nil {
+		return nil
+	}
+	switch policy.Action {
+	case "", PolicyActionWarn, PolicyActionFail:
+	default:
+		return fmt.Errorf("Dockerfile 检查处理方式%s错误，只支持 warn、fail", policy.Action)
+	}
+	for _, r := range policy.AllowedRegistries {
+		if strings.TrimSpace(r) == "" {
+			return fmt.Errorf("Dockerfile 检查允许的镜像仓库不能为空")
+		}
+	}
+	return nil
+}
+
+// expandArgs 使用第一个 FROM 之前 ARG 指令的默认值替换变量，返回替换后的值及是否存在无法替换的变量
+func expandArgs(value string, args map[string]string) (string, bool) {
+	resolved := true
+	expanded := os.Expand(value, func(name string) string {
+		def := ""
+		hasDefault := false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDefault = name[:i], name[i+2:], true
+		}
+		if v, ok := args[name]; ok && v != "" {
+			return v
+		}
+		if !hasDefault {
+			resolved = false
+		}
+		return def
+	})
+	return expanded, resolved
+}
+
+// registryAllowed 判断镜像是否属于允许的仓库或仓库路径前缀
+func registryAllowed(ref *registry.Reference, allowed []string) bool {
+	for _, a := range allowed {
+		a = strings.TrimSuffix(strings.TrimSpace(a), "/")
+		if ref.Registry == a || strings.HasPrefix(ref.Name(), a+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isRootUser(user string) bool {
+	uid := strings.SplitN(user, ":", 2)[0]
+	return uid == "0" || uid == "root"
+}
+
+// lintDockerfile 按规则检查 Dockerfile，返回所有违反规则的指令
+func lintDockerfile(path string, policy *serializers.DockerfilePolicy) ([]*DockerfileViolation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	instructions, err := docker.ParseDockerfile(f)
+	if err != nil {
+		return nil, err
+	}
+	var violations []*DockerfileViolation
+	violate := func(inst *docker.Instruction, rule, format string, a ...interface{}) {
+		violations = append(violations, &DockerfileViolation{
+			Line:        inst.Line,
+			Rule:        rule,
+			Instruction: inst.Original,
+			Message:     fmt.Sprintf(format, a...),
+		})
+	}
+	args := make(map[string]string)
+	var stages []*dockerfileStage
+	for _, inst := range instructions {
+		switch inst.Cmd {
+		case "ARG":
+			if len(stages) > 0 {
+				continue
+			}
+			for _, arg := range inst.Args {
+				kv := strings.SplitN(arg, "=", 2)
+				if len(kv) == 2 {
+					args[kv[0]] = strings.Trim(kv[1], `"'`)
+				} else {
+					args[kv[0]] = ""
+				}
+			}
+		case "FROM":
+			if len(inst.Args) == 0 {
+				violate(inst, policyRuleDockerfileSyntax, "FROM 指令缺少基础镜像")
+				continue
+			}
+			stage := &dockerfileStage{from: inst}
+			if len(inst.Args) >= 3 && strings.EqualFold(inst.Args[1], "as") {
+				stage.name = strings.ToLower(inst.Args[2])
+			}
+			image, resolved := expandArgs(inst.Args[0], args)
+			for _, s := range stages {
+				if s.name != "" && s.name == strings.ToLower(image) {
+					// 基于之前的构建阶段，继承该阶段的用户
+					stage.internal, stage.user, stage.userLine = true, s.user, s.userLine
+				}
+			}
+			stages = append(stages, stage)
+			if stage.internal || image == "scratch" {
+				continue
+			}
+			if !resolved {
+				violate(inst, PolicyRuleBaseImage, "基础镜像%s包含无法解析的变量", inst.Args[0])
+				continue
+			}
+			ref, err := registry.ParseReference(image)
+			if err != nil {
+				violate(inst, PolicyRuleBaseImage, "基础镜像%s格式错误：%v", image, err)
+				continue
+			}
+			if len(policy.AllowedRegistries) > 0 && !registryAllowed(ref, policy.AllowedRegistries) {
+				violate(inst, PolicyRuleAllowedRegistry, "基础镜像%s不属于允许的仓库：%s", image, strings.Join(policy.AllowedRegistries, ", "))
+			}
+			if policy.ForbidLatest && ref.Digest == "" && ref.Tag == registry.DefaultTag {
+				violate(inst, PolicyRuleLatestTag, "基础镜像%s使用 latest tag，请指定固定版本", image)
+			}
+			if policy.RequireDigest && ref.Digest == "" {
+				violate(inst, PolicyRuleDigestPinning, "基础镜像%s未通过 digest 固定版本，如 %s@sha256:...", image, ref.Name())
+			}
+		case "USER":
+			if len(stages) > 0 && len(inst.Args) > 0 {
+				stage := stages[len(stages)-1]
+				stage.user, stage.userLine = inst.Args[0], inst.Line
+			}
+		case "ADD":
+			if !policy.ForbidAddUrl || len(inst.Args) < 2 {
+				continue
+			}
+			for _, src := range inst.Args[:len(inst.Args)-1] {
+				lower := strings.ToLower(src)
+				if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+					violate(inst, PolicyRuleAddUrl, "禁止使用 ADD 下载远程文件%s，请在 RUN 中下载并校验", src)
+				}
+			}
+		}
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("Dockerfile 中没有 FROM 指令")
+	}
+	if policy.RequireNonRootUser {
+		final := stages[len(stages)-1]
+		if final.user == "" {
+			violate(final.from, PolicyRuleNonRootUser, "最终阶段未通过 USER 指令设置非 root 用户")
+		} else if isRootUser(final.user) {
+			violate(&docker.Instruction{Line: final.userLine, Original: "USER " + final.user},
+				PolicyRuleNonRootUser, "最终阶段使用 root 用户%s运行", final.user)
+		}
+	}
+	return violations, nil
+}
+
+// checkDockerfiles 镜像构建前检查所有 Dockerfile，返回各镜像构建使用的 Dockerfile 路径，
+// 违反规则时按配置输出警告或返回包含违规列表的 PolicyError
+func (b *CodeBuilderPlugin) checkDockerfiles() ([]string, error) {
+	var dockerfiles []string
+	for i, buildImage := range b.Params.ImageBuilds {
+		dockerfile, err := b.resolveDockerfile(i, buildImage.Dockerfile)
+		if err != nil {
+			return nil, err
+		}
+		dockerfiles = append(dockerfiles, dockerfile)
+	}
+	policy := b.Params.DockerfilePolicy
+	if policy == nil {
+		return dockerfiles, nil
+	}
+	var violations []*DockerfileViolation
+	for i, dockerfile := range dockerfiles {
+		name := filepath.Base(dockerfile)
+		if rel, err := filepath.Rel(b.CodeDir, dockerfile); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+		vs, err := lintDockerfile(dockerfile, policy)
+		if err != nil {
+			b.Log("检查 Dockerfile %s 失败：%v", name, err)
+			klog.Errorf("job=%d lint dockerfile %s error: %v", b.JobId, dockerfile, err)
+			return nil, fmt.Errorf("检查 Dockerfile %s 失败：%v", name, err)
+		}
+		for _, v := range vs {
+			v.Image, v.Dockerfile = b.Params.ImageBuilds[i].Image, name
+		}
+		violations = append(violations, vs...)
+	}
+	if len(violations) == 0 {
+		b.Log("Dockerfile 检查通过")
+		return dockerfiles, nil
+	}
+	b.Log("Dockerfile 检查发现%d个问题：", len(violations))
+	for _, v := range violations {
+		b.Log("  %s:%d [%s] %s", v.Dockerfile, v.Line, v.Rule, v.Message)
+	}
+	b.Result.DockerfileViolations = violations
+	if policy.Action == PolicyActionFail {
+		return nil, &PluginError{
+			Code: code.PolicyError,
+			Err:  fmt.Errorf("Dockerfile 检查未通过，共%d个问题", len(violations)),
+			Data: b.Result,
+		}
+	}
+	return dockerfiles, nil
+}
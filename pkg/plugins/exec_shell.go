@@ -2,6 +2,7 @@ package plugins
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/kubespace/pipeline-plugin/pkg/utils"
 	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
@@ -9,7 +10,7 @@ import (
 	"golang.org/x/crypto/ssh"
 	"k8s.io/klog"
 	"os"
-	"os/exec"
+	"sort"
 	"strings"
 )
 
@@ -37,8 +38,9 @@ func (b *ExecShell) ExecuteShell(ser *serializers.ExecShellSerializer) *utils.Re
 
 type ExecShellPlugin struct {
 	*BasePlugin
-	Params *serializers.ExecShellSerializer
-	Result map[string]interface{} `json:"env"`
+	Params    *serializers.ExecShellSerializer
+	Resources *ContainerResources
+	Result    map[string]interface{} `json:"env"`
 }
 
 func NewExecShellPlugin(ser *serializers.ExecShellSerializer) (*ExecShellPlugin, error) {
@@ -48,6 +50,31 @@ func NewExecShellPlugin(ser *serializers.ExecShellSerializer) (*ExecShellPlugin,
 		Result:     make(map[string]interface{}),
 	}
 	execPlugin.Executor = execPlugin
+	for name := range ser.Env {
+		if !utils.IsEnvName(name) {
+			return nil, fmt.Errorf("环境变量名%s不合法", name)
+		}
+	}
+	if err := validateArtifacts(ser.Artifacts); err != nil {
+		klog.Errorf("job=%d exec shell artifacts error: %v", ser.JobId, err)
+		return nil, err
+	}
+	if err := validateArtifactInputs(ser.JobId, ser.Inputs); err != nil {
+		klog.Errorf("job=%d exec shell inputs error: %v", ser.JobId, err)
+		return nil, err
+	}
+	if ser.Resource.Type == ResourceTypeImage {
+		resources, err := NewContainerResources(&ser.Resources)
+		if err != nil {
+			klog.Errorf("job=%d exec shell resources error: %v", ser.JobId, err)
+			return nil, err
+		}
+		execPlugin.Resources = resources
+		if err = execPlugin.UseContainerRuntime(ser.ContainerRuntime, &RuntimeRequirements{Resources: resources}); err != nil {
+			klog.Errorf("job=%d container runtime error: %v", ser.JobId, err)
+			return nil, err
+		}
+	}
 
 	return execPlugin, nil
 }
@@ -57,6 +84,9 @@ func (b *ExecShellPlugin) execute() (interface{}, error) {
 		return nil, fmt.Errorf("执行脚本目标资源参数为空，请检查流水线配置")
 	}
 	if b.Params.Resource.Type == ResourceTypeImage {
+		if err := b.InitDockerConfig(resourceRegistry(&b.Params.Resource)); err != nil {
+			return nil, err
+		}
 		if err := b.execImage(); err != nil {
 			return nil, err
 		}
@@ -74,6 +104,9 @@ func (b *ExecShellPlugin) execImage() error {
 	if shell == "" {
 		shell = "bash"
 	}
+	if err := b.fetchInputs(b.RootDir, b.Params.Inputs); err != nil {
+		return err
+	}
 	scriptFile := b.RootDir + "/.script.sh"
 	f, err := os.Create(scriptFile)
 	if err != nil {
@@ -89,17 +122,29 @@ func (b *ExecShellPlugin) execImage() error {
 	scriptFileName := ".script.sh"
 	var envs []string
 	for name, val := range b.Params.Env {
-		envs = append(envs, fmt.Sprintf("%s='%v'", name, val))
-	}
-	envs = append(envs, fmt.Sprintf("WORKDIR='/pipeline'"))
-	env := strings.Join(envs, " ")
-	dockerRunCmd := fmt.Sprintf("docker run --net=host --rm -i -v %s:/pipeline -w /pipeline --entrypoint sh %s -c \"%s %s -x %s 2>&1\"", b.RootDir, image, env, shell, scriptFileName)
-	klog.Infof("job=%d code build cmd: %s", b.JobId, dockerRunCmd)
-	cmd := exec.Command("bash", "-c", dockerRunCmd)
-	cmd.Stdout = b.Logger
-	cmd.Stderr = b.Logger
-	if err := cmd.Run(); err != nil {
+		envs = append(envs, fmt.Sprintf("%s=%v", name, val))
+	}
+	envs = append(envs, "WORKDIR=/pipeline")
+	err = b.runContainer(context.Background(), &ContainerSpec{
+		Name:       b.containerName("shell"),
+		Image:      image,
+		Entrypoint: []string{shell},
+		Cmd:        []string{"-x", scriptFileName},
+		Env:        envs,
+		WorkingDir: "/pipeline",
+		Mounts:     []ContainerMount{{Source: b.RootDir, Target: "/pipeline"}},
+		Resources:  b.Resources,
+	}, b.Logger)
+	var artifactErr error
+	if len(b.Params.Artifacts) > 0 {
+		// 脚本执行失败时同样归档产物
+		_, artifactErr = b.collectArtifacts(b.RootDir, b.Params.Artifacts)
+	}
+	if err != nil {
 		klog.Errorf("job=%d build error: %v", b.JobId, err)
+		if _, ok := err.(*PluginError); ok {
+			return err
+		}
 		return fmt.Errorf("build code error: %v", err)
 	} else {
 		outputBytes, err := os.ReadFile(b.RootDir + "/output")
@@ -123,7 +168,7 @@ func (b *ExecShellPlugin) execImage() error {
 			}
 		}
 	}
-	return nil
+	return artifactErr
 }
 
 func (b *ExecShellPlugin) execSsh() error {
@@ -154,8 +199,30 @@ func (b *ExecShellPlugin) execSsh() error {
 		b.Log("ssh host %s error: %s", host, err.Error())
 		return err
 	}
+	defer client.Close()
 	b.Log("连接主机%s成功", host)
 
+	// 脚本及环境变量通过标准输入写入远程工作目录的文件，不拼接到命令行中，保证任意内容原样传递
+	// 包含密钥的 .env 及脚本写入工作目录之外的 runDir，不会被归档为产物
+	workDir := fmt.Sprintf("/tmp/kubespace/pipeline/%d", b.JobId)
+	runDir := workDir + ".run"
+	// 无论脚本是否执行成功都删除远程工作目录，避免包含密钥的 .env 及脚本残留在主机上
+	defer b.removeHostWorkDir(client, workDir, runDir)
+	env := b.envFile(workDir)
+	if err = sshWriteFile(client, runDir, ".env", env); err != nil {
+		b.Log("写入环境变量文件失败: %s", err.Error())
+		return err
+	}
+	if err = sshWriteFile(client, runDir, ".script.sh", []byte(b.Params.Script)); err != nil {
+		b.Log("写入脚本文件失败: %s", err.Error())
+		return err
+	}
+	if len(b.Params.Inputs) > 0 {
+		if err = b.sendHostInputs(client, workDir); err != nil {
+			return err
+		}
+	}
+
 	// 建立新会话
 	session, err := client.NewSession()
 	if err != nil {
@@ -165,17 +232,15 @@ func (b *ExecShellPlugin) execSsh() error {
 	defer session.Close()
 	b.Log("建立session成功，开始执行脚本")
 	session.Stdout = b.Logger
-	var envs []string
-	for name, val := range b.Params.Env {
-		envs = append(envs, fmt.Sprintf("%s='%v'", name, val))
-	}
-	workDir := fmt.Sprintf("/tmp/kubespace/pipeline/%d", b.JobId)
-	envs = append(envs, fmt.Sprintf("WORKDIR='%s'", workDir))
-	env := strings.Join(envs, " ")
-
-	output := fmt.Sprintf("%s/output", workDir)
-	cmd := fmt.Sprintf("mkdir -p %s && cd %s && rm -rf %s && %s bash -cx '%s' 2>&1", workDir, workDir, output, env, b.Params.Script)
+	session.Stderr = b.Logger
+	cmd := fmt.Sprintf("mkdir -p %s && cd %s && rm -rf output && . %s && bash -x %s 2>&1", utils.ShellQuote(workDir),
+		utils.ShellQuote(workDir), utils.ShellQuote(runDir+"/.env"), utils.ShellQuote(runDir+"/.script.sh"))
 	err = session.Run(cmd)
+	var artifactErr error
+	if len(b.Params.Artifacts) > 0 {
+		// 脚本执行失败时同样归档产物
+		artifactErr = b.collectHostArtifacts(client, workDir)
+	}
 	if err != nil {
 		b.Log("执行脚本失败: %s", err.Error())
 		return err
@@ -188,7 +253,8 @@ func (b *ExecShellPlugin) execSsh() error {
 	defer newSession.Close()
 	buffer := new(bytes.Buffer)
 	newSession.Stdout = buffer
-	cmd = fmt.Sprintf("bash -c '[[ -f %s ]] && cat %s; rm -rf %s'", output, output, workDir)
+	output := workDir + "/output"
+	cmd = fmt.Sprintf("if [ -f %s ]; then cat %s; fi", utils.ShellQuote(output), utils.ShellQuote(output))
 	err = newSession.Run(cmd)
 	if err != nil {
 		b.Log("获取脚本输出%s失败: %s", output, err.Error())
@@ -207,5 +273,117 @@ func (b *ExecShellPlugin) execSsh() error {
 			}
 		}
 	}
+	return artifactErr
+}
+
+// removeHostWorkDir 使用新会话删除远程主机上的任务工作目录及脚本目录
+func (b *ExecShellPlugin) removeHostWorkDir(client *ssh.Client, workDir, runDir string) {
+	session, err := client.NewSession()
+	if err != nil {
+		klog.Errorf("job=%d new session to remove %s error: %v", b.JobId, workDir, err)
+		b.Log("删除远程工作目录%s失败: %s", workDir, err.Error())
+		return
+	}
+	defer session.Close()
+	if err = session.Run("rm -rf " + utils.ShellQuote(workDir) + " " + utils.ShellQuote(runDir)); err != nil {
+		klog.Errorf("job=%d remove %s error: %v", b.JobId, workDir, err)
+		b.Log("删除远程工作目录%s失败: %s", workDir, err.Error())
+	}
+}
+
+// collectHostArtifacts 在远程主机工作目录中展开产物路径并打包传回，解压到本地后归档
+func (b *ExecShellPlugin) collectHostArtifacts(client *ssh.Client, workDir string) error {
+	var patterns []string
+	for _, artifact := range b.Params.Artifacts {
+		patterns = append(patterns, artifact.Paths...)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		b.Log("获取产物文件失败: %s", err.Error())
+		return err
+	}
+	defer session.Close()
+	localDir := b.RootDir + "/.host-artifacts"
+	if err = os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(localDir)
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &bytes.Buffer{}
+	session.Stderr = stderr
+	// 产物路径已校验只包含安全字符，由远程 bash 展开 glob，不存在的路径及工作目录顶层的 .env、.script.sh 不传给 tar，
+	// 由 archiveArtifact 按产物是否 Optional 处理没有匹配文件的情况
+	script := fmt.Sprintf(`cd %s && shopt -s globstar nullglob dotglob && files=() && `+
+		`for f in %s; do case "${f#./}" in .env|.script.sh) continue;; esac; if [ -e "$f" ]; then files+=("$f"); fi; done && `+
+		`if [ ${#files[@]} -gt 0 ]; then tar -cf - --exclude=./.env --exclude=./.script.sh -- "${files[@]}"; fi`,
+		utils.ShellQuote(workDir), strings.Join(patterns, " "))
+	if err = session.Start("bash -c " + utils.ShellQuote(script)); err != nil {
+		b.Log("获取产物文件失败: %s", err.Error())
+		return err
+	}
+	extractErr := extractArtifactTar(stdout, localDir, nil)
+	if err = session.Wait(); err != nil {
+		b.Log("获取产物文件失败: %v: %s", err, strings.TrimSpace(stderr.String()))
+		return err
+	}
+	if extractErr != nil {
+		b.Log("解压产物文件失败: %s", extractErr.Error())
+		return extractErr
+	}
+	_, err = b.collectArtifacts(localDir, b.Params.Artifacts)
+	return err
+}
+
+// sendHostInputs 在本地下载解压依赖的产物，通过 sftp 上传到远程主机工作目录
+func (b *ExecShellPlugin) sendHostInputs(client *ssh.Client, workDir string) error {
+	localDir := b.RootDir + "/.inputs"
+	os.RemoveAll(localDir)
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(localDir)
+	if err := b.fetchInputs(localDir, b.Params.Inputs); err != nil {
+		return err
+	}
+	if err := b.uploadInputs(client, localDir, workDir); err != nil {
+		b.Log("上传依赖产物到主机失败：%v", err)
+		return err
+	}
+	b.Log("上传依赖产物到主机工作目录%s成功", workDir)
+	return nil
+}
+
+// envFile 生成导出环境变量的 shell 文件，变量值经过转义，任意内容原样传递
+func (b *ExecShellPlugin) envFile(workDir string) []byte {
+	var names []string
+	for name := range b.Params.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		fmt.Fprintf(buf, "export %s=%s\n", name, utils.ShellQuote(fmt.Sprintf("%v", b.Params.Env[name])))
+	}
+	fmt.Fprintf(buf, "export WORKDIR=%s\n", utils.ShellQuote(workDir))
+	return buf.Bytes()
+}
+
+// sshWriteFile 通过标准输入将内容写入远程主机 dir 目录下的文件，文件仅当前用户可读
+func sshWriteFile(client *ssh.Client, dir, name string, content []byte) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = bytes.NewReader(content)
+	stderr := &bytes.Buffer{}
+	session.Stderr = stderr
+	cmd := fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s", utils.ShellQuote(dir), utils.ShellQuote(dir+"/"+name))
+	if err = session.Run(cmd); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
 	return nil
 }
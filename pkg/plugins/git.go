@@ -0,0 +1,64 @@
+package plugins
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	sshgit "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/klog"
+	"os"
+	"time"
+)
+
+// gitClone 克隆代码仓库到 dir 目录并检出 commitId
+func (b *BasePlugin) gitClone(dir string, codeUrl string, secret *serializers.Secret, commitId string) error {
+	os.RemoveAll(dir)
+	b.Log("git clone %v", codeUrl)
+	time.Sleep(1)
+	var auth transport.AuthMethod
+	var err error
+	if secret.Type == "key" {
+		privateKey, err := sshgit.NewPublicKeys("git", []byte(secret.PrivateKey), "")
+		if err != nil {
+			return fmt.Errorf("生成代码密钥失败：" + err.Error())
+		}
+		privateKey.HostKeyCallbackHelper = sshgit.HostKeyCallbackHelper{
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}
+		auth = privateKey
+	} else if secret.Type == "password" {
+		auth = &http.BasicAuth{
+			Username: secret.User,
+			Password: secret.Password,
+		}
+	}
+	r, err := git.PlainClone(dir, false, &git.CloneOptions{
+		Auth:     auth,
+		URL:      codeUrl,
+		Progress: b.Logger,
+	})
+	if err != nil {
+		b.Log("克隆代码仓库失败：%v", err)
+		klog.Errorf("job=%d clone %s error: %v", b.JobId, codeUrl, err)
+		return fmt.Errorf("git clone %s error: %v", codeUrl, err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		b.Log("克隆代码仓库失败：%v", err)
+		klog.Errorf("job=%d clone %s error: %v", b.JobId, codeUrl, err)
+		return fmt.Errorf("git clone %s error: %v", codeUrl, err)
+	}
+	err = w.Checkout(&git.CheckoutOptions{
+		Hash: plumbing.NewHash(commitId),
+	})
+	if err != nil {
+		b.Log("git checkout %s 失败：%v", commitId, err)
+		klog.Errorf("job=%d git checkout %s error: %v", b.JobId, commitId, err)
+		return fmt.Errorf("git checkout %s error: %v", commitId, err)
+	}
+	return nil
+}
@@ -0,0 +1,464 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	ImageBuilderDocker  = "docker"
+	ImageBuilderBuildah = "buildah"
+	ImageBuilderKaniko  = "kaniko"
+
+	ImageBuildCacheInline   = "inline"
+	ImageBuildCacheRegistry = "registry"
+	ImageBuildCacheTag      = "buildcache"
+)
+
+// ImageBuildOptions 镜像构建参数
+type ImageBuildOptions struct {
+	// Image 构建并推送的镜像地址
+	Image string
+	// Dockerfile Dockerfile 文件的绝对路径
+	Dockerfile string
+	// Context 构建上下文目录
+	Context string
+	// Platforms 构建的目标平台，为空时构建主机架构的镜像
+	Platforms []string
+	// Cache 构建缓存参数，为空时不使用缓存
+	Cache *ImageBuildCacheOptions
+}
+
+// ImageBuildCacheOptions 镜像构建缓存参数
+type ImageBuildCacheOptions struct {
+	// Mode inline 将缓存元数据写入镜像并推送到 Ref，registry 将所有中间层缓存单独推送到 Ref
+	Mode string
+	// Ref 缓存导出的镜像地址
+	Ref string
+	// From 导入缓存的镜像地址，包含 Ref
+	From []string
+}
+
+// cacheRepo 去掉 tag 的缓存镜像仓库，kaniko、buildah 按构建步骤在该仓库下保存缓存层
+func (c *ImageBuildCacheOptions) cacheRepo() string {
+	if ref, err := registry.ParseReference(c.Ref); err == nil {
+		return ref.Name()
+	}
+	return c.Ref
+}
+
+// ImageBuilder 镜像构建后端，构建镜像并推送到镜像仓库
+type ImageBuilder interface {
+	// BuildAndPush 返回推送后镜像的 digest，无法获取时返回空
+	BuildAndPush(options *ImageBuildOptions) (string, error)
+}
+
+// NewImageBuilder 根据任务参数或服务默认配置创建镜像构建后端
+func NewImageBuilder(name string, plugin *BasePlugin) (ImageBuilder, error) {
+	if name == "" {
+		name = conf.AppConfig.ImageBuilder
+	}
+	switch name {
+	case "", ImageBuilderDocker:
+		return &dockerImageBuilder{BasePlugin: plugin}, nil
+	case ImageBuilderBuildah:
+		return &buildahImageBuilder{BasePlugin: plugin}, nil
+	case ImageBuilderKaniko:
+		return &kanikoImageBuilder{BasePlugin: plugin}, nil
+	}
+	return nil, fmt.Errorf("unknown image builder %s", name)
+}
+
+// dockerImageBuilder 使用主机 docker 守护进程构建镜像，多平台镜像通过 docker buildx 构建
+type dockerImageBuilder struct {
+	*BasePlugin
+}
+
+func (d *dockerImageBuilder) BuildAndPush(options *ImageBuildOptions) (string, error) {
+	cache := options.Cache
+	if len(options.Platforms) > 0 || cache != nil && cache.Mode == ImageBuildCacheRegistry {
+		return d.buildxAndPush(options)
+	}
+	rt, err := d.containerRuntime()
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	imageName := options.Image
+	buildOptions := &RuntimeBuildOptions{
+		Tags:       []string{imageName},
+		Dockerfile: options.Dockerfile,
+		Context:    options.Context,
+		Auths:      d.registries,
+	}
+	if cache != nil {
+		// 经典构建器仅使用本地存在的镜像作为缓存，构建前拉取缓存镜像
+		for _, from := range cache.From {
+			if err = rt.PullImage(ctx, from, d.registryAuth(from), nil); err != nil {
+				d.Log("拉取缓存镜像%s失败，不使用该缓存：%v", from, err)
+				continue
+			}
+			buildOptions.CacheFrom = append(buildOptions.CacheFrom, from)
+		}
+		buildOptions.Tags = append(buildOptions.Tags, cache.Ref)
+	}
+	d.Log("+ %s build -t %s -f %s %s", rt.Name(), strings.Join(buildOptions.Tags, " -t "), options.Dockerfile, options.Context)
+	stats := newBuildCacheStats(d.Logger)
+	if err = rt.BuildImage(ctx, buildOptions, stats); err != nil {
+		d.Log("构建镜像%s错误：%v", imageName, err)
+		klog.Errorf("build image error: %v", err)
+		return "", fmt.Errorf("构建镜像%s错误：%v", imageName, err)
+	}
+	if cache != nil {
+		d.Log("镜像 %s 构建缓存命中：%s", imageName, stats)
+	}
+	defer func() {
+		for _, image := range buildOptions.Tags {
+			if err := rt.RemoveImage(ctx, image); err != nil {
+				d.Log("删除本地镜像%s错误：%v", image, err)
+				klog.Errorf("remove image %s error: %v", image, err)
+			}
+		}
+	}()
+	digest := ""
+	for _, image := range buildOptions.Tags {
+		d.Log("+ %s push %s", rt.Name(), image)
+		pushed, err := rt.PushImage(ctx, image, d.registryAuth(image), d.Logger)
+		if err != nil {
+			d.Log("推送镜像%s错误：%v", image, err)
+			klog.Errorf("push image error: %v", err)
+			return "", fmt.Errorf("推送镜像%s错误：%v", image, err)
+		}
+		if image == imageName {
+			digest = pushed
+		}
+	}
+	return digest, nil
+}
+
+// buildxAndPush 使用 docker buildx 构建多平台镜像或导出 registry 缓存，构建完成后直接推送到仓库
+func (d *dockerImageBuilder) buildxAndPush(options *ImageBuildOptions) (string, error) {
+	builder, err := d.inspectBuildx()
+	if err != nil {
+		d.Log("%v", err)
+		return "", err
+	}
+	if err = builder.check(options); err != nil {
+		d.Log("%v", err)
+		return "", err
+	}
+	imageName := options.Image
+	metadataFile := d.RootDir + "/.buildx-metadata.json"
+	args := []string{"buildx", "build", "--progress=plain", "--push", "--metadata-file", metadataFile}
+	if len(options.Platforms) > 0 {
+		args = append(args, "--platform", strings.Join(options.Platforms, ","))
+	}
+	if cache := options.Cache; cache != nil {
+		for _, from := range cache.From {
+			args = append(args, "--cache-from", "type=registry,ref="+from)
+		}
+		if cache.Mode == ImageBuildCacheInline {
+			args = append(args, "--cache-to", "type=inline", "-t", cache.Ref)
+		} else {
+			args = append(args, "--cache-to", fmt.Sprintf("type=registry,ref=%s,mode=max", cache.Ref))
+		}
+	}
+	args = append(args, "-t", imageName, "-f", options.Dockerfile, options.Context)
+	stats := newBuildCacheStats(d.Logger)
+	cmd := d.traceCommand("docker", args...)
+	cmd.Stdout = stats
+	cmd.Stderr = stats
+	if err = cmd.Run(); err != nil {
+		d.Log("构建镜像%s错误：%v", imageName, err)
+		klog.Errorf("job=%d buildx image error: %v", d.JobId, err)
+		return "", fmt.Errorf("构建镜像%s错误：%v", imageName, err)
+	}
+	if options.Cache != nil {
+		d.Log("镜像 %s 构建缓存命中：%s", imageName, stats)
+	}
+	digest := ""
+	if metadataBytes, err := os.ReadFile(metadataFile); err == nil {
+		metadata := make(map[string]interface{})
+		if err = json.Unmarshal(metadataBytes, &metadata); err == nil {
+			digest, _ = metadata["containerimage.digest"].(string)
+		}
+	}
+	return digest, nil
+}
+
+// buildxBuilder 当前 buildx 构建器的驱动及支持的平台
+type buildxBuilder struct {
+	Name      string
+	Driver    string
+	Platforms map[string]bool
+}
+
+// inspectBuildx 获取当前 buildx 构建器的信息
+func (d *dockerImageBuilder) inspectBuildx() (*buildxBuilder, error) {
+	output := &bytes.Buffer{}
+	cmd := d.Command("docker", "buildx", "inspect", "--bootstrap")
+	cmd.Stdout = output
+	if err := cmd.Run(); err != nil {
+		klog.Errorf("job=%d docker buildx inspect error: %v", d.JobId, err)
+		return nil, fmt.Errorf("获取 buildx 构建器信息失败，请检查主机是否安装 docker buildx：%v", err)
+	}
+	builder := &buildxBuilder{Platforms: make(map[string]bool)}
+	for _, line := range strings.Split(output.String(), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Name:") && builder.Name == "":
+			builder.Name = strings.TrimSpace(strings.TrimPrefix(line, "Name:"))
+		case strings.HasPrefix(line, "Driver:") && builder.Driver == "":
+			builder.Driver = strings.TrimSpace(strings.TrimPrefix(line, "Driver:"))
+		case strings.HasPrefix(line, "Platforms:"):
+			for _, p := range strings.Split(strings.TrimPrefix(line, "Platforms:"), ",") {
+				builder.Platforms[strings.TrimSuffix(strings.TrimSpace(p), "*")] = true
+			}
+		}
+	}
+	return builder, nil
+}
+
+// check 检查构建器是否支持需要构建的所有平台，以及导出 registry 缓存需要的驱动，
+// docker 驱动不支持 mode=max 的 registry 缓存，需使用 docker-container 等驱动
+func (b *buildxBuilder) check(options *ImageBuildOptions) error {
+	if cache := options.Cache; cache != nil && cache.Mode == ImageBuildCacheRegistry && b.Driver == "docker" {
+		return fmt.Errorf("当前 buildx 构建器%s使用 docker 驱动，不支持导出 registry 缓存，"+
+			"请在主机创建并选择 docker-container 驱动的构建器（docker buildx create --driver docker-container --use），或使用 inline 缓存", b.Name)
+	}
+	var unsupported []string
+	for _, platform := range options.Platforms {
+		if !b.Platforms[platform] {
+			unsupported = append(unsupported, platform)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("当前 buildx 构建器不支持平台 %s，请在主机安装 qemu 模拟器（binfmt）或配置对应架构的构建节点",
+			strings.Join(unsupported, ","))
+	}
+	return nil
+}
+
+// buildahImageBuilder 使用 buildah 构建镜像，无需 docker 守护进程，支持 rootless 运行
+// 多平台镜像构建为 manifest list 后整体推送
+type buildahImageBuilder struct {
+	*BasePlugin
+}
+
+func (h *buildahImageBuilder) BuildAndPush(options *ImageBuildOptions) (string, error) {
+	imageName := options.Image
+	digestFile := h.RootDir + "/.image-digest"
+	var buildArgs, pushArgs []string
+	if len(options.Platforms) > 0 {
+		buildArgs = []string{"bud", "--platform", strings.Join(options.Platforms, ","), "--manifest", imageName}
+		pushArgs = []string{"manifest", "push", "--all", "--digestfile", digestFile, imageName, "docker://" + imageName}
+	} else {
+		buildArgs = []string{"bud", "--format", "docker", "-t", imageName}
+		pushArgs = []string{"push", "--digestfile", digestFile, imageName, "docker://" + imageName}
+	}
+	if options.Cache != nil {
+		// buildah 按构建步骤将中间层推送到缓存仓库，inline 与 registry 方式相同
+		cacheRepo := options.Cache.cacheRepo()
+		buildArgs = append(buildArgs, "--layers", "--cache-from", cacheRepo, "--cache-to", cacheRepo)
+	}
+	buildArgs = append(buildArgs, "-f", options.Dockerfile, options.Context)
+	stats := newBuildCacheStats(h.Logger)
+	cmd := h.traceCommand("buildah", buildArgs...)
+	cmd.Stdout = stats
+	cmd.Stderr = stats
+	if err := cmd.Run(); err != nil {
+		h.Log("构建镜像%s错误：%v", imageName, err)
+		klog.Errorf("job=%d buildah build image error: %v", h.JobId, err)
+		return "", fmt.Errorf("构建镜像%s错误：%v", imageName, err)
+	}
+	if options.Cache != nil {
+		h.Log("镜像 %s 构建缓存命中：%s", imageName, stats)
+	}
+	cmd = h.traceCommand("buildah", pushArgs...)
+	if err := cmd.Run(); err != nil {
+		h.Log("推送镜像%s错误：%v", imageName, err)
+		klog.Errorf("job=%d buildah push image error: %v", h.JobId, err)
+		return "", fmt.Errorf("推送镜像%s错误：%v", imageName, err)
+	}
+	digest := readDigestFile(digestFile)
+	if len(options.Platforms) > 0 {
+		cmd = h.traceCommand("buildah", "manifest", "rm", imageName)
+	} else {
+		cmd = h.traceCommand("buildah", "rmi", imageName)
+	}
+	if err := cmd.Run(); err != nil {
+		h.Log("删除本地镜像%s错误：%v", imageName, err)
+		klog.Errorf("job=%d remove image %s error: %v", h.JobId, imageName, err)
+	}
+	return digest, nil
+}
+
+// kanikoImageBuilder 在任务容器运行时中运行 kaniko executor 镜像，在用户空间构建镜像并直接推送，
+// 无需 docker 守护进程的构建能力，kaniko 不支持一次构建多个平台
+type kanikoImageBuilder struct {
+	*BasePlugin
+}
+
+const (
+	kanikoContextDir    = "/kubespace-context"
+	kanikoDockerfileDir = "/kubespace-dockerfile"
+	kanikoOutputDir     = "/kubespace-output"
+	kanikoDockerConfig  = "/kubespace-docker"
+)
+
+func (k *kanikoImageBuilder) BuildAndPush(options *ImageBuildOptions) (string, error) {
+	imageName := options.Image
+	if len(options.Platforms) > 1 {
+		k.Log("kaniko 不支持同时构建多个平台的镜像：%s", strings.Join(options.Platforms, ","))
+		return "", fmt.Errorf("kaniko 不支持同时构建多个平台的镜像")
+	}
+	// kaniko 需以 root 用户解压镜像文件系统，资源限制及网络使用服务端默认配置
+	resources, err := NewContainerResources(&serializers.ContainerResources{User: "0:0"})
+	if err != nil {
+		return "", err
+	}
+	// 构建上下文及 Dockerfile 只读挂载，digest 写入单独的输出目录
+	outputDir := filepath.Join(k.RootDir, ".kaniko")
+	if err = os.MkdirAll(outputDir, 0755); err != nil {
+		return "", fmt.Errorf("mkdir %s error: %v", outputDir, err)
+	}
+	defer os.RemoveAll(outputDir)
+	mounts := []ContainerMount{
+		{Source: options.Context, Target: kanikoContextDir, ReadOnly: true},
+		{Source: outputDir, Target: kanikoOutputDir},
+		{Source: k.DockerConfigDir, Target: kanikoDockerConfig, ReadOnly: true},
+	}
+	dockerfile := kanikoDockerfileDir + "/" + filepath.Base(options.Dockerfile)
+	if rel, err := filepath.Rel(options.Context, options.Dockerfile); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		dockerfile = kanikoContextDir + "/" + filepath.ToSlash(rel)
+	} else {
+		mounts = append(mounts, ContainerMount{Source: filepath.Dir(options.Dockerfile), Target: kanikoDockerfileDir, ReadOnly: true})
+	}
+	args := []string{"--dockerfile", dockerfile, "--context", "dir://" + kanikoContextDir,
+		"--destination", imageName, "--digest-file", kanikoOutputDir + "/digest"}
+	if len(options.Platforms) == 1 {
+		args = append(args, "--custom-platform", options.Platforms[0])
+	}
+	if options.Cache != nil {
+		// kaniko 只支持将 RUN 等步骤的缓存层推送到缓存仓库
+		args = append(args, "--cache=true", "--cache-repo", options.Cache.cacheRepo())
+	}
+	stats := newBuildCacheStats(k.Logger)
+	err = k.runContainer(context.Background(), &ContainerSpec{
+		Name:       k.containerName("kaniko"),
+		Image:      conf.AppConfig.KanikoImage,
+		Entrypoint: []string{"/kaniko/executor"},
+		Cmd:        args,
+		Env:        []string{"DOCKER_CONFIG=" + kanikoDockerConfig},
+		Mounts:     mounts,
+		Resources:  resources,
+	}, stats)
+	if err != nil {
+		k.Log("构建镜像%s错误：%v", imageName, err)
+		klog.Errorf("job=%d kaniko build image error: %v", k.JobId, err)
+		return "", fmt.Errorf("构建镜像%s错误：%v", imageName, err)
+	}
+	if options.Cache != nil {
+		k.Log("镜像 %s 构建缓存命中：%s", imageName, stats)
+	}
+	return readDigestFile(filepath.Join(outputDir, "digest")), nil
+}
+
+func readDigestFile(digestFile string) string {
+	digestBytes, err := os.ReadFile(digestFile)
+	if err != nil {
+		return ""
+	}
+	os.Remove(digestFile)
+	return strings.TrimSpace(string(digestBytes))
+}
+
+var (
+	// BuildKit 输出，如：#8 [builder 3/5] RUN go mod download、#8 CACHED
+	buildkitStepRe   = regexp.MustCompile(`^#(\d+) \[[^\]]*\d+/\d+\]`)
+	buildkitCachedRe = regexp.MustCompile(`^#(\d+) CACHED`)
+	// docker 经典构建器及 buildah 输出，如：Step 3/5 : RUN ...、 ---> Using cache
+	classicStepRe   = regexp.MustCompile(`^(Step|STEP) \d+/\d+ ?:`)
+	classicCachedRe = regexp.MustCompile(`^\s*-+> Using cache`)
+	// kaniko 输出
+	kanikoCachedRe = regexp.MustCompile(`Using caching version of cmd`)
+	kanikoMissRe   = regexp.MustCompile(`No cached layer found for cmd`)
+)
+
+// buildCacheStats 将构建输出写入任务日志，同时统计构建步骤的缓存命中情况
+type buildCacheStats struct {
+	writer io.Writer
+	line   []byte
+	// BuildKit 按步骤编号统计
+	steps       map[string]bool
+	cachedSteps map[string]bool
+	// docker 经典构建器及 buildah
+	classicTotal  int
+	classicCached int
+	// kaniko 只输出可缓存步骤的命中情况
+	kanikoCached int
+	kanikoMissed int
+}
+
+func newBuildCacheStats(writer io.Writer) *buildCacheStats {
+	return &buildCacheStats{
+		writer:      writer,
+		steps:       make(map[string]bool),
+		cachedSteps: make(map[string]bool),
+	}
+}
+
+func (s *buildCacheStats) Write(p []byte) (int, error) {
+	s.line = append(s.line, p...)
+	for {
+		i := bytes.IndexByte(s.line, '\n')
+		if i < 0 {
+			break
+		}
+		s.parseLine(string(s.line[:i]))
+		s.line = s.line[i+1:]
+	}
+	return s.writer.Write(p)
+}
+
+func (s *buildCacheStats) parseLine(line string) {
+	if m := buildkitStepRe.FindStringSubmatch(line); m != nil {
+		s.steps[m[1]] = true
+	} else if m = buildkitCachedRe.FindStringSubmatch(line); m != nil {
+		s.cachedSteps[m[1]] = true
+	} else if classicStepRe.MatchString(line) {
+		s.classicTotal++
+	} else if classicCachedRe.MatchString(line) {
+		s.classicCached++
+	} else if kanikoCachedRe.MatchString(line) {
+		s.kanikoCached++
+	} else if kanikoMissRe.MatchString(line) {
+		s.kanikoMissed++
+	}
+}
+
+// String 缓存命中统计，如：3/5 个构建步骤（60%）
+func (s *buildCacheStats) String() string {
+	total := len(s.steps) + s.classicTotal + s.kanikoCached + s.kanikoMissed
+	cached := s.classicCached + s.kanikoCached
+	for step := range s.cachedSteps {
+		if s.steps[step] {
+			cached++
+		}
+	}
+	if total == 0 {
+		return "无可缓存的构建步骤"
+	}
+	return fmt.Sprintf("%d/%d 个构建步骤（%d%%）", cached, total, cached*100/total)
+}
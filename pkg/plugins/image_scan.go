@@ -0,0 +1,204 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/report"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxResultVulnerabilities 回调结果中每个镜像包含的漏洞数上限
+const maxResultVulnerabilities = 20
+
+// ImageScanResult 一个镜像的漏洞扫描结果，Severities 为各级别的漏洞数
+type ImageScanResult struct {
+	Image      string         `json:"image"`
+	Total      int            `json:"total"`
+	Severities map[string]int `json:"severities"`
+	// Vulnerabilities 按严重程度排序的漏洞，最多包含 maxResultVulnerabilities 个，完整列表保存在数据库中
+	Vulnerabilities []*report.Vulnerability `json:"vulnerabilities,omitempty"`
+}
+
+// validateImageScan 校验镜像漏洞扫描参数
+func validateImageScan(scan *serializers.ImageScan) error {
+	if scan == nil {
+		return nil
+	}
+	for _, thresholds := range []map[string]int{scan.FailThresholds, scan.UnstableThresholds} {
+		for severity, max := range thresholds {
+			if report.VulnSeverityRank(severity) == len(report.VulnSeverities) {
+				return fmt.Errorf("漏洞级别%s错误，只支持 %s", severity, strings.Join(report.VulnSeverities, "、"))
+			}
+			if max < 0 {
+				return fmt.Errorf("%s级别漏洞数上限不能小于 0", severity)
+			}
+		}
+	}
+	return nil
+}
+
+// imageScanDbDir trivy 离线漏洞库目录
+func imageScanDbDir() string {
+	if dir := conf.AppConfig.ImageScanDbDir; dir != "" {
+		return dir
+	}
+	return conf.AppConfig.DataDir + "/.trivy"
+}
+
+// scanImages 使用 trivy 扫描推送的所有镜像，漏洞保存到数据库，超过阈值时返回 QualityGateError 或 Unstable
+func (b *CodeBuilderPlugin) scanImages() error {
+	scan := b.Params.ImageScan
+	if scan == nil || len(b.Result.Builds) == 0 {
+		return nil
+	}
+	dbDir, _ := filepath.Abs(imageScanDbDir())
+	if !fileExists(filepath.Join(dbDir, "db", "trivy.db")) {
+		b.Log("离线漏洞库%s/db/trivy.db不存在，请先下载漏洞库", dbDir)
+		return fmt.Errorf("离线漏洞库%s不存在", dbDir)
+	}
+	scanDir := filepath.Join(b.RootDir, ".image-scan")
+	if err := os.MkdirAll(scanDir, 0755); err != nil {
+		return err
+	}
+	var records []*types.PipelineJobVulnerability
+	counts := make(map[string]int)
+	for i, build := range b.Result.Builds {
+		vulns, err := b.scanImage(i, build, dbDir, scanDir, scan.IgnoreUnfixed)
+		if err != nil {
+			return err
+		}
+		result := &ImageScanResult{Image: build.Image, Total: len(vulns), Severities: make(map[string]int)}
+		for _, severity := range report.VulnSeverities {
+			result.Severities[severity] = 0
+		}
+		for _, v := range vulns {
+			result.Severities[v.Severity]++
+			counts[v.Severity]++
+			records = append(records, &types.PipelineJobVulnerability{
+				Image:            truncate(build.Image, 500),
+				Target:           truncate(v.Target, 500),
+				VulnerabilityId:  truncate(v.VulnerabilityId, 100),
+				PkgName:          truncate(v.PkgName, 255),
+				InstalledVersion: truncate(v.InstalledVersion, 255),
+				FixedVersion:     truncate(v.FixedVersion, 255),
+				Severity:         v.Severity,
+				Title:            truncate(v.Title, maxCaseMessageSize),
+				PrimaryUrl:       truncate(v.PrimaryUrl, 1000),
+			})
+		}
+		sort.SliceStable(vulns, func(i, j int) bool {
+			return report.VulnSeverityRank(vulns[i].Severity) < report.VulnSeverityRank(vulns[j].Severity)
+		})
+		if len(vulns) > maxResultVulnerabilities {
+			vulns = vulns[:maxResultVulnerabilities]
+		}
+		result.Vulnerabilities = vulns
+		b.Result.ImageScans = append(b.Result.ImageScans, result)
+		var summary []string
+		for _, severity := range report.VulnSeverities {
+			summary = append(summary, fmt.Sprintf("%s %d", severity, result.Severities[severity]))
+		}
+		b.Log("镜像%s共%d个漏洞：%s", build.Image, result.Total, strings.Join(summary, "，"))
+		for _, v := range vulns {
+			b.Log("  [%s] %s %s %s -> %s", v.Severity, v.VulnerabilityId, v.PkgName, v.InstalledVersion, v.FixedVersion)
+		}
+	}
+	if err := models.Models.VulnerabilityManager.Save(b.JobId, records); err != nil {
+		klog.Errorf("job=%d save vulnerabilities error: %v", b.JobId, err)
+		b.Log("保存漏洞扫描结果失败：%v", err)
+		return fmt.Errorf("保存漏洞扫描结果失败：%v", err)
+	}
+	if violations := exceededThresholds(counts, scan.FailThresholds); len(violations) > 0 {
+		b.Result.ImageScanViolations = violations
+		b.Log("镜像漏洞扫描未通过：%s", strings.Join(violations, "；"))
+		return &PluginError{
+			Code: code.QualityGateError,
+			Err:  fmt.Errorf("镜像漏洞扫描未通过：%s", strings.Join(violations, "；")),
+			Data: b.Result,
+		}
+	}
+	if violations := exceededThresholds(counts, scan.UnstableThresholds); len(violations) > 0 {
+		b.Result.ImageScanViolations = violations
+		b.Log("镜像漏洞数超过警告上限，任务标记为不稳定：%s", strings.Join(violations, "；"))
+		return &PluginError{
+			Code: code.Unstable,
+			Err:  fmt.Errorf("镜像漏洞数超过警告上限：%s", strings.Join(violations, "；")),
+			Data: b.Result,
+		}
+	}
+	b.Log("镜像漏洞扫描通过")
+	return nil
+}
+
+// scanImage 在 trivy 容器中离线扫描镜像，镜像 digest 已知时按 digest 扫描，
+// 多平台镜像扫描与主机架构相同的平台
+func (b *CodeBuilderPlugin) scanImage(index int, build *ImageBuildResult, dbDir, scanDir string, ignoreUnfixed bool) ([]*report.Vulnerability, error) {
+	image := build.Image
+	ref, err := registry.ParseReference(build.Image)
+	if err == nil && build.Digest != "" {
+		image = ref.WithDigest(build.Digest).String()
+	}
+	output := fmt.Sprintf("trivy-%d.json", index+1)
+	args := []string{"image", "--cache-dir", "/trivy", "--skip-db-update", "--skip-java-db-update", "--offline-scan",
+		"--scanners", "vuln", "--format", "json", "--output", scanMountDir + "/" + output, "--quiet", "--timeout", "30m"}
+	if ignoreUnfixed {
+		args = append(args, "--ignore-unfixed")
+	}
+	if err == nil && conf.AppConfig.IsInsecureRegistry(ref.Registry) {
+		args = append(args, "--insecure")
+	}
+	args = append(args, image)
+	// 漏洞库只读挂载，trivy 的镜像层缓存写入容器内的 /trivy 目录，多个任务互不影响
+	mounts := []ContainerMount{
+		{Source: filepath.Join(dbDir, "db"), Target: "/trivy/db", ReadOnly: true},
+		{Source: scanDir, Target: scanMountDir},
+		{Source: b.DockerConfigDir, Target: "/kubespace-docker", ReadOnly: true},
+	}
+	if info, err := os.Stat(filepath.Join(dbDir, "java-db")); err == nil && info.IsDir() {
+		mounts = append(mounts, ContainerMount{Source: filepath.Join(dbDir, "java-db"), Target: "/trivy/java-db", ReadOnly: true})
+	}
+	b.Log("扫描镜像%s漏洞", image)
+	err = b.runContainer(context.Background(), &ContainerSpec{
+		Name:       b.containerName(fmt.Sprintf("image-scan-%d", index+1)),
+		Image:      conf.AppConfig.ImageScanImage,
+		Entrypoint: []string{"trivy"},
+		Cmd:        args,
+		Env:        []string{"DOCKER_CONFIG=/kubespace-docker"},
+		Mounts:     mounts,
+	}, b.Logger)
+	if err != nil {
+		klog.Errorf("job=%d scan image %s error: %v", b.JobId, image, err)
+		b.Log("扫描镜像%s失败：%v", image, err)
+		return nil, fmt.Errorf("扫描镜像%s失败：%v", image, err)
+	}
+	content, err := readReport(filepath.Join(scanDir, output))
+	if err != nil {
+		return nil, fmt.Errorf("读取镜像%s扫描结果失败：%v", image, err)
+	}
+	vulns, err := report.ParseTrivy(content)
+	if err != nil {
+		return nil, fmt.Errorf("解析镜像%s扫描结果失败：%v", image, err)
+	}
+	return vulns, nil
+}
+
+// exceededThresholds 返回超过阈值的级别说明
+func exceededThresholds(counts map[string]int, thresholds map[string]int) []string {
+	var violations []string
+	for _, severity := range report.VulnSeverities {
+		if max, ok := thresholds[severity]; ok && counts[severity] > max {
+			violations = append(violations, fmt.Sprintf("%s级别漏洞%d个，超过上限%d个", severity, counts[severity], max))
+		}
+	}
+	return violations
+}
@@ -0,0 +1,55 @@
+package plugins
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixWriter 在每行输出前添加前缀后写入任务日志，多个 prefixWriter 共用同一把锁时按整行写入，
+// 并发执行的容器输出不会交错在同一行中
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+func newPrefixWriter(out io.Writer, prefix string, mu *sync.Mutex) *prefixWriter {
+	if mu == nil {
+		mu = &sync.Mutex{}
+	}
+	return &prefixWriter{mu: mu, out: out, prefix: []byte(prefix)}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush 写入最后不以换行结尾的输出
+func (w *prefixWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := append(w.buf, '\n')
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.out.Write(append(append([]byte{}, w.prefix...), line...))
+	return err
+}
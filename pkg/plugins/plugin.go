@@ -1,46 +1,77 @@
 package plugins
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/kubespace/pipeline-plugin/pkg/conf"
 	"github.com/kubespace/pipeline-plugin/pkg/models"
 	"github.com/kubespace/pipeline-plugin/pkg/utils"
 	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
 	"io"
 	"k8s.io/klog"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 )
 
 const (
 	PluginBuildCodeToImage = "build_code_to_image"
+	PluginPromoteImage     = "promote_image"
+	PluginCodeScan         = "code_scan"
 )
 
 type PluginExecutor interface {
 	execute() (interface{}, error)
 }
 
+// PluginError 插件执行错误，Code 作为回调结果的错误码，Data 作为回调结果的数据
+type PluginError struct {
+	Code string
+	Err  error
+	Data interface{}
+}
+
+func (e *PluginError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
 type BasePlugin struct {
 	PluginType string
 	RootDir    string
-	LogFile    string
-	CloseLog   chan struct{}
-	JobId      uint
-	Executor   PluginExecutor
-	Logger     io.Writer
+	// DockerConfigDir 任务独立的 docker 配置目录，保存该任务的镜像仓库认证信息
+	DockerConfigDir string
+	LogFile         string
+	CloseLog        chan struct{}
+	JobId           uint
+	Executor        PluginExecutor
+	Logger          io.Writer
+	// Runtime 运行构建、脚本容器的容器运行时
+	Runtime ContainerRuntime
+	// registries 任务使用的镜像仓库认证信息
+	registries []*serializers.ImageRegistry
 }
 
 func NewBasePlugin(jobId uint, pluginType string) *BasePlugin {
 	rootDir := fmt.Sprintf("%s/%d", conf.AppConfig.DataDir, jobId)
 	logFile := fmt.Sprintf(rootDir + "/.klog")
 	return &BasePlugin{
-		RootDir:    rootDir,
-		JobId:      jobId,
-		LogFile:    logFile,
-		PluginType: pluginType,
-		CloseLog:   make(chan struct{}),
+		RootDir:         rootDir,
+		DockerConfigDir: rootDir + "/.docker",
+		JobId:           jobId,
+		LogFile:         logFile,
+		PluginType:      pluginType,
+		CloseLog:        make(chan struct{}),
+		Runtime:         defaultRuntime,
 	}
 }
 
@@ -74,7 +105,115 @@ func (b *BasePlugin) InitRootDir(pluginType string, pluginParams interface{}) er
 	return nil
 }
 
+// InitDockerConfig 将镜像仓库认证信息写入任务独立的 DOCKER_CONFIG 目录，替代 docker login
+// 多个任务并发执行时互不覆盖，任务结束后随 Clear 删除；容器运行时拉取、推送镜像时使用同一份认证信息
+func (b *BasePlugin) InitDockerConfig(registries ...*serializers.ImageRegistry) error {
+	for _, reg := range registries {
+		if reg != nil && reg.User != "" && reg.Password != "" {
+			b.registries = append(b.registries, reg)
+		}
+	}
+	if err := writeDockerConfig(b.DockerConfigDir, b.registries); err != nil {
+		klog.Errorf("job=%d write docker config error: %v", b.JobId, err)
+		return err
+	}
+	// docker 从 DOCKER_CONFIG 目录查找 buildx 等命令行插件，链接主机上安装的插件
+	hostPlugins := hostDockerConfigDir() + "/cli-plugins"
+	if _, err := os.Stat(hostPlugins); err == nil {
+		if err = os.Symlink(hostPlugins, b.DockerConfigDir+"/cli-plugins"); err != nil && !os.IsExist(err) {
+			klog.Errorf("job=%d link docker cli plugins error: %v", b.JobId, err)
+		}
+	}
+	return nil
+}
+
+// writeDockerConfig 将镜像仓库认证信息写入 dir 目录下的 config.json
+func writeDockerConfig(dir string, registries []*serializers.ImageRegistry) error {
+	auths := make(map[string]map[string]string)
+	for _, reg := range registries {
+		if reg == nil || reg.User == "" || reg.Password == "" {
+			continue
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte(reg.User + ":" + reg.Password))
+		auths[authServer(reg.Registry)] = map[string]string{"auth": auth}
+	}
+	configBytes, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err != nil {
+		return fmt.Errorf("marshal docker config error: %v", err)
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("mkdir %s error: %v", dir, err)
+	}
+	if err = os.WriteFile(dir+"/config.json", configBytes, 0600); err != nil {
+		return fmt.Errorf("write docker config error: %v", err)
+	}
+	return nil
+}
+
+// Command 创建执行命令，输出写入任务日志，docker 等命令使用任务独立的镜像仓库认证信息
+func (b *BasePlugin) Command(name string, arg ...string) *exec.Cmd {
+	cmd := exec.Command(name, arg...)
+	// buildah、podman 通过 REGISTRY_AUTH_FILE 读取同一份认证信息
+	cmd.Env = append(os.Environ(), "DOCKER_CONFIG="+b.DockerConfigDir, "REGISTRY_AUTH_FILE="+b.DockerConfigDir+"/config.json")
+	if os.Getenv("BUILDX_CONFIG") == "" {
+		// buildx 构建器实例仍使用主机 docker 配置目录下的配置
+		cmd.Env = append(cmd.Env, "BUILDX_CONFIG="+hostDockerConfigDir()+"/buildx")
+	}
+	cmd.Stdout = b.Logger
+	cmd.Stderr = b.Logger
+	return cmd
+}
+
+// traceCommand 创建执行命令并在任务日志中打印转义后的命令行，参数以 argv 形式传递，不经过 shell 解析
+func (b *BasePlugin) traceCommand(name string, arg ...string) *exec.Cmd {
+	b.Log("+ %s", utils.ShellJoin(append([]string{name}, arg...)))
+	return b.Command(name, arg...)
+}
+
+// hostDockerConfigDir 主机 docker 命令默认的配置目录
+func hostDockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return home + "/.docker"
+}
+
+// ClearWorkspace 清理任务工作目录中除日志外的所有文件
+// 容器以 root 用户在挂载目录中创建的文件无法被非 root 运行的插件删除，此时通过辅助容器删除
+func (b *BasePlugin) ClearWorkspace() error {
+	entries, err := os.ReadDir(b.RootDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var removeErr error
+	for _, entry := range entries {
+		path := filepath.Join(b.RootDir, entry.Name())
+		if path == b.LogFile {
+			continue
+		}
+		if err = os.RemoveAll(path); err != nil && removeErr == nil {
+			removeErr = err
+		}
+	}
+	if removeErr == nil {
+		return nil
+	}
+	klog.Warningf("job=%d remove workspace error: %v, try to remove by helper container", b.JobId, removeErr)
+	if err = removeByHelperContainer(b.Runtime, b.RootDir, filepath.Base(b.LogFile)); err != nil {
+		klog.Errorf("job=%d remove workspace by helper container error: %v", b.JobId, err)
+		return fmt.Errorf("%v; 辅助容器删除失败：%v", removeErr, err)
+	}
+	return nil
+}
+
 func (b *BasePlugin) Clear() {
+	if err := os.RemoveAll(b.DockerConfigDir); err != nil {
+		klog.Errorf("job=%d remove docker config dir %s error: %v", b.JobId, b.DockerConfigDir, err)
+	}
 	time.Sleep(3 * time.Second)
 	if err := os.RemoveAll(b.RootDir); err != nil {
 		klog.Errorf("job=%d remove root dir %s error: %v", b.JobId, b.RootDir, err)
@@ -101,7 +240,7 @@ func (b *BasePlugin) FlushLogToDB() {
 			klog.Info("close log update")
 			err := models.Models.JobLogManager.UpdateLog(b.JobId, b.LogFile)
 			if err != nil {
-				klog.Errorf("update job %s log error: %s", b.JobId, err.Error())
+				klog.Errorf("update job %d log error: %s", b.JobId, err.Error())
 			}
 			return
 		case <-tick.C:
@@ -113,7 +252,7 @@ func (b *BasePlugin) FlushLogToDB() {
 				if logStat == nil || currLogStat.ModTime() != logStat.ModTime() {
 					err := models.Models.JobLogManager.UpdateLog(b.JobId, b.LogFile)
 					if err != nil {
-						klog.Errorf("update job %s log error: %s", b.JobId, err.Error())
+						klog.Errorf("update job %d log error: %s", b.JobId, err.Error())
 					}
 				}
 			}
@@ -151,11 +290,20 @@ func (b *BasePlugin) Execute(pluginParams interface{}) {
 	go b.FlushLogToDB()
 	defer close(b.CloseLog)
 	result, err := b.Executor.execute()
+	resp := &utils.Response{Code: code.Success, Data: result}
 	if err != nil {
-		b.Callback(&utils.Response{Code: code.ExecError, Msg: err.Error()})
-		return
+		resp = &utils.Response{Code: code.ExecError, Msg: err.Error()}
+		var pluginErr *PluginError
+		if errors.As(err, &pluginErr) {
+			resp.Code = pluginErr.Code
+			resp.Data = pluginErr.Data
+		}
+	}
+	if err = b.ClearWorkspace(); err != nil {
+		b.Log("清理任务工作目录失败：%v", err)
+		resp.Msg = strings.TrimSpace(resp.Msg + "\n清理任务工作目录失败：" + err.Error())
 	}
-	b.Callback(&utils.Response{Code: code.Success, Data: result})
+	b.Callback(resp)
 }
 
 func (b *BasePlugin) Callback(resp *utils.Response) {
@@ -0,0 +1,166 @@
+package plugins
+
+import (
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"k8s.io/klog"
+	"sort"
+	"strings"
+)
+
+type ImagePromoter struct{}
+
+func NewImagePromoter() *ImagePromoter {
+	return &ImagePromoter{}
+}
+
+func (p *ImagePromoter) PromoteImage(ser *serializers.PromoteImageSerializer) *utils.Response {
+	if len(ser.Images) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "晋级镜像列表为空"}
+	}
+	if ser.TargetRegistry.Registry == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "目标镜像仓库为空"}
+	}
+	if err := defaultSourceRegistry(ser); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	promotePlugin, err := NewImagePromoterPlugin(ser)
+	if err != nil {
+		return &utils.Response{Code: code.InitError, Msg: err.Error()}
+	}
+
+	go promotePlugin.Execute(ser)
+
+	return &utils.Response{Code: code.Success}
+}
+
+// defaultSourceRegistry 指定了源仓库认证但未指定源仓库地址时，使用镜像中的仓库地址，
+// 镜像属于多个仓库时无法确定认证信息所属的仓库，返回错误
+func defaultSourceRegistry(ser *serializers.PromoteImageSerializer) error {
+	if ser.SourceRegistry.Registry != "" || ser.SourceRegistry.User == "" {
+		return nil
+	}
+	for _, image := range ser.Images {
+		image = strings.TrimSpace(image)
+		if image == "" {
+			continue
+		}
+		ref, err := registry.ParseReference(image)
+		if err != nil {
+			return fmt.Errorf("解析镜像%s错误：%v", image, err)
+		}
+		if ser.SourceRegistry.Registry == "" {
+			ser.SourceRegistry.Registry = ref.Registry
+		} else if !registry.SameRegistry(ser.SourceRegistry.Registry, ref.Registry) {
+			return fmt.Errorf("晋级镜像属于多个仓库，请指定源镜像仓库")
+		}
+	}
+	return nil
+}
+
+type ImagePromoterPlugin struct {
+	*BasePlugin
+	Params *serializers.PromoteImageSerializer
+	Result *ImagePromoterPluginResult
+}
+
+type ImagePromoterPluginResult struct {
+	Images   string           `json:"images"`
+	Promoted []*PromotedImage `json:"promoted"`
+}
+
+type PromotedImage struct {
+	Source    string            `json:"source"`
+	Target    string            `json:"target"`
+	Digest    string            `json:"digest"`
+	Platforms map[string]string `json:"platforms,omitempty"`
+}
+
+func NewImagePromoterPlugin(ser *serializers.PromoteImageSerializer) (*ImagePromoterPlugin, error) {
+	promotePlugin := &ImagePromoterPlugin{
+		BasePlugin: NewBasePlugin(ser.JobId, PluginPromoteImage),
+		Params:     ser,
+		Result:     &ImagePromoterPluginResult{},
+	}
+	promotePlugin.Executor = promotePlugin
+
+	return promotePlugin, nil
+}
+
+func (p *ImagePromoterPlugin) execute() (interface{}, error) {
+	var images []string
+	for _, image := range p.Params.Images {
+		image = strings.TrimSpace(image)
+		if image == "" {
+			continue
+		}
+		promoted, err := p.promote(image)
+		if err != nil {
+			return nil, err
+		}
+		p.Result.Promoted = append(p.Result.Promoted, promoted)
+		images = append(images, promoted.Target)
+	}
+	p.Result.Images = strings.Join(images, ",")
+	return p.Result, nil
+}
+
+// promote 将镜像从源仓库复制到目标仓库，目标镜像与源镜像同名，tag 按 TagMapping 映射
+func (p *ImagePromoterPlugin) promote(image string) (*PromotedImage, error) {
+	srcRegistry := p.Params.SourceRegistry.Registry
+	if srcRegistry != "" && !strings.HasPrefix(image, srcRegistry+"/") {
+		image = srcRegistry + "/" + image
+	}
+	srcRef, err := registry.ParseReference(image)
+	if err != nil {
+		p.Log("解析镜像%s错误：%v", image, err)
+		return nil, fmt.Errorf("解析镜像%s错误：%v", image, err)
+	}
+	if srcRef.Tag == "" {
+		p.Log("镜像%s未指定tag", image)
+		return nil, fmt.Errorf("镜像%s未指定tag", image)
+	}
+	dstTag := srcRef.Tag
+	if tag, ok := p.Params.TagMapping[srcRef.Tag]; ok && tag != "" {
+		dstTag = tag
+	}
+	dstRef := &registry.Reference{
+		Registry:   p.Params.TargetRegistry.Registry,
+		Repository: srcRef.Repository,
+		Tag:        dstTag,
+	}
+	if len(p.Params.Platforms) > 0 {
+		p.Log("晋级镜像 %s -> %s，平台：%s", srcRef, dstRef, strings.Join(p.Params.Platforms, ","))
+	} else {
+		p.Log("晋级镜像 %s -> %s", srcRef, dstRef)
+	}
+	src := newRegistryClient(srcRef.Registry, &p.Params.SourceRegistry)
+	dst := newRegistryClient(dstRef.Registry, &p.Params.TargetRegistry)
+	result, err := registry.CopyImage(src, srcRef, dst, dstRef, &registry.CopyOptions{
+		Platforms: p.Params.Platforms,
+		Progress:  p.Logger,
+	})
+	if err != nil {
+		p.Log("晋级镜像%s错误：%v", image, err)
+		klog.Errorf("job=%d promote image %s error: %v", p.JobId, image, err)
+		return nil, fmt.Errorf("晋级镜像%s错误：%v", image, err)
+	}
+	var platforms []string
+	for platform := range result.Platforms {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	for _, platform := range platforms {
+		p.Log("%s %s", platform, result.Platforms[platform])
+	}
+	p.Log("晋级镜像 %s 成功，digest: %s", dstRef, result.Digest)
+	return &PromotedImage{
+		Source:    srcRef.String(),
+		Target:    dstRef.String(),
+		Digest:    result.Digest,
+		Platforms: result.Platforms,
+	}, nil
+}
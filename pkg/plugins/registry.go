@@ -0,0 +1,35 @@
+package plugins
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+)
+
+// newRegistryClient 创建访问镜像仓库的客户端，仅当 cred 与镜像所在仓库一致时携带认证信息
+func newRegistryClient(registryHost string, cred *serializers.ImageRegistry) *registry.Client {
+	options := &registry.Options{
+		Insecure: conf.AppConfig.IsInsecureRegistry(registryHost),
+	}
+	if cred != nil && cred.User != "" && registry.SameRegistry(registryHost, cred.Registry) {
+		options.Username = cred.User
+		options.Password = cred.Password
+	}
+	return registry.NewClient(registryHost, options)
+}
+
+// resourceRegistry 镜像类型流水线资源的仓库认证信息，用于拉取构建或执行脚本的镜像
+func resourceRegistry(resource *serializers.PipelineResource) *serializers.ImageRegistry {
+	if resource.Secret.User == "" || resource.Secret.Password == "" {
+		return nil
+	}
+	ref, err := registry.ParseReference(resource.Value)
+	if err != nil {
+		return nil
+	}
+	return &serializers.ImageRegistry{
+		Registry: ref.Registry,
+		User:     resource.Secret.User,
+		Password: resource.Secret.Password,
+	}
+}
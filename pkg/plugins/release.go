@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"crypto"
 	"errors"
 	"fmt"
 	"github.com/go-git/go-git/v5"
@@ -13,11 +14,11 @@ import (
 	"github.com/kubespace/pipeline-plugin/pkg/models"
 	"github.com/kubespace/pipeline-plugin/pkg/utils"
 	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
 	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
 	"golang.org/x/crypto/ssh"
 	"k8s.io/klog"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -30,8 +31,8 @@ func NewReleaser() *Releaser {
 }
 
 func (b *Releaser) Release(ser *serializers.ReleaseSerializer) *utils.Response {
-	if ser.Version == "" {
-		return &utils.Response{Code: code.ParamsError, Msg: "发布版本号为空"}
+	if err := validateVersioning(ser); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
 	}
 	releasePlugin, err := NewReleaserPlugin(ser)
 	if err != nil {
@@ -49,11 +50,28 @@ type ReleaserPlugin struct {
 	CodeDir string
 	Result  *ReleaserPluginResult
 	Images  []string
+	// ImageTag 发布镜像的 tag，由发布版本转换
+	ImageTag string
 }
 
 type ReleaserPluginResult struct {
 	Version string `json:"version"`
 	Images  string `json:"images"`
+	// PreviousVersion 开启语义化版本时空间已发布的最新版本
+	PreviousVersion string `json:"previous_version,omitempty"`
+	// Bump 自动计算版本号时升级的部分
+	Bump string `json:"bump,omitempty"`
+	// Verifications 打标签前校验通过的镜像签名
+	Verifications []*SignatureResult `json:"verifications,omitempty"`
+	// Signatures 发布镜像的签名
+	Signatures []*SignatureResult `json:"signatures,omitempty"`
+}
+
+// releaseImage 待发布的镜像，content 为打标签前获取并校验过的镜像清单
+type releaseImage struct {
+	ref     *registry.Reference
+	client  *registry.Client
+	content *registry.ManifestContent
 }
 
 func NewReleaserPlugin(ser *serializers.ReleaseSerializer) (*ReleaserPlugin, error) {
@@ -73,22 +91,56 @@ func NewReleaserPlugin(ser *serializers.ReleaseSerializer) (*ReleaserPlugin, err
 	absCodeDir, _ := filepath.Abs(releaserPlugin.RootDir + "/" + codeDir)
 	releaserPlugin.CodeDir = absCodeDir
 	releaserPlugin.Executor = releaserPlugin
+	for _, key := range ser.VerifyKeys {
+		if err := validateSigningKey(key, false); err != nil {
+			klog.Errorf("job=%d image verify key error: %v", ser.JobId, err)
+			return nil, err
+		}
+	}
+	if ser.Signing != nil {
+		if err := validateSigningKey(ser.Signing.Key, true); err != nil {
+			klog.Errorf("job=%d image signing error: %v", ser.JobId, err)
+			return nil, err
+		}
+	}
 
 	return releaserPlugin, nil
 }
 
 func (r *ReleaserPlugin) execute() (interface{}, error) {
-	err := models.Models.PipelineReleaseManager.Add(r.Params.WorkspaceId, r.Params.Version, r.JobId)
+	// 发布前获取镜像清单并校验签名，校验失败时不记录发布版本
+	images, err := r.resolveImages()
 	if err != nil {
 		return nil, err
 	}
+	var repo *git.Repository
+	var auth transport.AuthMethod
 	if r.Params.CodeUrl != "" {
-		if err = r.clone(); err != nil {
+		if repo, auth, err = r.clone(); err != nil {
+			return nil, err
+		}
+	}
+	if err = r.resolveVersion(repo); err != nil {
+		return nil, err
+	}
+	// 记录发布版本及推送 git tag 前校验镜像 tag，避免发布中途失败后版本无法重用
+	if len(images) > 0 {
+		if r.ImageTag, err = releaseImageTag(r.Params.Version); err != nil {
+			r.Log("%v", err)
+			return nil, err
+		}
+	}
+	err = models.Models.PipelineReleaseManager.Add(r.Params.WorkspaceId, r.Params.Version, r.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if repo != nil {
+		if err = r.pushTag(repo, auth); err != nil {
 			return nil, err
 		}
 	}
-	if r.Params.Images != "" {
-		if err = r.tagImage(); err != nil {
+	if len(images) > 0 {
+		if err = r.tagImage(images); err != nil {
 			return nil, err
 		}
 		r.Result.Images = strings.Join(r.Images, ",")
@@ -96,7 +148,7 @@ func (r *ReleaserPlugin) execute() (interface{}, error) {
 	return r.Result, nil
 }
 
-func (r *ReleaserPlugin) clone() error {
+func (r *ReleaserPlugin) clone() (*git.Repository, transport.AuthMethod, error) {
 	os.RemoveAll(r.CodeDir)
 	r.Log("git clone %v", r.Params.CodeUrl)
 	time.Sleep(1)
@@ -105,7 +157,7 @@ func (r *ReleaserPlugin) clone() error {
 	if r.Params.CodeSecret.Type == "key" {
 		privateKey, err := sshgit.NewPublicKeys("git", []byte(r.Params.CodeSecret.PrivateKey), "")
 		if err != nil {
-			return fmt.Errorf("生成代码密钥失败：" + err.Error())
+			return nil, nil, fmt.Errorf("生成代码密钥失败：" + err.Error())
 		}
 		privateKey.HostKeyCallbackHelper = sshgit.HostKeyCallbackHelper{
 			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
@@ -125,13 +177,13 @@ func (r *ReleaserPlugin) clone() error {
 	if err != nil {
 		r.Log("克隆代码仓库失败：%v", err)
 		klog.Errorf("job=%d clone %s error: %v", r.BasePlugin.JobId, r.Params.CodeUrl, err)
-		return fmt.Errorf("git clone %s error: %v", r.Params.CodeUrl, err)
+		return nil, nil, fmt.Errorf("git clone %s error: %v", r.Params.CodeUrl, err)
 	}
 	w, err := repo.Worktree()
 	if err != nil {
 		r.Log("克隆代码仓库失败：%v", err)
 		klog.Errorf("job=%d clone %s error: %v", r.BasePlugin.JobId, r.Params.CodeUrl, err)
-		return fmt.Errorf("git clone %s error: %v", r.Params.CodeUrl, err)
+		return nil, nil, fmt.Errorf("git clone %s error: %v", r.Params.CodeUrl, err)
 	}
 	err = w.Checkout(&git.CheckoutOptions{
 		Hash: plumbing.NewHash(r.Params.CodeCommitId),
@@ -139,10 +191,15 @@ func (r *ReleaserPlugin) clone() error {
 	if err != nil {
 		r.Log("git checkout %s 失败：%v", r.Params.CodeCommitId, err)
 		klog.Errorf("job=%d git checkout %s error: %v", r.BasePlugin.JobId, r.Params.CodeCommitId, err)
-		return fmt.Errorf("git checkout %s error: %v", r.Params.CodeCommitId, err)
+		return nil, nil, fmt.Errorf("git checkout %s error: %v", r.Params.CodeCommitId, err)
 	}
+	return repo, auth, nil
+}
+
+// pushTag 为发布版本创建 git tag 并推送到代码仓库
+func (r *ReleaserPlugin) pushTag(repo *git.Repository, auth transport.AuthMethod) error {
 	r.Log("git tag %s", r.Params.Version)
-	_, err = repo.CreateTag(r.Params.Version, plumbing.NewHash(r.Params.CodeCommitId), &git.CreateTagOptions{
+	_, err := repo.CreateTag(r.Params.Version, plumbing.NewHash(r.Params.CodeCommitId), &git.CreateTagOptions{
 		Message: r.Params.Version,
 		Tagger: &object.Signature{
 			Name:  "kubespace",
@@ -172,74 +229,89 @@ func (r *ReleaserPlugin) clone() error {
 	return nil
 }
 
-func (r *ReleaserPlugin) tagImage() error {
-	images := strings.Split(r.Params.Images, ",")
-	if r.Params.ImageBuildRegistry.User != "" && r.Params.ImageBuildRegistry.Password != "" {
-		if err := r.loginDocker(r.Params.ImageBuildRegistry.User, r.Params.ImageBuildRegistry.Password, r.Params.ImageBuildRegistry.Registry); err != nil {
-			r.Log("docker login %s error: %v", r.Params.ImageBuildRegistry.Registry, err)
-			klog.Errorf("docker login %s error: %v", r.Params.ImageBuildRegistry.Registry, err)
+// resolveImages 获取待发布镜像的清单，配置校验密钥时校验镜像签名，未签名或清单被篡改的镜像不能发布
+func (r *ReleaserPlugin) resolveImages() ([]*releaseImage, error) {
+	var keys []crypto.PublicKey
+	for _, name := range r.Params.VerifyKeys {
+		key, err := loadVerifyKey(name)
+		if err != nil {
+			r.Log("%v", err)
+			return nil, err
 		}
+		keys = append(keys, key)
 	}
-	for _, image := range images {
-		if err := r.tagAndPushImage(image); err != nil {
-			return err
+	var images []*releaseImage
+	for _, image := range strings.Split(r.Params.Images, ",") {
+		image = strings.TrimSpace(image)
+		if image == "" {
+			continue
+		}
+		ref, err := registry.ParseReference(image)
+		if err != nil {
+			r.Log("解析镜像%s错误：%v", image, err)
+			return nil, fmt.Errorf("解析镜像%s错误：%v", image, err)
+		}
+		client := newRegistryClient(ref.Registry, &r.Params.ImageBuildRegistry)
+		content, err := client.GetManifest(ref.Repository, ref.Identifier())
+		if err != nil {
+			r.Log("获取镜像%s清单错误：%v", image, err)
+			klog.Errorf("job=%d get manifest of %s error: %v", r.JobId, image, err)
+			return nil, fmt.Errorf("获取镜像%s清单错误：%v", image, err)
 		}
+		if digest := registry.Digest(content.Body); digest != content.Digest || ref.Digest != "" && digest != ref.Digest {
+			r.Log("镜像%s清单 digest %s 与仓库返回的 %s 不一致", image, digest, content.Digest)
+			return nil, fmt.Errorf("镜像%s清单 digest 校验失败", image)
+		}
+		if len(keys) > 0 {
+			result, err := r.verifyImage(client, ref, content.Digest, r.Params.VerifyKeys, keys)
+			if err != nil {
+				return nil, err
+			}
+			r.Result.Verifications = append(r.Result.Verifications, result)
+		}
+		images = append(images, &releaseImage{ref: ref, client: client, content: content})
 	}
-	return nil
+	return images, nil
 }
 
-func (r *ReleaserPlugin) loginDocker(user string, password string, server string) error {
-	r.Log("docker login %s", server)
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("docker login -u %s -p %s %s", user, password, server))
-	cmd.Stdout = r.Logger
-	cmd.Stderr = r.Logger
-	return cmd.Run()
-}
-
-func (r *ReleaserPlugin) tagAndPushImage(image string) error {
-	dockerBuildCmd := fmt.Sprintf("docker pull %s", image)
-	cmd := exec.Command("bash", "-xc", dockerBuildCmd)
-	cmd.Stdout = r.Logger
-	cmd.Stderr = r.Logger
-	if err := cmd.Run(); err != nil {
-		r.Log("拉取镜像%s错误：%v", image, err)
-		klog.Errorf("pull image error: %v", err)
-		return fmt.Errorf("拉取镜像%s错误：%v", image, err)
-	}
-	newImage := strings.Split(image, ":")[0] + ":" + r.Params.Version
-	cmd = exec.Command("bash", "-xc", "docker tag "+image+" "+newImage)
-	cmd.Stdout = r.Logger
-	cmd.Stderr = r.Logger
-	if err := cmd.Run(); err != nil {
-		r.Log("镜像打标签%s错误：%v", image, err)
-		klog.Errorf("tag image error: %v", err)
-		return fmt.Errorf("镜像打标签%s错误：%v", image, err)
-	}
-	if err := r.pushImage(newImage); err != nil {
-		return err
+func (r *ReleaserPlugin) tagImage(images []*releaseImage) error {
+	var signer crypto.Signer
+	if r.Params.Signing != nil {
+		var err error
+		if signer, err = loadSigningKey(r.Params.Signing.Key); err != nil {
+			r.Log("%v", err)
+			return err
+		}
 	}
-	r.Images = append(r.Images, newImage)
-	rmiImage := fmt.Sprintf("docker rmi %s && docker rmi %s", image, newImage)
-	cmd = exec.Command("bash", "-xc", rmiImage)
-	cmd.Stdout = r.Logger
-	cmd.Stderr = r.Logger
-	if err := cmd.Run(); err != nil {
-		r.Log("删除本地镜像%s错误：%v", image, err)
-		klog.Errorf("rmi image error: %v", err)
-		return fmt.Errorf("删除本地构建镜像%s错误：%v", image, err)
+	for _, image := range images {
+		newRef, err := r.tagAndPushImage(image)
+		if err != nil {
+			return err
+		}
+		if signer == nil {
+			continue
+		}
+		result, err := r.signImage(image.client, newRef, image.content.Digest, r.Params.Signing.Key, signer)
+		if err != nil {
+			return err
+		}
+		r.Result.Signatures = append(r.Result.Signatures, result)
 	}
 	return nil
 }
 
-func (r *ReleaserPlugin) pushImage(imageUrl string) error {
-	pushCmd := fmt.Sprintf("docker push %s", imageUrl)
-	cmd := exec.Command("bash", "-xc", pushCmd)
-	cmd.Stdout = r.Logger
-	cmd.Stderr = r.Logger
-	if err := cmd.Run(); err != nil {
-		r.Log("docker push %s：%v", imageUrl, err)
-		klog.Errorf("push image error: %v", err)
-		return fmt.Errorf("推送镜像%s错误：%v", imageUrl, err)
+// tagAndPushImage 在镜像仓库服务端将校验过的镜像清单上传为发布版本 tag，无需拉取镜像
+func (r *ReleaserPlugin) tagAndPushImage(image *releaseImage) (*registry.Reference, error) {
+	ref := image.ref
+	newRef := ref.WithTag(r.ImageTag)
+	r.Log("镜像 %s 打标签 %s", ref.String(), newRef.String())
+	digest, err := image.client.PutManifest(ref.Repository, r.ImageTag, image.content.MediaType, image.content.Body)
+	if err != nil {
+		r.Log("镜像打标签%s错误：%v", ref.String(), err)
+		klog.Errorf("job=%d tag image %s error: %v", r.JobId, ref.String(), err)
+		return nil, fmt.Errorf("镜像打标签%s错误：%v", ref.String(), err)
 	}
-	return nil
+	r.Log("推送镜像 %s 成功，digest: %s", newRef.String(), digest)
+	r.Images = append(r.Images, newRef.String())
+	return newRef, nil
 }
@@ -0,0 +1,190 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/semver"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"k8s.io/klog"
+	"strings"
+)
+
+// BumpAuto 根据 conventional commits 提交信息计算版本升级部分
+const BumpAuto = "auto"
+
+// maxBumpCommits 自动计算版本时读取的提交数上限
+const maxBumpCommits = 1000
+
+// validateVersioning 校验发布版本参数，未开启语义化版本时只要求版本号不为空
+func validateVersioning(ser *serializers.ReleaseSerializer) error {
+	versioning := ser.Versioning
+	if versioning == nil {
+		if ser.Version == "" {
+			return fmt.Errorf("发布版本号为空")
+		}
+		return nil
+	}
+	switch versioning.Bump {
+	case "", semver.BumpMajor, semver.BumpMinor, semver.BumpPatch:
+	case BumpAuto:
+		if ser.Version == "" && ser.CodeUrl == "" {
+			return fmt.Errorf("根据提交信息计算版本号需要指定代码仓库")
+		}
+	default:
+		return fmt.Errorf("版本升级方式%s错误，只支持 major、minor、patch、auto", versioning.Bump)
+	}
+	if versioning.Prefix != "" && versioning.Prefix != "v" {
+		return fmt.Errorf("版本号前缀只支持 v")
+	}
+	if ser.Version == "" {
+		if versioning.Bump == "" {
+			return fmt.Errorf("发布版本号为空")
+		}
+		return nil
+	}
+	if _, err := semver.Parse(ser.Version); err != nil {
+		return fmt.Errorf("发布版本号%s不符合语义化版本格式", ser.Version)
+	}
+	return nil
+}
+
+// releaseImageTag 发布版本对应的镜像 tag，镜像 tag 不支持 +，语义化版本的构建信息与 docker 工具一致使用 _ 分隔
+func releaseImageTag(version string) (string, error) {
+	tag := strings.ReplaceAll(version, "+", "_")
+	if !registry.ValidTag(tag) {
+		return "", fmt.Errorf("发布版本%s不能作为镜像 tag，只能包含字母、数字及._-，且不超过128个字符", version)
+	}
+	return tag, nil
+}
+
+// resolveVersion 校验发布版本大于空间已发布的最新版本，未指定版本号时在最新版本的基础上自动升级，
+// 不符合语义化版本的历史版本号及当前任务之前执行时发布的版本不参与比较
+func (r *ReleaserPlugin) resolveVersion(repo *git.Repository) error {
+	versioning := r.Params.Versioning
+	if versioning == nil {
+		return nil
+	}
+	versions, err := models.Models.PipelineReleaseManager.Versions(r.Params.WorkspaceId, r.JobId)
+	if err != nil {
+		klog.Errorf("job=%d list release versions error: %v", r.JobId, err)
+		return fmt.Errorf("获取已发布版本失败：%v", err)
+	}
+	var latest *semver.Version
+	latestRaw := ""
+	for _, raw := range versions {
+		v, err := semver.Parse(raw)
+		if err != nil {
+			continue
+		}
+		if latest == nil || v.Compare(latest) > 0 {
+			latest, latestRaw = v, raw
+		}
+	}
+	r.Result.PreviousVersion = latestRaw
+	if r.Params.Version != "" {
+		v, err := semver.Parse(r.Params.Version)
+		if err != nil {
+			return fmt.Errorf("发布版本号%s不符合语义化版本格式", r.Params.Version)
+		}
+		if latest != nil && v.Compare(latest) <= 0 {
+			r.Log("发布版本%s必须大于最新版本%s", r.Params.Version, latestRaw)
+			return fmt.Errorf("发布版本%s必须大于最新版本%s", r.Params.Version, latestRaw)
+		}
+		return nil
+	}
+	base := latest
+	if base == nil {
+		base = &semver.Version{}
+	}
+	bump := versioning.Bump
+	if bump == BumpAuto {
+		if repo == nil {
+			return fmt.Errorf("根据提交信息计算版本号需要指定代码仓库")
+		}
+		messages, err := r.commitMessagesSince(repo, latestRaw)
+		if err != nil {
+			r.Log("读取提交信息失败：%v", err)
+			return fmt.Errorf("读取提交信息失败：%v", err)
+		}
+		bump = semver.ConventionalBump(messages)
+		r.Log("自 %s 以来共%d个提交，升级 %s 版本", latestRaw, len(messages), bump)
+	}
+	next, err := base.Bump(bump)
+	if err != nil {
+		return err
+	}
+	if versioning.Prefix != "" {
+		next.Prefix = versioning.Prefix
+	}
+	r.Params.Version = next.String()
+	r.Result.Version = r.Params.Version
+	r.Result.Bump = bump
+	r.Log("发布版本：%s", r.Params.Version)
+	return nil
+}
+
+// commitMessagesSince 获取发布提交与 tag 之间的提交信息，即 git log <tag>..<commit>，
+// tag 为空或不存在时返回发布提交的所有历史提交
+func (r *ReleaserPlugin) commitMessagesSince(repo *git.Repository, tag string) ([]string, error) {
+	released := make(map[plumbing.Hash]bool)
+	if tag != "" {
+		tagCommit, err := tagCommitHash(repo, tag)
+		if err != nil {
+			r.Log("代码仓库中未找到 tag %s，使用全部提交计算版本", tag)
+		} else {
+			iter, err := repo.Log(&git.LogOptions{From: tagCommit})
+			if err != nil {
+				return nil, err
+			}
+			err = iter.ForEach(func(c *object.Commit) error {
+				released[c.Hash] = true
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	iter, err := repo.Log(&git.LogOptions{From: plumbing.NewHash(r.Params.CodeCommitId)})
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		if released[c.Hash] {
+			return nil
+		}
+		if len(messages) >= maxBumpCommits {
+			return storer.ErrStop
+		}
+		messages = append(messages, c.Message)
+		return nil
+	})
+	return messages, err
+}
+
+// tagCommitHash tag 指向的提交，支持附注 tag 及轻量 tag
+func tagCommitHash(repo *git.Repository, tag string) (plumbing.Hash, error) {
+	ref, err := repo.Tag(tag)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	tagObject, err := repo.TagObject(ref.Hash())
+	if err == nil {
+		commit, err := tagObject.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return commit.Hash, nil
+	}
+	if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return plumbing.ZeroHash, err
+	}
+	return ref.Hash(), nil
+}
@@ -0,0 +1,301 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"k8s.io/klog"
+	"sync"
+	"time"
+)
+
+// ContainerRuntime 容器运行时，执行构建、脚本容器以及本地镜像的构建、推送
+type ContainerRuntime interface {
+	// Name 运行时名称
+	Name() string
+	// Capabilities 最近一次检测的运行时能力
+	Capabilities() *RuntimeCapabilities
+	// detect 检测运行时是否可用及支持的功能
+	detect() *RuntimeCapabilities
+	// RunContainer 创建并运行容器直到容器退出，容器输出写入 out，容器退出后删除容器，
+	// 本地不存在镜像时使用 spec.Auth 拉取镜像
+	RunContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) (*ContainerExit, error)
+	// StartContainer 创建并在后台运行容器，返回容器 id，容器需调用 RemoveContainer 删除
+	StartContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) (string, error)
+	// ContainerStatus 获取后台容器的运行及健康检查状态
+	ContainerStatus(ctx context.Context, id string) (*ContainerStatus, error)
+	// ContainerLogs 读取后台容器当前的所有输出
+	ContainerLogs(ctx context.Context, id string, out io.Writer) error
+	// RemoveContainer 强制删除容器
+	RemoveContainer(ctx context.Context, id string) error
+	// CreateNetwork 创建 bridge 网络，同一网络中的容器可以通过别名互相访问
+	CreateNetwork(ctx context.Context, name string) error
+	// RemoveNetwork 删除网络
+	RemoveNetwork(ctx context.Context, name string) error
+	// BuildImage 构建本地镜像，构建输出写入 out
+	BuildImage(ctx context.Context, options *RuntimeBuildOptions, out io.Writer) error
+	// PullImage 拉取镜像
+	PullImage(ctx context.Context, image string, auth *serializers.ImageRegistry, out io.Writer) error
+	// PushImage 推送本地镜像，返回推送后镜像的 digest，无法获取时返回空
+	PushImage(ctx context.Context, image string, auth *serializers.ImageRegistry, out io.Writer) (string, error)
+	// RemoveImage 删除本地镜像
+	RemoveImage(ctx context.Context, image string) error
+}
+
+// ContainerSpec 运行容器的参数
+type ContainerSpec struct {
+	Name       string
+	Image      string
+	Entrypoint []string
+	Cmd        []string
+	// Env 容器环境变量，格式为 key=value
+	Env        []string
+	WorkingDir string
+	Mounts     []ContainerMount
+	// Resources 容器资源限制、网络及运行用户，为空时不限制
+	Resources *ContainerResources
+	// Network 容器加入的网络，不为空时替代 Resources 中的网络模式
+	Network string
+	// Aliases 容器在 Network 网络中的别名
+	Aliases []string
+	// HealthCheck 容器健康检查，为空时使用镜像中定义的检查
+	HealthCheck *ContainerHealthCheck
+	// Auth 拉取镜像的仓库认证信息
+	Auth *serializers.ImageRegistry
+}
+
+// ContainerMount 挂载到容器的主机目录
+type ContainerMount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// ContainerHealthCheck 容器健康检查参数
+type ContainerHealthCheck struct {
+	// Cmd 通过容器内的 shell 执行的检查命令，退出码为 0 时健康
+	Cmd         string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
+// 容器健康检查状态
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// ContainerStatus 后台容器的运行状态
+type ContainerStatus struct {
+	Running  bool
+	ExitCode int
+	// Health 健康检查状态，未配置健康检查时为空
+	Health string
+}
+
+// ContainerExit 容器退出状态
+type ContainerExit struct {
+	ExitCode  int
+	OOMKilled bool
+}
+
+// RuntimeBuildOptions 容器运行时构建本地镜像的参数
+type RuntimeBuildOptions struct {
+	Tags []string
+	// Dockerfile Dockerfile 文件的绝对路径
+	Dockerfile string
+	// Context 构建上下文目录
+	Context   string
+	CacheFrom []string
+	// BuildArgs 构建参数
+	BuildArgs map[string]string
+	// Auths 拉取基础镜像的仓库认证信息
+	Auths []*serializers.ImageRegistry
+}
+
+// RuntimeCapabilities 容器运行时是否可用及支持的功能
+type RuntimeCapabilities struct {
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+	Version   string `json:"version"`
+	// CpuLimit、MemoryLimit、PidsLimit 是否支持限制容器的 cpu、内存及进程数
+	CpuLimit    bool `json:"cpu_limit"`
+	MemoryLimit bool `json:"memory_limit"`
+	PidsLimit   bool `json:"pids_limit"`
+	// Build 是否支持构建本地镜像
+	Build bool `json:"build"`
+	// BuildKit 是否支持 docker buildx 多平台构建及导出 registry 缓存
+	BuildKit bool `json:"buildkit"`
+	// Services 是否支持在任务网络中运行带别名及健康检查的服务容器
+	Services bool `json:"services"`
+}
+
+// RuntimeRequirements 任务需要容器运行时支持的功能
+type RuntimeRequirements struct {
+	Resources *ContainerResources
+	Build     bool
+	BuildKit  bool
+	Services  bool
+}
+
+// check 检查运行时是否满足任务需要的功能
+func (c *RuntimeCapabilities) check(name string, req *RuntimeRequirements) error {
+	if !c.Available {
+		return fmt.Errorf("容器运行时%s不可用：%s", name, c.Error)
+	}
+	if req == nil {
+		return nil
+	}
+	if res := req.Resources; res != nil {
+		if res.Cpus > 0 && !c.CpuLimit {
+			return fmt.Errorf("容器运行时%s不支持限制容器cpu", name)
+		}
+		if res.Memory > 0 && !c.MemoryLimit {
+			return fmt.Errorf("容器运行时%s不支持限制容器内存", name)
+		}
+		if res.Pids > 0 && !c.PidsLimit {
+			return fmt.Errorf("容器运行时%s不支持限制容器进程数", name)
+		}
+	}
+	if req.Build && !c.Build {
+		return fmt.Errorf("容器运行时%s不支持构建镜像，请使用 buildah 或 kaniko 构建", name)
+	}
+	if req.Services && !c.Services {
+		return fmt.Errorf("容器运行时%s不支持服务容器", name)
+	}
+	if req.BuildKit && !c.BuildKit {
+		return fmt.Errorf("容器运行时%s不支持多平台构建及导出 registry 缓存，请使用 docker 运行时或 buildah 构建", name)
+	}
+	return nil
+}
+
+// runtimeCapabilities 保存运行时最近一次检测的能力，嵌入各运行时实现
+type runtimeCapabilities struct {
+	mu   sync.RWMutex
+	caps *RuntimeCapabilities
+}
+
+func (r *runtimeCapabilities) Capabilities() *RuntimeCapabilities {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.caps == nil {
+		return &RuntimeCapabilities{Error: "未检测"}
+	}
+	return r.caps
+}
+
+func (r *runtimeCapabilities) setCapabilities(caps *RuntimeCapabilities) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.caps = caps
+}
+
+var (
+	runtimesMu sync.Mutex
+	// runtimes 服务支持的所有容器运行时
+	runtimes = make(map[string]ContainerRuntime)
+	// defaultRuntime 任务未指定时使用的容器运行时
+	defaultRuntime ContainerRuntime
+)
+
+// InitContainerRuntimes 创建并检测所有容器运行时，不可用的运行时仅打印警告，
+// 使用 buildah 等不依赖容器运行时的构建后端时仍可以启动服务
+func InitContainerRuntimes() error {
+	dockerRt, err := newDockerRuntime(RuntimeDocker, conf.AppConfig.DockerHost, conf.AppConfig.DockerCertPath)
+	if err != nil {
+		return err
+	}
+	podmanRt, err := newDockerRuntime(RuntimePodman, conf.AppConfig.PodmanHost, conf.AppConfig.PodmanCertPath)
+	if err != nil {
+		return err
+	}
+	nerdctlRt := newNerdctlRuntime(conf.AppConfig.NerdctlPath, conf.AppConfig.ContainerdAddress, conf.AppConfig.ContainerdNamespace)
+	for _, rt := range []ContainerRuntime{dockerRt, podmanRt, nerdctlRt} {
+		caps := rt.detect()
+		if caps.Available {
+			klog.Infof("container runtime %s %s capabilities: %+v", rt.Name(), caps.Version, *caps)
+		} else {
+			klog.Warningf("container runtime %s is unavailable: %s", rt.Name(), caps.Error)
+		}
+		runtimes[rt.Name()] = rt
+	}
+	name := conf.AppConfig.ContainerRuntime
+	if name == "" {
+		name = RuntimeDocker
+	}
+	if defaultRuntime = runtimes[name]; defaultRuntime == nil {
+		return fmt.Errorf("unknown container runtime %s", name)
+	}
+	return nil
+}
+
+// GetContainerRuntime 获取任务指定的容器运行时，name 为空时使用默认运行时，
+// 启动时不可用的运行时重新检测一次，守护进程在服务之后启动时无需重启服务
+func GetContainerRuntime(name string) (ContainerRuntime, error) {
+	rt := defaultRuntime
+	if name != "" {
+		rt = runtimes[name]
+	}
+	if rt == nil {
+		if name == "" {
+			return nil, fmt.Errorf("容器运行时未初始化")
+		}
+		return nil, fmt.Errorf("未知的容器运行时%s", name)
+	}
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+	if !rt.Capabilities().Available {
+		rt.detect()
+	}
+	return rt, nil
+}
+
+// UseContainerRuntime 设置任务使用的容器运行时，运行时不可用或不支持任务需要的功能时返回错误
+func (b *BasePlugin) UseContainerRuntime(name string, req *RuntimeRequirements) error {
+	rt, err := GetContainerRuntime(name)
+	if err != nil {
+		return err
+	}
+	if err = rt.Capabilities().check(rt.Name(), req); err != nil {
+		return err
+	}
+	b.Runtime = rt
+	return nil
+}
+
+// containerEnv 容器环境变量，非 root 用户在镜像中通常没有可写的 HOME 目录
+func containerEnv(spec *ContainerSpec) []string {
+	env := append([]string{}, spec.Env...)
+	if res := spec.Resources; res != nil && res.User != "" && !res.isRoot() {
+		env = append(env, "HOME=/tmp")
+	}
+	return env
+}
+
+// registryAuth 查找镜像所在仓库的认证信息，任务未配置该仓库时返回空
+func (b *BasePlugin) registryAuth(image string) *serializers.ImageRegistry {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return nil
+	}
+	for _, reg := range b.registries {
+		if reg != nil && reg.User != "" && registry.SameRegistry(ref.Registry, reg.Registry) {
+			return reg
+		}
+	}
+	return nil
+}
+
+// containerRuntime 任务使用的容器运行时
+func (b *BasePlugin) containerRuntime() (ContainerRuntime, error) {
+	if b.Runtime == nil {
+		return nil, fmt.Errorf("容器运行时未初始化")
+	}
+	return b.Runtime, nil
+}
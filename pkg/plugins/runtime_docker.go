@@ -0,0 +1,294 @@
+package plugins
+
+import (
+	"context"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/docker"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"k8s.io/klog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	RuntimeDocker = "docker"
+	// RuntimePodman 通过 podman system service 提供的 docker 兼容 api 访问
+	RuntimePodman = "podman"
+)
+
+// dockerRuntime 通过 docker engine api 访问守护进程的容器运行时，同时用于 podman
+type dockerRuntime struct {
+	runtimeCapabilities
+	name   string
+	client *docker.Client
+}
+
+func newDockerRuntime(name string, host string, certPath string) (*dockerRuntime, error) {
+	client, err := docker.NewClient(host, &docker.Options{TLSCertPath: certPath})
+	if err != nil {
+		return nil, err
+	}
+	return &dockerRuntime{name: name, client: client}, nil
+}
+
+func (d *dockerRuntime) Name() string {
+	return d.name
+}
+
+func (d *dockerRuntime) detect() *RuntimeCapabilities {
+	caps := &RuntimeCapabilities{}
+	defer d.setCapabilities(caps)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := d.client.Info(ctx)
+	if err != nil {
+		caps.Error = err.Error()
+		return caps
+	}
+	caps.Available = true
+	caps.Version = info.ServerVersion
+	caps.CpuLimit = info.CpuCfsQuota
+	caps.MemoryLimit = info.MemoryLimit
+	caps.PidsLimit = info.PidsLimit
+	caps.Build = true
+	caps.Services = true
+	if d.name == RuntimeDocker {
+		// buildx 通过 docker 命令行插件执行
+		caps.BuildKit = exec.Command("docker", "buildx", "version").Run() == nil
+	}
+	return caps
+}
+
+// dockerAuth 转换为 docker api 的仓库认证信息
+func dockerAuth(reg *serializers.ImageRegistry) *docker.AuthConfig {
+	if reg == nil || reg.User == "" {
+		return nil
+	}
+	return &docker.AuthConfig{
+		Username:      reg.User,
+		Password:      reg.Password,
+		ServerAddress: authServer(reg.Registry),
+	}
+}
+
+// authServer 仓库认证信息中的仓库地址，docker hub 使用 docker cli 的默认地址
+func authServer(registryHost string) string {
+	if registryHost == "" || registryHost == registry.DefaultRegistry {
+		return "https://index.docker.io/v1/"
+	}
+	return registryHost
+}
+
+// hostConfig 将容器挂载及资源限制转换为 docker api 参数
+func (d *dockerRuntime) hostConfig(spec *ContainerSpec) *docker.HostConfig {
+	hostConfig := &docker.HostConfig{}
+	for _, mount := range spec.Mounts {
+		bind := mount.Source + ":" + mount.Target
+		if mount.ReadOnly {
+			bind += ":ro"
+		}
+		hostConfig.Binds = append(hostConfig.Binds, bind)
+	}
+	res := spec.Resources
+	if res == nil {
+		hostConfig.NetworkMode = spec.Network
+		return hostConfig
+	}
+	hostConfig.NetworkMode = res.Network
+	if spec.Network != "" {
+		hostConfig.NetworkMode = spec.Network
+	}
+	hostConfig.NanoCpus = int64(res.Cpus * 1e9)
+	if res.Memory > 0 {
+		// 内存与 swap 上限相同，禁止使用 swap
+		hostConfig.Memory = res.Memory
+		hostConfig.MemorySwap = res.Memory
+	}
+	hostConfig.PidsLimit = res.Pids
+	if res.ReadOnly {
+		hostConfig.ReadonlyRootfs = true
+		hostConfig.Tmpfs = map[string]string{"/tmp": ""}
+	}
+	return hostConfig
+}
+
+// createContainer 创建容器，删除上次执行残留的同名容器，本地不存在镜像时拉取镜像
+func (d *dockerRuntime) createContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) (string, error) {
+	config := &docker.ContainerConfig{
+		Image:        spec.Image,
+		Entrypoint:   spec.Entrypoint,
+		Cmd:          spec.Cmd,
+		Env:          containerEnv(spec),
+		WorkingDir:   spec.WorkingDir,
+		AttachStdout: true,
+		AttachStderr: true,
+		HostConfig:   d.hostConfig(spec),
+	}
+	if res := spec.Resources; res != nil {
+		config.User = res.User
+	}
+	if spec.Network != "" && len(spec.Aliases) > 0 {
+		config.NetworkingConfig = &docker.NetworkingConfig{
+			EndpointsConfig: map[string]*docker.EndpointSettings{spec.Network: {Aliases: spec.Aliases}},
+		}
+	}
+	if hc := spec.HealthCheck; hc != nil {
+		config.Healthcheck = &docker.Healthcheck{
+			Test:        []string{"CMD-SHELL", hc.Cmd},
+			Interval:    hc.Interval,
+			Timeout:     hc.Timeout,
+			StartPeriod: hc.StartPeriod,
+			Retries:     hc.Retries,
+		}
+	}
+	if spec.Name != "" {
+		if err := d.client.RemoveContainer(ctx, spec.Name); err != nil && !docker.IsNotFound(err) {
+			klog.Warningf("remove container %s error: %v", spec.Name, err)
+		}
+	}
+	id, err := d.client.CreateContainer(ctx, spec.Name, config)
+	if docker.IsNotFound(err) {
+		if err = d.PullImage(ctx, spec.Image, spec.Auth, out); err != nil {
+			return "", err
+		}
+		id, err = d.client.CreateContainer(ctx, spec.Name, config)
+	}
+	return id, err
+}
+
+func (d *dockerRuntime) RunContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) (*ContainerExit, error) {
+	id, err := d.createContainer(ctx, spec, out)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// 任务取消时 ctx 已结束，使用新的 ctx 删除容器
+		if err := d.client.RemoveContainer(context.Background(), id); err != nil && !docker.IsNotFound(err) {
+			klog.Errorf("remove container %s error: %v", id, err)
+		}
+	}()
+	if err = d.client.StartContainer(ctx, id); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := d.client.KillContainer(context.Background(), id, ""); err != nil {
+				klog.Errorf("kill container %s error: %v", id, err)
+			}
+		case <-done:
+		}
+	}()
+	if err = d.client.ContainerLogs(ctx, id, true, out, out); err != nil && ctx.Err() == nil {
+		klog.Warningf("read container %s logs error: %v", id, err)
+	}
+	exitCode, err := d.client.WaitContainer(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	exit := &ContainerExit{ExitCode: exitCode}
+	if info, err := d.client.InspectContainer(context.Background(), id); err == nil && info.State != nil {
+		exit.OOMKilled = info.State.OOMKilled
+	}
+	return exit, ctx.Err()
+}
+
+func (d *dockerRuntime) StartContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) (string, error) {
+	id, err := d.createContainer(ctx, spec, out)
+	if err != nil {
+		return "", err
+	}
+	if err = d.client.StartContainer(ctx, id); err != nil {
+		_ = d.client.RemoveContainer(context.Background(), id)
+		return "", err
+	}
+	return id, nil
+}
+
+func (d *dockerRuntime) ContainerStatus(ctx context.Context, id string) (*ContainerStatus, error) {
+	info, err := d.client.InspectContainer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	status := &ContainerStatus{}
+	if info.State != nil {
+		status.Running = info.State.Running
+		status.ExitCode = info.State.ExitCode
+		if info.State.Health != nil {
+			status.Health = info.State.Health.Status
+		}
+	}
+	return status, nil
+}
+
+func (d *dockerRuntime) ContainerLogs(ctx context.Context, id string, out io.Writer) error {
+	return d.client.ContainerLogs(ctx, id, false, out, out)
+}
+
+func (d *dockerRuntime) RemoveContainer(ctx context.Context, id string) error {
+	err := d.client.RemoveContainer(ctx, id)
+	if docker.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *dockerRuntime) CreateNetwork(ctx context.Context, name string) error {
+	// 删除上次执行残留的同名网络
+	if err := d.client.RemoveNetwork(ctx, name); err != nil && !docker.IsNotFound(err) {
+		klog.Warningf("remove network %s error: %v", name, err)
+	}
+	_, err := d.client.CreateNetwork(ctx, name, map[string]string{"kubespace.pipeline": "true"})
+	return err
+}
+
+func (d *dockerRuntime) RemoveNetwork(ctx context.Context, name string) error {
+	err := d.client.RemoveNetwork(ctx, name)
+	if docker.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *dockerRuntime) BuildImage(ctx context.Context, options *RuntimeBuildOptions, out io.Writer) error {
+	buildContext, dockerfile, err := docker.TarContext(options.Context, options.Dockerfile)
+	if err != nil {
+		return err
+	}
+	defer buildContext.Close()
+	buildOptions := &docker.BuildOptions{
+		Tags:       options.Tags,
+		Dockerfile: dockerfile,
+		CacheFrom:  options.CacheFrom,
+		BuildArgs:  options.BuildArgs,
+		Auths:      make(map[string]docker.AuthConfig),
+	}
+	for _, reg := range options.Auths {
+		if auth := dockerAuth(reg); auth != nil {
+			buildOptions.Auths[auth.ServerAddress] = *auth
+		}
+	}
+	start := time.Now()
+	imageId, err := d.client.BuildImage(ctx, buildContext, buildOptions, out)
+	if err != nil {
+		return err
+	}
+	klog.Infof("built image %s %s in %s", strings.Join(options.Tags, ","), imageId, time.Since(start))
+	return nil
+}
+
+func (d *dockerRuntime) PullImage(ctx context.Context, image string, auth *serializers.ImageRegistry, out io.Writer) error {
+	return d.client.PullImage(ctx, image, dockerAuth(auth), out)
+}
+
+func (d *dockerRuntime) PushImage(ctx context.Context, image string, auth *serializers.ImageRegistry, out io.Writer) (string, error) {
+	return d.client.PushImage(ctx, image, dockerAuth(auth), out)
+}
+
+func (d *dockerRuntime) RemoveImage(ctx context.Context, image string) error {
+	return d.client.RemoveImage(ctx, image)
+}
@@ -0,0 +1,293 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io"
+	"k8s.io/klog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RuntimeContainerd 通过 nerdctl 命令行访问 containerd
+const RuntimeContainerd = "containerd"
+
+// nerdctlRuntime 使用 nerdctl 命令行执行容器的 containerd 运行时，所有参数以 argv 形式传递
+type nerdctlRuntime struct {
+	runtimeCapabilities
+	path      string
+	address   string
+	namespace string
+}
+
+func newNerdctlRuntime(path, address, namespace string) *nerdctlRuntime {
+	if path == "" {
+		path = "nerdctl"
+	}
+	return &nerdctlRuntime{path: path, address: address, namespace: namespace}
+}
+
+func (n *nerdctlRuntime) Name() string {
+	return RuntimeContainerd
+}
+
+// command 创建 nerdctl 命令，dockerConfig 不为空时使用该目录下的镜像仓库认证信息
+func (n *nerdctlRuntime) command(ctx context.Context, dockerConfig string, arg ...string) *exec.Cmd {
+	var args []string
+	if n.address != "" {
+		args = append(args, "--address", n.address)
+	}
+	if n.namespace != "" {
+		args = append(args, "--namespace", n.namespace)
+	}
+	cmd := exec.CommandContext(ctx, n.path, append(args, arg...)...)
+	if dockerConfig != "" {
+		cmd.Env = append(os.Environ(), "DOCKER_CONFIG="+dockerConfig)
+	}
+	return cmd
+}
+
+// run 执行 nerdctl 命令，失败时返回包含命令输出的错误
+func (n *nerdctlRuntime) run(ctx context.Context, arg ...string) (string, error) {
+	output, err := n.command(ctx, "", arg...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("nerdctl %s error: %v: %s", arg[0], err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// withAuth 将仓库认证信息写入临时 DOCKER_CONFIG 目录后执行 fn，nerdctl 从该目录读取认证信息
+func (n *nerdctlRuntime) withAuth(registries []*serializers.ImageRegistry, fn func(dockerConfig string) error) error {
+	var auths []*serializers.ImageRegistry
+	for _, reg := range registries {
+		if reg != nil && reg.User != "" {
+			auths = append(auths, reg)
+		}
+	}
+	if len(auths) == 0 {
+		return fn("")
+	}
+	dir, err := os.MkdirTemp("", "kubespace-nerdctl-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err = writeDockerConfig(dir, auths); err != nil {
+		return err
+	}
+	return fn(dir)
+}
+
+func (n *nerdctlRuntime) detect() *RuntimeCapabilities {
+	caps := &RuntimeCapabilities{}
+	defer n.setCapabilities(caps)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := n.run(ctx, "info", "--format", "{{json .}}")
+	if err != nil {
+		caps.Error = err.Error()
+		return caps
+	}
+	// nerdctl info 输出与 docker info 兼容的字段
+	info := &struct {
+		ServerVersion string `json:"ServerVersion"`
+		MemoryLimit   bool   `json:"MemoryLimit"`
+		CpuCfsQuota   bool   `json:"CpuCfsQuota"`
+		PidsLimit     bool   `json:"PidsLimit"`
+	}{}
+	if err = json.Unmarshal([]byte(output), info); err != nil {
+		caps.Error = fmt.Sprintf("parse nerdctl info error: %v", err)
+		return caps
+	}
+	caps.Available = true
+	caps.Version = info.ServerVersion
+	caps.CpuLimit = info.CpuCfsQuota
+	caps.MemoryLimit = info.MemoryLimit
+	caps.PidsLimit = info.PidsLimit
+	// nerdctl build 需要 buildkitd 及 buildctl
+	_, err = exec.LookPath("buildctl")
+	caps.Build = err == nil
+	return caps
+}
+
+// runArgs nerdctl run 的参数
+func (n *nerdctlRuntime) runArgs(name string, spec *ContainerSpec) []string {
+	args := []string{"run", "--name", name}
+	if res := spec.Resources; res != nil {
+		if res.Network != "" {
+			args = append(args, "--network", res.Network)
+		}
+		if res.Cpus > 0 {
+			args = append(args, fmt.Sprintf("--cpus=%v", res.Cpus))
+		}
+		if res.Memory > 0 {
+			// 内存与 swap 上限相同，禁止使用 swap
+			args = append(args, fmt.Sprintf("--memory=%d", res.Memory), fmt.Sprintf("--memory-swap=%d", res.Memory))
+		}
+		if res.Pids > 0 {
+			args = append(args, fmt.Sprintf("--pids-limit=%d", res.Pids))
+		}
+		if res.ReadOnly {
+			args = append(args, "--read-only", "--tmpfs", "/tmp")
+		}
+		if res.User != "" {
+			args = append(args, "--user", res.User)
+		}
+	}
+	for _, env := range containerEnv(spec) {
+		args = append(args, "-e", env)
+	}
+	for _, mount := range spec.Mounts {
+		volume := mount.Source + ":" + mount.Target
+		if mount.ReadOnly {
+			volume += ":ro"
+		}
+		args = append(args, "-v", volume)
+	}
+	if spec.WorkingDir != "" {
+		args = append(args, "-w", spec.WorkingDir)
+	}
+	cmd := spec.Cmd
+	if len(spec.Entrypoint) > 0 {
+		// --entrypoint 仅支持一个参数，其余参数放到命令之前
+		args = append(args, "--entrypoint", spec.Entrypoint[0])
+		cmd = append(append([]string{}, spec.Entrypoint[1:]...), cmd...)
+	}
+	args = append(args, spec.Image)
+	return append(args, cmd...)
+}
+
+func (n *nerdctlRuntime) RunContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) (*ContainerExit, error) {
+	name := spec.Name
+	if name == "" {
+		name = fmt.Sprintf("kubespace-%d", time.Now().UnixNano())
+	}
+	// 删除上次执行残留的同名容器
+	_, _ = n.run(context.Background(), "rm", "-f", name)
+	defer func() {
+		if _, err := n.run(context.Background(), "rm", "-f", name); err != nil {
+			klog.Errorf("remove container %s error: %v", name, err)
+		}
+	}()
+	exit := &ContainerExit{}
+	err := n.withAuth([]*serializers.ImageRegistry{spec.Auth}, func(dockerConfig string) error {
+		cmd := n.command(context.Background(), dockerConfig, n.runArgs(name, spec)...)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				if _, err := n.run(context.Background(), "kill", name); err != nil {
+					klog.Errorf("kill container %s error: %v", name, err)
+				}
+			case <-done:
+			}
+		}()
+		err := cmd.Wait()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exit.ExitCode = exitErr.ExitCode()
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output, err := n.run(context.Background(), "inspect", "--format", "{{.State.OOMKilled}}", name); err == nil {
+		exit.OOMKilled = strings.TrimSpace(output) == "true"
+	}
+	return exit, ctx.Err()
+}
+
+// errNerdctlServices nerdctl 不支持网络别名，无法运行服务容器，检测能力时 Services 为 false
+var errNerdctlServices = errors.New("containerd 运行时不支持服务容器")
+
+func (n *nerdctlRuntime) StartContainer(ctx context.Context, spec *ContainerSpec, out io.Writer) (string, error) {
+	return "", errNerdctlServices
+}
+
+func (n *nerdctlRuntime) ContainerStatus(ctx context.Context, id string) (*ContainerStatus, error) {
+	return nil, errNerdctlServices
+}
+
+func (n *nerdctlRuntime) ContainerLogs(ctx context.Context, id string, out io.Writer) error {
+	return errNerdctlServices
+}
+
+func (n *nerdctlRuntime) RemoveContainer(ctx context.Context, id string) error {
+	_, err := n.run(ctx, "rm", "-f", id)
+	return err
+}
+
+func (n *nerdctlRuntime) CreateNetwork(ctx context.Context, name string) error {
+	return errNerdctlServices
+}
+
+func (n *nerdctlRuntime) RemoveNetwork(ctx context.Context, name string) error {
+	return errNerdctlServices
+}
+
+func (n *nerdctlRuntime) BuildImage(ctx context.Context, options *RuntimeBuildOptions, out io.Writer) error {
+	args := []string{"build", "--progress=plain", "-f", options.Dockerfile}
+	for _, tag := range options.Tags {
+		args = append(args, "-t", tag)
+	}
+	for _, from := range options.CacheFrom {
+		args = append(args, "--cache-from", from)
+	}
+	for key, val := range options.BuildArgs {
+		args = append(args, "--build-arg", key+"="+val)
+	}
+	args = append(args, options.Context)
+	return n.withAuth(options.Auths, func(dockerConfig string) error {
+		cmd := n.command(ctx, dockerConfig, args...)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		return cmd.Run()
+	})
+}
+
+func (n *nerdctlRuntime) PullImage(ctx context.Context, image string, auth *serializers.ImageRegistry, out io.Writer) error {
+	return n.withAuth([]*serializers.ImageRegistry{auth}, func(dockerConfig string) error {
+		cmd := n.command(ctx, dockerConfig, "pull", image)
+		output := &bytes.Buffer{}
+		cmd.Stdout = output
+		cmd.Stderr = output
+		if out != nil {
+			cmd.Stdout = io.MultiWriter(output, out)
+			cmd.Stderr = cmd.Stdout
+		}
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(output.String()))
+		}
+		return nil
+	})
+}
+
+func (n *nerdctlRuntime) PushImage(ctx context.Context, image string, auth *serializers.ImageRegistry, out io.Writer) (string, error) {
+	err := n.withAuth([]*serializers.ImageRegistry{auth}, func(dockerConfig string) error {
+		cmd := n.command(ctx, dockerConfig, "push", image)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		return cmd.Run()
+	})
+	// nerdctl push 不输出 digest，由调用方通过镜像仓库获取
+	return "", err
+}
+
+func (n *nerdctlRuntime) RemoveImage(ctx context.Context, image string) error {
+	_, err := n.run(ctx, "rmi", "-f", image)
+	return err
+}
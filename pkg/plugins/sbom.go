@@ -0,0 +1,216 @@
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"io/ioutil"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SbomFormatSpdx      = "spdx-json"
+	SbomFormatCycloneDX = "cyclonedx-json"
+
+	// SbomArtifactName 物料清单保存的产物名称
+	SbomArtifactName = "sbom"
+
+	SbomSourceImage = "image"
+	SbomSourceCode  = "code"
+)
+
+// sbomFormats 物料清单格式对应的文件后缀及推送到镜像仓库时的 artifactType
+var sbomFormats = map[string]struct {
+	ext          string
+	artifactType string
+}{
+	SbomFormatSpdx:      {".spdx.json", "application/spdx+json"},
+	SbomFormatCycloneDX: {".cdx.json", "application/vnd.cyclonedx+json"},
+}
+
+// SbomResult 一个镜像或代码目录的物料清单，文件保存在 sbom 产物中
+type SbomResult struct {
+	Source    string          `json:"source"`
+	Image     string          `json:"image,omitempty"`
+	Digest    string          `json:"digest,omitempty"`
+	Documents []*SbomDocument `json:"documents"`
+}
+
+// SbomDocument 物料清单文件，File 为产物中的文件名，Referrer 为推送到镜像仓库的 referrer 清单 digest
+type SbomDocument struct {
+	Format   string `json:"format"`
+	Artifact string `json:"artifact"`
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+// validateSbom 校验物料清单参数，物料清单保存为 sbom 产物，用户产物不能使用该名称
+func validateSbom(sbom *serializers.Sbom, artifacts []serializers.Artifact) error {
+	if sbom == nil {
+		return nil
+	}
+	formats := make(map[string]bool)
+	for _, format := range sbom.Formats {
+		if _, ok := sbomFormats[format]; !ok {
+			return fmt.Errorf("物料清单格式%s错误，只支持 %s、%s", format, SbomFormatSpdx, SbomFormatCycloneDX)
+		}
+		if formats[format] {
+			return fmt.Errorf("物料清单格式%s重复", format)
+		}
+		formats[format] = true
+	}
+	for _, artifact := range artifacts {
+		if artifact.Name == SbomArtifactName {
+			return fmt.Errorf("产物名称%s已被物料清单使用", SbomArtifactName)
+		}
+	}
+	return nil
+}
+
+// generateSboms 使用 syft 为推送的镜像及代码目录生成物料清单，保存为 sbom 产物，
+// 开启 Attach 时将镜像的物料清单推送到镜像仓库
+func (b *CodeBuilderPlugin) generateSboms() error {
+	sbom := b.Params.Sbom
+	if sbom == nil || len(b.Result.Builds) == 0 {
+		return nil
+	}
+	formats := sbom.Formats
+	if len(formats) == 0 {
+		formats = []string{SbomFormatSpdx, SbomFormatCycloneDX}
+	}
+	sbomDir := filepath.Join(b.RootDir, ".sbom")
+	if err := os.MkdirAll(sbomDir, 0755); err != nil {
+		return err
+	}
+	codeResult, err := b.generateSbom("code", "dir:/app", formats, sbomDir, ContainerMount{Source: b.CodeDir, Target: "/app", ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	codeResult.Source = SbomSourceCode
+	results := []*SbomResult{codeResult}
+	for i, build := range b.Result.Builds {
+		ref, err := registry.ParseReference(build.Image)
+		if err != nil {
+			return err
+		}
+		image := build.Image
+		if build.Digest != "" {
+			image = ref.WithDigest(build.Digest).String()
+		}
+		result, err := b.generateSbom(fmt.Sprintf("image-%d", i+1), "registry:"+image, formats, sbomDir,
+			ContainerMount{Source: b.DockerConfigDir, Target: "/kubespace-docker", ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		result.Source = SbomSourceImage
+		result.Image = build.Image
+		result.Digest = build.Digest
+		results = append(results, result)
+	}
+	artifact, err := b.archiveArtifact(sbomDir, &serializers.Artifact{Name: SbomArtifactName, Paths: []string{"*.json"}})
+	if err != nil {
+		b.Log("%v", err)
+		return err
+	}
+	b.Result.Artifacts = append(b.Result.Artifacts, artifact)
+	b.Result.Sboms = results
+	if !sbom.Attach {
+		return nil
+	}
+	for _, result := range results[1:] {
+		if err = b.attachSbom(result, sbomDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateSbom 在 syft 容器中生成一个来源的所有格式物料清单，文件名为 <name><后缀>
+func (b *CodeBuilderPlugin) generateSbom(name, source string, formats []string, sbomDir string, mount ContainerMount) (*SbomResult, error) {
+	args := []string{source, "--quiet"}
+	for _, format := range formats {
+		args = append(args, "-o", format+"="+scanMountDir+"/"+name+sbomFormats[format].ext)
+	}
+	env := []string{"DOCKER_CONFIG=/kubespace-docker"}
+	if strings.HasPrefix(source, "registry:") {
+		ref, err := registry.ParseReference(strings.TrimPrefix(source, "registry:"))
+		if err == nil && conf.AppConfig.IsInsecureRegistry(ref.Registry) {
+			env = append(env, "SYFT_REGISTRY_INSECURE_SKIP_TLS_VERIFY=true")
+		}
+	}
+	b.Log("生成%s物料清单", strings.SplitN(source, ":", 2)[1])
+	err := b.runContainer(context.Background(), &ContainerSpec{
+		Name:       b.containerName("sbom-" + name),
+		Image:      conf.AppConfig.SbomImage,
+		Entrypoint: []string{"/syft"},
+		Cmd:        args,
+		Env:        env,
+		Mounts:     []ContainerMount{mount, {Source: sbomDir, Target: scanMountDir}},
+	}, b.Logger)
+	if err != nil {
+		klog.Errorf("job=%d generate sbom of %s error: %v", b.JobId, source, err)
+		b.Log("生成物料清单失败：%v", err)
+		return nil, fmt.Errorf("生成%s物料清单失败：%v", source, err)
+	}
+	result := &SbomResult{}
+	for _, format := range formats {
+		file := name + sbomFormats[format].ext
+		content, err := ioutil.ReadFile(filepath.Join(sbomDir, file))
+		if err != nil {
+			return nil, fmt.Errorf("读取物料清单%s失败：%v", file, err)
+		}
+		sum := sha256.Sum256(content)
+		result.Documents = append(result.Documents, &SbomDocument{
+			Format:   format,
+			Artifact: SbomArtifactName,
+			File:     file,
+			Size:     int64(len(content)),
+			Sha256:   hex.EncodeToString(sum[:]),
+		})
+	}
+	return result, nil
+}
+
+// attachSbom 将镜像的物料清单作为 OCI referrer 推送到镜像所在仓库，subject 为镜像清单
+func (b *CodeBuilderPlugin) attachSbom(result *SbomResult, sbomDir string) error {
+	ref, err := registry.ParseReference(result.Image)
+	if err != nil {
+		return err
+	}
+	client := newRegistryClient(ref.Registry, &b.Params.ImageBuildRegistry)
+	reference := result.Digest
+	if reference == "" {
+		reference = ref.Identifier()
+	}
+	content, err := client.GetManifest(ref.Repository, reference)
+	if err != nil {
+		klog.Errorf("job=%d get manifest of %s error: %v", b.JobId, result.Image, err)
+		b.Log("获取镜像%s清单失败：%v", result.Image, err)
+		return fmt.Errorf("获取镜像%s清单失败：%v", result.Image, err)
+	}
+	subject := content.Descriptor()
+	for _, doc := range result.Documents {
+		document, err := ioutil.ReadFile(filepath.Join(sbomDir, doc.File))
+		if err != nil {
+			return err
+		}
+		annotations := map[string]string{"org.opencontainers.image.title": doc.File}
+		doc.Referrer, err = client.PushReferrer(ref.Repository, &subject, sbomFormats[doc.Format].artifactType, document, annotations)
+		if err != nil {
+			klog.Errorf("job=%d push sbom %s error: %v", b.JobId, doc.File, err)
+			b.Log("推送物料清单%s失败：%v", doc.File, err)
+			return fmt.Errorf("推送镜像%s物料清单失败：%v", result.Image, err)
+		}
+		b.Log("推送镜像%s %s 物料清单：%s", result.Image, doc.Format, doc.Referrer)
+	}
+	return nil
+}
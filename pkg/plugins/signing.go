@@ -0,0 +1,140 @@
+package plugins
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/conf"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/signature"
+	"io/ioutil"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var signingKeyNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// SignatureResult 镜像签名或签名校验结果，Signature 为保存签名的镜像地址
+type SignatureResult struct {
+	Image     string `json:"image"`
+	Digest    string `json:"digest"`
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
+}
+
+// signingKeyPath 签名密钥目录下的密钥文件，目录为空时为数据目录下的 .signing-keys
+func signingKeyPath(name, ext string) string {
+	dir := conf.AppConfig.SigningKeyDir
+	if dir == "" {
+		dir = conf.AppConfig.DataDir + "/.signing-keys"
+	}
+	return filepath.Join(dir, name+ext)
+}
+
+// validateSigningKey 校验密钥名称，私钥或公钥文件需要存在
+func validateSigningKey(name string, private bool) error {
+	if !signingKeyNameRe.MatchString(name) {
+		return fmt.Errorf("签名密钥名称%s不合法，只能包含字母、数字及._-", name)
+	}
+	if fileExists(signingKeyPath(name, ".key")) || !private && fileExists(signingKeyPath(name, ".pub")) {
+		return nil
+	}
+	return fmt.Errorf("签名密钥%s不存在", name)
+}
+
+// loadSigningKey 读取签名私钥，<name>.password 文件存在时作为私钥密码
+func loadSigningKey(name string) (crypto.Signer, error) {
+	content, err := ioutil.ReadFile(signingKeyPath(name, ".key"))
+	if err != nil {
+		return nil, fmt.Errorf("读取签名密钥%s失败：%v", name, err)
+	}
+	password, err := ioutil.ReadFile(signingKeyPath(name, ".password"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取签名密钥%s密码失败：%v", name, err)
+	}
+	signer, err := signature.LoadPrivateKey(content, bytes.TrimRight(password, "\r\n"))
+	if err != nil {
+		return nil, fmt.Errorf("解析签名密钥%s失败：%v", name, err)
+	}
+	return signer, nil
+}
+
+// loadVerifyKey 读取校验签名的公钥，没有 <name>.pub 文件时使用私钥对应的公钥
+func loadVerifyKey(name string) (crypto.PublicKey, error) {
+	content, err := ioutil.ReadFile(signingKeyPath(name, ".pub"))
+	if os.IsNotExist(err) {
+		signer, err := loadSigningKey(name)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取签名公钥%s失败：%v", name, err)
+	}
+	key, err := signature.LoadPublicKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("解析签名公钥%s失败：%v", name, err)
+	}
+	return key, nil
+}
+
+// signImage 使用服务端密钥签名镜像 digest，签名推送到镜像所在仓库
+func (b *BasePlugin) signImage(client *registry.Client, ref *registry.Reference, digest, keyName string, signer crypto.Signer) (*SignatureResult, error) {
+	if digest == "" {
+		b.Log("镜像%s digest 为空，无法签名", ref.String())
+		return nil, fmt.Errorf("镜像%s digest 为空，无法签名", ref.String())
+	}
+	if err := signature.Sign(client, ref.Repository, ref.Name(), digest, signer); err != nil {
+		klog.Errorf("job=%d sign image %s error: %v", b.JobId, ref.String(), err)
+		b.Log("签名镜像%s失败：%v", ref.String(), err)
+		return nil, fmt.Errorf("签名镜像%s失败：%v", ref.String(), err)
+	}
+	sigRef := ref.WithTag(signature.SignatureTag(digest)).String()
+	b.Log("使用密钥%s签名镜像%s@%s：%s", keyName, ref.Name(), digest, sigRef)
+	return &SignatureResult{Image: ref.String(), Digest: digest, Key: keyName, Signature: sigRef}, nil
+}
+
+// verifyImage 校验镜像 digest 是否有 keyNames 中任一密钥的有效签名
+func (b *BasePlugin) verifyImage(client *registry.Client, ref *registry.Reference, digest string, keyNames []string, keys []crypto.PublicKey) (*SignatureResult, error) {
+	i, err := signature.Verify(client, ref.Repository, digest, keys)
+	if err == signature.ErrNoSignature {
+		b.Log("镜像%s@%s没有密钥%v的有效签名", ref.Name(), digest, keyNames)
+		return nil, fmt.Errorf("镜像%s没有有效签名，禁止发布", ref.String())
+	}
+	if err != nil {
+		klog.Errorf("job=%d verify image %s error: %v", b.JobId, ref.String(), err)
+		b.Log("校验镜像%s签名失败：%v", ref.String(), err)
+		return nil, fmt.Errorf("校验镜像%s签名失败：%v", ref.String(), err)
+	}
+	sigRef := ref.WithTag(signature.SignatureTag(digest)).String()
+	b.Log("镜像%s@%s签名校验通过，密钥：%s", ref.Name(), digest, keyNames[i])
+	return &SignatureResult{Image: ref.String(), Digest: digest, Key: keyNames[i], Signature: sigRef}, nil
+}
+
+// signImages 签名代码构建推送的所有镜像
+func (b *CodeBuilderPlugin) signImages() error {
+	if b.Params.Signing == nil {
+		return nil
+	}
+	signer, err := loadSigningKey(b.Params.Signing.Key)
+	if err != nil {
+		b.Log("%v", err)
+		return err
+	}
+	for _, build := range b.Result.Builds {
+		ref, err := registry.ParseReference(build.Image)
+		if err != nil {
+			return err
+		}
+		client := newRegistryClient(ref.Registry, &b.Params.ImageBuildRegistry)
+		result, err := b.signImage(client, ref, build.Digest, b.Params.Signing.Key, signer)
+		if err != nil {
+			return err
+		}
+		b.Result.Signatures = append(b.Result.Signatures, result)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/models/types"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/report"
+	"github.com/kubespace/pipeline-plugin/pkg/views/serializers"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxReportSize 单个报告文件大小上限
+	maxReportSize = 100 << 20
+	// maxStoredTestCases 保存到数据库的失败用例数上限
+	maxStoredTestCases = 1000
+	// maxResultTestCases 回调结果中包含的失败用例数上限
+	maxResultTestCases = 20
+	maxCaseMessageSize = 4 << 10
+	maxCaseOutputSize  = 64 << 10
+)
+
+// TestReportResult 代码构建的测试及覆盖率报告汇总
+type TestReportResult struct {
+	*report.TestSummary
+	// FailedCases 失败的测试用例，最多包含 maxResultTestCases 个，完整列表保存在数据库中
+	FailedCases []*report.TestCase `json:"failed_cases,omitempty"`
+	Coverage    *CoverageResult    `json:"coverage,omitempty"`
+}
+
+// CoverageResult 覆盖率汇总，Percent 为百分比
+type CoverageResult struct {
+	*report.Coverage
+	Percent   float64 `json:"percent"`
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// validateBuildReports 校验测试报告参数
+func validateBuildReports(reports *serializers.CodeBuildReports) error {
+	if reports == nil {
+		return nil
+	}
+	for _, p := range append(append([]string{}, reports.JUnit...), reports.Coverage...) {
+		if !artifactPathRe.MatchString(p) {
+			return fmt.Errorf("测试报告路径%s不合法，只能包含字母、数字、glob 通配符及./_-@+=,", p)
+		}
+		if err := checkRepoPath(p); err != nil {
+			return fmt.Errorf("测试报告路径错误：%v", err)
+		}
+	}
+	if reports.CoverageFormat != "" {
+		supported := false
+		for _, f := range report.Formats {
+			supported = supported || f == reports.CoverageFormat
+		}
+		if !supported {
+			return fmt.Errorf("覆盖率报告格式%s错误，只支持 %s", reports.CoverageFormat, strings.Join(report.Formats, "、"))
+		}
+	}
+	if reports.CoverageThreshold < 0 || reports.CoverageThreshold > 100 {
+		return fmt.Errorf("覆盖率下限%v错误，需在 0 到 100 之间", reports.CoverageThreshold)
+	}
+	if reports.CoverageThreshold > 0 && len(reports.Coverage) == 0 {
+		return fmt.Errorf("设置覆盖率下限时覆盖率报告路径不能为空")
+	}
+	return nil
+}
+
+// findReports 查找 dirs 目录下匹配路径的报告文件，返回文件路径
+func findReports(dirs []string, patterns []string) ([]string, error) {
+	var files []string
+	for _, dir := range dirs {
+		matched, err := matchArtifactFiles(dir, patterns)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range matched {
+			files = append(files, filepath.Join(dir, filepath.FromSlash(file)))
+		}
+	}
+	return files, nil
+}
+
+func readReport(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return report.ReadLimited(f, maxReportSize)
+}
+
+// collectReports 代码构建结束后解析 dirs 目录下的测试及覆盖率报告，矩阵构建时包含所有单元的目录，
+// 单个报告解析失败时只输出日志，汇总结果保存到数据库及回调结果中
+func (b *CodeBuilderPlugin) collectReports(dirs []string) {
+	params := b.Params.CodeBuildReports
+	if params == nil {
+		return
+	}
+	result := &TestReportResult{TestSummary: &report.TestSummary{}}
+	if len(params.JUnit) > 0 {
+		files, err := findReports(dirs, params.JUnit)
+		if err != nil {
+			b.Log("查找测试报告失败：%v", err)
+		}
+		for _, file := range files {
+			content, err := readReport(file)
+			if err == nil {
+				var summary *report.TestSummary
+				if summary, err = report.ParseJUnit(bytes.NewReader(content)); err == nil {
+					result.Merge(summary)
+				}
+			}
+			if err != nil {
+				b.Log("解析测试报告%s失败：%v", b.reportName(file), err)
+			}
+		}
+		if len(files) == 0 {
+			b.Log("没有匹配的测试报告：%s", strings.Join(params.JUnit, ", "))
+		} else {
+			b.Log("测试报告：共%d个用例，失败%d个，错误%d个，跳过%d个，耗时%.2fs",
+				result.Tests, result.Failures, result.Errors, result.Skipped, result.Duration)
+		}
+	}
+	if len(params.Coverage) > 0 {
+		files, err := findReports(dirs, params.Coverage)
+		if err != nil {
+			b.Log("查找覆盖率报告失败：%v", err)
+		}
+		var coverage *report.Coverage
+		for _, file := range files {
+			content, err := readReport(file)
+			if err == nil {
+				var cov *report.Coverage
+				if cov, err = report.ParseCoverage(content, params.CoverageFormat); err == nil {
+					if coverage == nil {
+						coverage = cov
+					} else {
+						coverage.Merge(cov)
+					}
+				}
+			}
+			if err != nil {
+				b.Log("解析覆盖率报告%s失败：%v", b.reportName(file), err)
+			}
+		}
+		if coverage != nil {
+			result.Coverage = &CoverageResult{Coverage: coverage, Percent: coverage.Percent(), Threshold: params.CoverageThreshold}
+			b.Log("覆盖率（%s）：%.2f%%，覆盖%d/%d行", coverage.Format, result.Coverage.Percent, coverage.LinesCovered, coverage.LinesValid)
+		} else {
+			b.Log("没有可解析的覆盖率报告：%s", strings.Join(params.Coverage, ", "))
+		}
+	}
+	failed := result.TestSummary.FailedCases()
+	if len(failed) > 0 {
+		b.Log("失败的测试用例：")
+		for i, tc := range failed {
+			if i == maxResultTestCases {
+				b.Log("  ...共%d个", len(failed))
+				break
+			}
+			b.Log("  [%s] %s %s: %s", tc.Status, tc.ClassName, tc.Name, firstLine(tc.Message))
+		}
+	}
+	result.FailedCases = failed
+	if len(failed) > maxResultTestCases {
+		result.FailedCases = failed[:maxResultTestCases]
+	}
+	b.Result.Reports = result
+	if err := b.saveReports(result, failed); err != nil {
+		klog.Errorf("job=%d save test report error: %v", b.JobId, err)
+		b.Log("保存测试报告失败：%v", err)
+	}
+}
+
+// reportName 报告文件相对于任务目录的路径，用于日志输出
+func (b *CodeBuilderPlugin) reportName(file string) string {
+	if rel, err := filepath.Rel(b.RootDir, file); err == nil {
+		return rel
+	}
+	return file
+}
+
+func (b *CodeBuilderPlugin) saveReports(result *TestReportResult, failed []*report.TestCase) error {
+	record := &types.PipelineJobTestReport{
+		JobRunId: b.JobId,
+		Tests:    result.Tests,
+		Failures: result.Failures,
+		Errors:   result.Errors,
+		Skipped:  result.Skipped,
+		Duration: result.Duration,
+	}
+	if cov := result.Coverage; cov != nil {
+		percent := cov.Percent
+		record.CoverageFormat, record.LinesCovered, record.LinesValid, record.Coverage = cov.Format, cov.LinesCovered, cov.LinesValid, &percent
+	}
+	var cases []*types.PipelineJobTestCase
+	for i, tc := range failed {
+		if i == maxStoredTestCases {
+			break
+		}
+		cases = append(cases, &types.PipelineJobTestCase{
+			Suite:     truncate(tc.Suite, 500),
+			ClassName: truncate(tc.ClassName, 500),
+			Name:      truncate(tc.Name, 500),
+			Status:    tc.Status,
+			Message:   truncate(tc.Message, maxCaseMessageSize),
+			Output:    truncate(tc.Output, maxCaseOutputSize),
+			Duration:  tc.Duration,
+		})
+	}
+	return models.Models.TestReportManager.Save(record, cases)
+}
+
+// checkCoverageThreshold 覆盖率低于下限或没有覆盖率报告时返回 QualityGateError
+func (b *CodeBuilderPlugin) checkCoverageThreshold() error {
+	params := b.Params.CodeBuildReports
+	if params == nil || params.CoverageThreshold <= 0 {
+		return nil
+	}
+	var err error
+	if b.Result.Reports == nil || b.Result.Reports.Coverage == nil {
+		err = fmt.Errorf("没有可解析的覆盖率报告，无法检查覆盖率下限%.2f%%", params.CoverageThreshold)
+	} else if percent := b.Result.Reports.Coverage.Percent; percent < params.CoverageThreshold {
+		err = fmt.Errorf("覆盖率%.2f%%低于下限%.2f%%", percent, params.CoverageThreshold)
+	}
+	if err != nil {
+		b.Log("%v", err)
+		return &PluginError{Code: code.QualityGateError, Err: err, Data: b.Result}
+	}
+	return nil
+}
+
+// truncate 按字节截断字符串，不截断 utf8 字符
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	return s[:size]
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
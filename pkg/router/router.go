@@ -40,6 +40,10 @@ func apiWrapper(handler views.ViewHandler) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		context := &views.Context{Context: c}
 		res := handler(context)
+		// 下载文件等直接写入响应的接口返回 nil
+		if res == nil {
+			return
+		}
 		c.JSON(200, res)
 	}
 }
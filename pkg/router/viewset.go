@@ -8,7 +8,17 @@ type ViewSets map[string][]*views.View
 
 func NewViewSets() *ViewSets {
 	plugins := views.NewPluginViews()
+	buildCaches := views.NewBuildCacheViews()
+	artifacts := views.NewArtifactViews()
+	testReports := views.NewTestReportViews()
+	findings := views.NewFindingViews()
+	vulnerabilities := views.NewVulnerabilityViews()
 	return &ViewSets{
-		"plugin": plugins.Views,
+		"plugin":        plugins.Views,
+		"build_cache":   buildCaches.Views,
+		"artifact":      artifacts.Views,
+		"test_report":   testReports.Views,
+		"finding":       findings.Views,
+		"vulnerability": vulnerabilities.Views,
 	}
 }
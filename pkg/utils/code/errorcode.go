@@ -20,4 +20,10 @@ const (
 	EncodeError    = "EncodeError"
 	DataNotExists  = "DataNotExists"
 	AuthError      = "AuthError"
+	OOMKilledError = "OOMKilledError"
+	PolicyError    = "PolicyError"
+	// QualityGateError 测试覆盖率等质量门禁未通过
+	QualityGateError = "QualityGateError"
+	// Unstable 任务执行成功但未通过不稳定门禁，如镜像漏洞数超过警告上限
+	Unstable = "Unstable"
 )
@@ -0,0 +1,187 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// contextDockerfile Dockerfile 不在构建上下文目录中时，打包到上下文中的文件名
+const contextDockerfile = ".kubespace.Dockerfile"
+
+// ignorePattern .dockerignore 中的一条规则
+type ignorePattern struct {
+	re      *regexp.Regexp
+	exclude bool
+}
+
+// readDockerignore 读取构建上下文目录下的 .dockerignore 规则
+func readDockerignore(dir string) ([]*ignorePattern, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []*ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern := &ignorePattern{exclude: true}
+		if strings.HasPrefix(line, "!") {
+			pattern.exclude = false
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(filepath.Clean(line), "/")
+		if pattern.re, err = patternRegexp(line); err != nil {
+			return nil, fmt.Errorf(".dockerignore pattern %s error: %v", line, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, scanner.Err()
+}
+
+// patternRegexp 将 .dockerignore 规则转换为正则，** 匹配任意层级目录，规则匹配目录时同时匹配目录下的所有文件
+func patternRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	sb.WriteString("(/.*)?$")
+	return regexp.Compile(sb.String())
+}
+
+// ignored 判断相对路径是否被排除，后面的规则覆盖前面的规则
+func ignored(patterns []*ignorePattern, rel string) bool {
+	matched := false
+	for _, p := range patterns {
+		if p.re.MatchString(rel) {
+			matched = p.exclude
+		}
+	}
+	return matched
+}
+
+// TarContext 将构建上下文目录打包为 tar 流，返回 Dockerfile 在上下文中的相对路径，
+// Dockerfile 不在上下文目录中时一同打包到上下文根目录
+func TarContext(dir string, dockerfile string) (io.ReadCloser, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	patterns, err := readDockerignore(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	hasException := false
+	for _, p := range patterns {
+		if !p.exclude {
+			hasException = true
+		}
+	}
+	if _, err = os.Stat(dockerfile); err != nil {
+		return nil, "", fmt.Errorf("dockerfile %s not found: %v", dockerfile, err)
+	}
+	relDockerfile, err := filepath.Rel(dir, dockerfile)
+	external := err != nil || strings.HasPrefix(relDockerfile, "..")
+	if external {
+		relDockerfile = contextDockerfile
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			// Dockerfile 及 .dockerignore 始终发送到守护进程
+			if rel != relDockerfile && rel != ".dockerignore" && ignored(patterns, rel) {
+				if info.IsDir() && !hasException {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return addTarFile(tw, path, rel, info)
+		})
+		if err == nil && external {
+			var info os.FileInfo
+			if info, err = os.Stat(dockerfile); err == nil {
+				err = addTarFile(tw, dockerfile, contextDockerfile, info)
+			}
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		_ = writer.CloseWithError(err)
+	}()
+	return reader, relDockerfile, nil
+}
+
+func addTarFile(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	// 与 docker cli 一致，不保留文件属主
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
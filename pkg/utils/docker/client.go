@@ -0,0 +1,288 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Options 访问 docker 守护进程的参数
+type Options struct {
+	// APIVersion 请求使用的 api 版本，如 1.41，为空时使用守护进程的默认版本
+	APIVersion string
+	// Transport 自定义底层连接，为空时根据 host 创建 unix socket 或 tcp 连接
+	Transport http.RoundTripper
+	// TLSCertPath tcp 连接使用的 TLS 证书目录，与 DOCKER_CERT_PATH 相同包含 ca.pem、cert.pem、key.pem，
+	// 为空时只允许以明文访问本机的 tcp 地址
+	TLSCertPath string
+}
+
+// Client 基于 docker engine api 的客户端，通过 unix socket 或 tcp 访问 docker 守护进程，
+// podman 提供兼容的 api，同样可以使用该客户端访问
+type Client struct {
+	host     string
+	endpoint string
+	version  string
+	client   *http.Client
+}
+
+// NewClient 创建 docker 客户端，host 格式为 unix:///var/run/docker.sock 或 tcp://127.0.0.1:2375，
+// 访问其它主机的 tcp 地址时需配置 TLS 证书
+func NewClient(host string, options *Options) (*Client, error) {
+	if host == "" {
+		host = DefaultHost
+	}
+	if options == nil {
+		options = &Options{}
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("parse docker host %s error: %v", host, err)
+	}
+	endpoint := "http://docker"
+	transport := options.Transport
+	switch u.Scheme {
+	case "unix":
+		if transport == nil {
+			socket := u.Path
+			transport = &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			}
+		}
+	case "tcp", "http":
+		endpoint = "http://" + u.Host
+		if options.TLSCertPath != "" {
+			endpoint = "https://" + u.Host
+			if transport == nil {
+				tlsConfig, err := loadTLSConfig(options.TLSCertPath)
+				if err != nil {
+					return nil, err
+				}
+				transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+			}
+		} else if !isLoopback(u.Hostname()) {
+			return nil, fmt.Errorf("docker host %s requires tls, tls cert path is not configured", host)
+		}
+		if transport == nil {
+			transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported docker host %s", host)
+	}
+	return &Client{
+		host:     host,
+		endpoint: endpoint,
+		version:  options.APIVersion,
+		client:   &http.Client{Transport: transport},
+	}, nil
+}
+
+// loadTLSConfig 加载证书目录中的 ca.pem 校验守护进程证书，cert.pem、key.pem 作为客户端证书，
+// ca.pem 不存在时使用系统根证书，cert.pem 不存在时不使用客户端证书
+func loadTLSConfig(certPath string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	ca, err := ioutil.ReadFile(filepath.Join(certPath, "ca.pem"))
+	if err == nil {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("parse docker tls ca %s error", filepath.Join(certPath, "ca.pem"))
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read docker tls ca error: %v", err)
+	}
+	certFile := filepath.Join(certPath, "cert.pem")
+	if _, err = os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, filepath.Join(certPath, "key.pem"))
+		if err != nil {
+			return nil, fmt.Errorf("load docker tls cert error: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// isLoopback 判断地址是否为本机地址
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Host 客户端连接的守护进程地址
+func (c *Client) Host() string {
+	return c.host
+}
+
+// Error docker api 返回的错误
+type Error struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("docker api status %d", e.StatusCode)
+	}
+	return e.Message
+}
+
+// IsNotFound 判断错误是否为容器或镜像不存在
+func IsNotFound(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// IsConflict 判断错误是否为资源冲突，如容器名称已存在
+func IsConflict(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// AuthConfig 镜像仓库认证信息，拉取及推送镜像时通过 X-Registry-Auth 头传递
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// encodeAuth 将认证信息编码为 X-Registry-Auth 头
+func encodeAuth(auth interface{}) string {
+	authBytes, _ := json.Marshal(auth)
+	return base64.URLEncoding.EncodeToString(authBytes)
+}
+
+// do 发送请求，非 2xx 响应时解析错误信息并关闭响应体
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader) (*http.Response, error) {
+	if c.version != "" {
+		path = "/v" + c.version + path
+	}
+	reqUrl := c.endpoint + path
+	if len(query) > 0 {
+		reqUrl += "?" + query.Encode()
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request docker %s %s error: %v", method, path, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	errBytes, _ := ioutil.ReadAll(resp.Body)
+	if err = json.Unmarshal(errBytes, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(errBytes))
+	}
+	return nil, apiErr
+}
+
+// doJSON 发送 json 请求，并将响应解析到 out，out 为空时忽略响应
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in interface{}, out interface{}) error {
+	var body io.Reader
+	header := http.Header{}
+	if in != nil {
+		inBytes, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(inBytes)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(ctx, method, path, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Ping 检查守护进程是否可以访问
+func (c *Client) Ping(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return c.doJSON(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// Version 守护进程版本信息
+type Version struct {
+	Version       string `json:"Version"`
+	APIVersion    string `json:"ApiVersion"`
+	Os            string `json:"Os"`
+	Arch          string `json:"Arch"`
+	KernelVersion string `json:"KernelVersion"`
+	Components    []struct {
+		Name    string `json:"Name"`
+		Version string `json:"Version"`
+	} `json:"Components"`
+}
+
+// Version 获取守护进程版本
+func (c *Client) Version(ctx context.Context) (*Version, error) {
+	version := &Version{}
+	if err := c.doJSON(ctx, http.MethodGet, "/version", nil, nil, version); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// Info 守护进程系统信息，包含资源限制等功能的支持情况
+type Info struct {
+	ServerVersion   string `json:"ServerVersion"`
+	OperatingSystem string `json:"OperatingSystem"`
+	Architecture    string `json:"Architecture"`
+	CgroupVersion   string `json:"CgroupVersion"`
+	MemoryLimit     bool   `json:"MemoryLimit"`
+	SwapLimit       bool   `json:"SwapLimit"`
+	CpuCfsQuota     bool   `json:"CpuCfsQuota"`
+	PidsLimit       bool   `json:"PidsLimit"`
+}
+
+// Info 获取守护进程系统信息
+func (c *Client) Info(ctx context.Context) (*Info, error) {
+	info := &Info{}
+	if err := c.doJSON(ctx, http.MethodGet, "/info", nil, nil, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
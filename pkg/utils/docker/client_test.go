@@ -0,0 +1,360 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newUnixServer 在 unix socket 上启动模拟的 docker 守护进程，返回访问该守护进程的客户端
+func newUnixServer(t *testing.T, handler http.Handler) *Client {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	client, err := NewClient("unix://"+socket, &Options{APIVersion: "1.41"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeFrame 写入 docker 多路复用输出流中的一帧
+func writeFrame(w *bytes.Buffer, stream byte, data string) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	w.Write(header)
+	w.WriteString(data)
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	var created ContainerConfig
+	exitCodes := map[string]int{"c1": 0, "c2": 3}
+	started := map[string]bool{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.41/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Query().Get("name") == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "bad create request"})
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		id := "c1"
+		if r.URL.Query().Get("name") == "failed" {
+			id = "c2"
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": id, "Warnings": []string{}})
+	})
+	mux.HandleFunc("/v1.41/containers/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1.41/containers/"), "/")
+		id, action := parts[0], parts[len(parts)-1]
+		if _, ok := exitCodes[id]; !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "No such container: " + id})
+			return
+		}
+		switch action {
+		case "start":
+			started[id] = true
+			w.WriteHeader(http.StatusNoContent)
+		case "wait":
+			if !started[id] {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"StatusCode": -1, "Error": map[string]string{"Message": "container not started"}})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"StatusCode": exitCodes[id]})
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "page not found"})
+		}
+	})
+	client := newUnixServer(t, mux)
+	ctx := context.Background()
+
+	for name, expected := range map[string]int{"ok": 0, "failed": 3} {
+		id, err := client.CreateContainer(ctx, name, &ContainerConfig{
+			Image:      "busybox",
+			Cmd:        []string{"sh", "-c", "exit 3"},
+			HostConfig: &HostConfig{Binds: []string{"/data:/app"}, Memory: 1 << 20},
+		})
+		if err != nil {
+			t.Fatalf("create container %s error: %v", name, err)
+		}
+		if created.Image != "busybox" || created.HostConfig == nil || created.HostConfig.Binds[0] != "/data:/app" {
+			t.Fatalf("unexpected create config %+v", created)
+		}
+		if err = client.StartContainer(ctx, id); err != nil {
+			t.Fatalf("start container %s error: %v", id, err)
+		}
+		exitCode, err := client.WaitContainer(ctx, id)
+		if err != nil {
+			t.Fatalf("wait container %s error: %v", id, err)
+		}
+		if exitCode != expected {
+			t.Errorf("container %s exit code %d, expected %d", name, exitCode, expected)
+		}
+	}
+
+	started["c1"] = false
+	if _, err := client.WaitContainer(ctx, "c1"); err == nil || !strings.Contains(err.Error(), "container not started") {
+		t.Errorf("expected wait error, got %v", err)
+	}
+	err := client.StartContainer(ctx, "missing")
+	if !IsNotFound(err) || err.Error() != "No such container: missing" {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestContainerLogs(t *testing.T) {
+	raw := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.41/containers/c1/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") != "1" || r.URL.Query().Get("stderr") != "1" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "bad logs request"})
+			return
+		}
+		if raw {
+			w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+			w.Write([]byte("tty output\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+		body := &bytes.Buffer{}
+		writeFrame(body, 1, "out 1\n")
+		writeFrame(body, 2, "err 1\n")
+		writeFrame(body, 1, strings.Repeat("x", 40000)+"\n")
+		writeFrame(body, 2, "")
+		writeFrame(body, 1, "out 2\n")
+		w.Write(body.Bytes())
+	})
+	client := newUnixServer(t, mux)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := client.ContainerLogs(context.Background(), "c1", true, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "out 1\n" + strings.Repeat("x", 40000) + "\nout 2\n"; stdout.String() != expected {
+		t.Errorf("unexpected stdout of %d bytes", stdout.Len())
+	}
+	if stderr.String() != "err 1\n" {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+
+	raw = true
+	stdout.Reset()
+	stderr.Reset()
+	if err := client.ContainerLogs(context.Background(), "c1", true, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "tty output\n" || stderr.Len() != 0 {
+		t.Errorf("unexpected raw stream output %q %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestDemuxStreamError(t *testing.T) {
+	body := &bytes.Buffer{}
+	writeFrame(body, 1, "ok\n")
+	writeFrame(body, 5, "bad")
+	if err := demuxStream(body, ioutil.Discard, ioutil.Discard); err == nil {
+		t.Error("expected unknown stream type error")
+	}
+	body.Reset()
+	writeFrame(body, 1, "truncated")
+	if err := demuxStream(bytes.NewReader(body.Bytes()[:12]), ioutil.Discard, ioutil.Discard); err == nil {
+		t.Error("expected truncated frame error")
+	}
+}
+
+func TestBuildImage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.41/build", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Header.Get("Content-Type") != "application/x-tar" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "content type is not tar"})
+			return
+		}
+		if strings.Join(query["t"], ",") != "harbor.test/app:v1,harbor.test/app:latest" || query.Get("dockerfile") != "build/Dockerfile" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "bad build query " + query.Encode()})
+			return
+		}
+		var buildArgs map[string]string
+		if err := json.Unmarshal([]byte(query.Get("buildargs")), &buildArgs); err != nil || buildArgs["VERSION"] != "v1" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "bad build args"})
+			return
+		}
+		configBytes, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Config"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "bad registry config"})
+			return
+		}
+		var auths map[string]AuthConfig
+		if err = json.Unmarshal(configBytes, &auths); err != nil || auths["harbor.test"].Username != "admin" || auths["harbor.test"].Password != "secret" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "bad registry config " + string(configBytes)})
+			return
+		}
+		if buildContext, _ := ioutil.ReadAll(r.Body); string(buildContext) != "tar context" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "bad build context"})
+			return
+		}
+		encoder := json.NewEncoder(w)
+		encoder.Encode(map[string]string{"stream": "Step 1/2 : FROM harbor.test/base\n"})
+		encoder.Encode(map[string]interface{}{"status": "Downloading", "id": "abc", "progress": "[=>  ]"})
+		encoder.Encode(map[string]interface{}{"aux": map[string]string{"ID": "sha256:1234"}})
+		encoder.Encode(map[string]string{"stream": "Successfully built 1234\n"})
+	})
+	client := newUnixServer(t, mux)
+
+	out := &bytes.Buffer{}
+	imageId, err := client.BuildImage(context.Background(), strings.NewReader("tar context"), &BuildOptions{
+		Tags:       []string{"harbor.test/app:v1", "harbor.test/app:latest"},
+		Dockerfile: "build/Dockerfile",
+		BuildArgs:  map[string]string{"VERSION": "v1"},
+		Auths:      map[string]AuthConfig{"harbor.test": {Username: "admin", Password: "secret", ServerAddress: "harbor.test"}},
+	}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageId != "sha256:1234" {
+		t.Errorf("image id %s, expected sha256:1234", imageId)
+	}
+	if out.String() != "Step 1/2 : FROM harbor.test/base\nSuccessfully built 1234\n" {
+		t.Errorf("unexpected build output %q", out.String())
+	}
+}
+
+func TestPushImage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.41/images/harbor.test/app/push", func(w http.ResponseWriter, r *http.Request) {
+		authBytes, _ := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		var auth AuthConfig
+		if json.Unmarshal(authBytes, &auth) != nil || auth.Username != "admin" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "no auth"})
+			return
+		}
+		encoder := json.NewEncoder(w)
+		encoder.Encode(map[string]string{"status": "The push refers to repository [harbor.test/app]"})
+		encoder.Encode(map[string]interface{}{"status": "Pushing", "id": "abc", "progress": "[=>  ]"})
+		encoder.Encode(map[string]string{"status": "Pushed", "id": "abc"})
+		if r.URL.Query().Get("tag") == "broken" {
+			encoder.Encode(map[string]interface{}{"errorDetail": map[string]string{"message": "denied: requested access to the resource is denied"}, "error": "denied"})
+			return
+		}
+		encoder.Encode(map[string]string{"status": "v1: digest: sha256:5678 size: 528"})
+		encoder.Encode(map[string]interface{}{"progressDetail": map[string]string{}, "aux": map[string]interface{}{"Tag": "v1", "Digest": "sha256:5678", "Size": 528}})
+	})
+	client := newUnixServer(t, mux)
+	auth := &AuthConfig{Username: "admin", Password: "secret", ServerAddress: "harbor.test"}
+
+	out := &bytes.Buffer{}
+	digest, err := client.PushImage(context.Background(), "harbor.test/app:v1", auth, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha256:5678" {
+		t.Errorf("digest %s, expected sha256:5678", digest)
+	}
+	if strings.Contains(out.String(), "Pushing") || !strings.Contains(out.String(), "abc: Pushed\n") {
+		t.Errorf("unexpected push output %q", out.String())
+	}
+
+	_, err = client.PushImage(context.Background(), "harbor.test/app:broken", auth, ioutil.Discard)
+	if err == nil || err.Error() != "denied: requested access to the resource is denied" {
+		t.Errorf("expected push denied error, got %v", err)
+	}
+	_, err = client.PushImage(context.Background(), "harbor.test/app:v1", nil, ioutil.Discard)
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusUnauthorized || e.Message != "no auth" {
+		t.Errorf("expected unauthorized api error, got %v", err)
+	}
+}
+
+func TestPullImageError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.41/images/create", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		encoder := json.NewEncoder(w)
+		encoder.Encode(map[string]string{"status": "Pulling from library/" + query.Get("fromImage"), "id": query.Get("tag")})
+		if query.Get("tag") == "missing" {
+			encoder.Encode(map[string]string{"error": "manifest for busybox:missing not found"})
+			return
+		}
+		encoder.Encode(map[string]string{"status": "Status: Downloaded newer image for busybox:" + query.Get("tag")})
+	})
+	client := newUnixServer(t, mux)
+
+	out := &bytes.Buffer{}
+	if err := client.PullImage(context.Background(), "busybox", nil, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "latest: Pulling from library/busybox\nStatus: Downloaded newer image for busybox:latest\n" {
+		t.Errorf("unexpected pull output %q", out.String())
+	}
+	err := client.PullImage(context.Background(), "busybox:missing", nil, ioutil.Discard)
+	if err == nil || err.Error() != "manifest for busybox:missing not found" {
+		t.Errorf("expected pull error, got %v", err)
+	}
+}
+
+func TestNewClientTCP(t *testing.T) {
+	if _, err := NewClient("tcp://10.0.0.1:2375", nil); err == nil {
+		t.Error("expected error of tcp host without tls")
+	}
+	if _, err := NewClient("tcp://127.0.0.1:2375", nil); err != nil {
+		t.Errorf("loopback tcp host error: %v", err)
+	}
+	if _, err := NewClient("ssh://docker-host", nil); err == nil {
+		t.Error("expected error of unsupported scheme")
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+	certPath := t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(filepath.Join(certPath, "ca.pem"), ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	host := "tcp://" + server.Listener.Addr().String()
+	client, err := NewClient(host, &Options{TLSCertPath: certPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Ping(context.Background()); err != nil {
+		t.Errorf("ping over tls error: %v", err)
+	}
+	// 未信任守护进程证书时连接失败
+	client, err = NewClient(host, &Options{TLSCertPath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Ping(context.Background()); err == nil {
+		t.Error("expected tls verify error")
+	}
+}
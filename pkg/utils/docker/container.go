@@ -0,0 +1,210 @@
+package docker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ContainerConfig 创建容器的参数
+type ContainerConfig struct {
+	Image        string            `json:"Image"`
+	Entrypoint   []string          `json:"Entrypoint,omitempty"`
+	Cmd          []string          `json:"Cmd,omitempty"`
+	Env          []string          `json:"Env,omitempty"`
+	WorkingDir   string            `json:"WorkingDir,omitempty"`
+	User         string            `json:"User,omitempty"`
+	Labels       map[string]string `json:"Labels,omitempty"`
+	AttachStdout bool              `json:"AttachStdout"`
+	AttachStderr bool              `json:"AttachStderr"`
+	Healthcheck  *Healthcheck      `json:"Healthcheck,omitempty"`
+	HostConfig   *HostConfig       `json:"HostConfig,omitempty"`
+	// NetworkingConfig 容器在网络中的别名等参数
+	NetworkingConfig *NetworkingConfig `json:"NetworkingConfig,omitempty"`
+}
+
+// Healthcheck 容器健康检查，时间单位为纳秒
+type Healthcheck struct {
+	// Test 检查命令，如 ["CMD-SHELL", "mysqladmin ping"]、["CMD", "redis-cli", "ping"]
+	Test        []string      `json:"Test"`
+	Interval    time.Duration `json:"Interval,omitempty"`
+	Timeout     time.Duration `json:"Timeout,omitempty"`
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"`
+	Retries     int           `json:"Retries,omitempty"`
+}
+
+// NetworkingConfig 容器连接的网络参数
+type NetworkingConfig struct {
+	EndpointsConfig map[string]*EndpointSettings `json:"EndpointsConfig"`
+}
+
+// EndpointSettings 容器在网络中的参数
+type EndpointSettings struct {
+	Aliases []string `json:"Aliases,omitempty"`
+}
+
+// HostConfig 容器的挂载及资源限制参数
+type HostConfig struct {
+	Binds          []string          `json:"Binds,omitempty"`
+	NetworkMode    string            `json:"NetworkMode,omitempty"`
+	NanoCpus       int64             `json:"NanoCpus,omitempty"`
+	Memory         int64             `json:"Memory,omitempty"`
+	MemorySwap     int64             `json:"MemorySwap,omitempty"`
+	PidsLimit      int64             `json:"PidsLimit,omitempty"`
+	ReadonlyRootfs bool              `json:"ReadonlyRootfs,omitempty"`
+	Tmpfs          map[string]string `json:"Tmpfs,omitempty"`
+	AutoRemove     bool              `json:"AutoRemove,omitempty"`
+}
+
+// ContainerState 容器运行状态
+type ContainerState struct {
+	Status    string `json:"Status"`
+	Running   bool   `json:"Running"`
+	OOMKilled bool   `json:"OOMKilled"`
+	ExitCode  int    `json:"ExitCode"`
+	Error     string `json:"Error"`
+	Health    *struct {
+		Status string `json:"Status"`
+	} `json:"Health"`
+}
+
+// ContainerInfo 容器详情
+type ContainerInfo struct {
+	ID    string          `json:"Id"`
+	Name  string          `json:"Name"`
+	State *ContainerState `json:"State"`
+}
+
+// CreateContainer 创建容器，返回容器 id
+func (c *Client) CreateContainer(ctx context.Context, name string, config *ContainerConfig) (string, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+	created := &struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}{}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/create", query, config, created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// StartContainer 启动容器
+func (c *Client) StartContainer(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+// WaitContainer 等待容器退出，返回退出码
+func (c *Client) WaitContainer(ctx context.Context, id string) (int, error) {
+	result := &struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}{}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/wait", nil, nil, result); err != nil {
+		return -1, err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, fmt.Errorf("wait container %s error: %s", id, result.Error.Message)
+	}
+	return result.StatusCode, nil
+}
+
+// InspectContainer 获取容器详情
+func (c *Client) InspectContainer(ctx context.Context, id string) (*ContainerInfo, error) {
+	info := &ContainerInfo{}
+	if err := c.doJSON(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// KillContainer 向容器发送信号，signal 为空时发送 SIGKILL
+func (c *Client) KillContainer(ctx context.Context, id string, signal string) error {
+	query := url.Values{}
+	if signal != "" {
+		query.Set("signal", signal)
+	}
+	return c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/kill", query, nil, nil)
+}
+
+// RemoveContainer 强制删除容器及其匿名卷
+func (c *Client) RemoveContainer(ctx context.Context, id string) error {
+	query := url.Values{"force": {"1"}, "v": {"1"}}
+	return c.doJSON(ctx, http.MethodDelete, "/containers/"+id, query, nil, nil)
+}
+
+// ContainerLogs 读取容器的标准输出及错误输出写入 stdout、stderr，follow 为 true 时持续读取直到容器退出
+func (c *Client) ContainerLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	if follow {
+		query.Set("follow", "1")
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/logs", query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") == "application/vnd.docker.raw-stream" {
+		// 使用 tty 创建的容器输出不区分标准输出及错误输出
+		_, err = io.Copy(stdout, resp.Body)
+		return err
+	}
+	return demuxStream(resp.Body, stdout, stderr)
+}
+
+// demuxStream 解析 docker 多路复用的输出流，每帧包含 8 字节头：流类型、3 字节保留、4 字节大端长度
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		var w io.Writer
+		switch header[0] {
+		case 0, 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			return fmt.Errorf("unknown stream type %s of container logs", strconv.Itoa(int(header[0])))
+		}
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// CreateNetwork 创建 bridge 网络，返回网络 id
+func (c *Client) CreateNetwork(ctx context.Context, name string, labels map[string]string) (string, error) {
+	created := &struct {
+		ID string `json:"Id"`
+	}{}
+	req := map[string]interface{}{
+		"Name":           name,
+		"Driver":         "bridge",
+		"CheckDuplicate": true,
+		"Labels":         labels,
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/networks/create", nil, req, created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// RemoveNetwork 删除网络
+func (c *Client) RemoveNetwork(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/networks/"+id, nil, nil, nil)
+}
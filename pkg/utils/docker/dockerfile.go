@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	directiveRe = regexp.MustCompile(`^#\s*([a-zA-Z]+)\s*=\s*(\S+)\s*$`)
+	heredocRe   = regexp.MustCompile(`(?:^|[^<])<<-?\s*["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+)
+
+// Instruction Dockerfile 中的一条指令
+type Instruction struct {
+	// Cmd 大写的指令名称，如 FROM、RUN
+	Cmd string
+	// Flags 指令参数前的选项，如 --platform=linux/amd64、--chown=1000
+	Flags []string
+	// Args 指令参数，exec 格式（json 数组）的参数按数组元素拆分
+	Args []string
+	// Original 合并续行后的原始指令
+	Original string
+	// Line 指令开始的行号，从 1 开始
+	Line int
+}
+
+// ParseDockerfile 解析 Dockerfile 指令，支持续行、注释、escape 解析指令及 heredoc，不做变量替换
+func ParseDockerfile(r io.Reader) ([]*Instruction, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	escape := `\`
+	directives := true
+	var instructions []*Instruction
+	var current []string
+	start := 0
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		trimmed := strings.TrimSpace(scanner.Text())
+		if directives {
+			// 文件开头的解析指令，如 # syntax=docker/dockerfile:1、# escape=`
+			if m := directiveRe.FindStringSubmatch(trimmed); m != nil {
+				if strings.ToLower(m[1]) == "escape" && (m[2] == `\` || m[2] == "`") {
+					escape = m[2]
+				}
+				continue
+			}
+			directives = false
+		}
+		if current == nil && (trimmed == "" || strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+		if current != nil && strings.HasPrefix(trimmed, "#") {
+			// 续行中的注释行被忽略
+			continue
+		}
+		if current == nil {
+			start = lineNo
+		}
+		if strings.HasSuffix(trimmed, escape) {
+			current = append(current, strings.TrimSuffix(trimmed, escape))
+			continue
+		}
+		current = append(current, trimmed)
+		inst := newInstruction(strings.Join(current, " "), start)
+		current = nil
+		if inst == nil {
+			continue
+		}
+		// heredoc 内容不是指令，跳过到结束标记
+		if inst.Cmd == "RUN" || inst.Cmd == "COPY" || inst.Cmd == "ADD" {
+			for _, m := range heredocRe.FindAllStringSubmatch(inst.Original, -1) {
+				for scanner.Scan() {
+					lineNo++
+					if strings.TrimSpace(scanner.Text()) == m[1] {
+						break
+					}
+				}
+			}
+		}
+		instructions = append(instructions, inst)
+	}
+	if current != nil {
+		if inst := newInstruction(strings.Join(current, " "), start); inst != nil {
+			instructions = append(instructions, inst)
+		}
+	}
+	return instructions, scanner.Err()
+}
+
+func newInstruction(original string, line int) *Instruction {
+	fields := strings.Fields(original)
+	if len(fields) == 0 {
+		return nil
+	}
+	inst := &Instruction{Cmd: strings.ToUpper(fields[0]), Original: original, Line: line}
+	rest := strings.TrimSpace(original[len(fields[0]):])
+	for strings.HasPrefix(rest, "--") {
+		i := strings.IndexAny(rest, " \t")
+		if i < 0 {
+			inst.Flags = append(inst.Flags, rest)
+			rest = ""
+			break
+		}
+		inst.Flags = append(inst.Flags, rest[:i])
+		rest = strings.TrimSpace(rest[i:])
+	}
+	if strings.HasPrefix(rest, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(rest), &args); err == nil {
+			inst.Args = args
+			return inst
+		}
+	}
+	inst.Args = strings.Fields(rest)
+	return inst
+}
@@ -0,0 +1,197 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// jsonMessage 拉取、推送及构建镜像时返回的 json 消息流
+type jsonMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	ID          string `json:"id"`
+	Progress    string `json:"progress"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux json.RawMessage `json:"aux"`
+}
+
+// readMessages 读取 json 消息流并将构建输出及状态写入 out，下载进度消息不写入日志，
+// onAux 处理消息中的 aux 数据，如推送的镜像 digest 及构建的镜像 id
+func readMessages(r io.Reader, out io.Writer, onAux func(aux json.RawMessage)) error {
+	decoder := json.NewDecoder(r)
+	for {
+		msg := &jsonMessage{}
+		if err := decoder.Decode(msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+			return fmt.Errorf("%s", msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+		if len(msg.Aux) > 0 && onAux != nil {
+			onAux(msg.Aux)
+		}
+		if out == nil {
+			continue
+		}
+		if msg.Stream != "" {
+			_, _ = io.WriteString(out, msg.Stream)
+		} else if msg.Status != "" && msg.Progress == "" {
+			if msg.ID != "" {
+				_, _ = fmt.Fprintf(out, "%s: %s\n", msg.ID, msg.Status)
+			} else {
+				_, _ = fmt.Fprintln(out, msg.Status)
+			}
+		}
+	}
+}
+
+// splitTag 将镜像地址拆分为名称及 tag，digest 格式的地址 tag 为空
+func splitTag(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// PullImage 拉取镜像，拉取过程输出到 out
+func (c *Client) PullImage(ctx context.Context, image string, auth *AuthConfig, out io.Writer) error {
+	name, tag := splitTag(image)
+	query := url.Values{"fromImage": {name}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	header := http.Header{}
+	if auth != nil {
+		header.Set("X-Registry-Auth", encodeAuth(auth))
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/images/create", query, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readMessages(resp.Body, out, nil)
+}
+
+// PushImage 推送镜像，返回推送后镜像的 digest
+func (c *Client) PushImage(ctx context.Context, image string, auth *AuthConfig, out io.Writer) (string, error) {
+	name, tag := splitTag(image)
+	query := url.Values{}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	header := http.Header{}
+	if auth == nil {
+		auth = &AuthConfig{}
+	}
+	// 推送镜像时 X-Registry-Auth 头为必须参数
+	header.Set("X-Registry-Auth", encodeAuth(auth))
+	resp, err := c.do(ctx, http.MethodPost, "/images/"+name+"/push", query, header, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	digest := ""
+	err = readMessages(resp.Body, out, func(aux json.RawMessage) {
+		pushed := &struct {
+			Digest string `json:"Digest"`
+		}{}
+		if json.Unmarshal(aux, pushed) == nil && pushed.Digest != "" {
+			digest = pushed.Digest
+		}
+	})
+	return digest, err
+}
+
+// TagImage 为本地镜像添加新的 tag
+func (c *Client) TagImage(ctx context.Context, source, target string) error {
+	name, tag := splitTag(target)
+	query := url.Values{"repo": {name}, "tag": {tag}}
+	return c.doJSON(ctx, http.MethodPost, "/images/"+source+"/tag", query, nil, nil)
+}
+
+// RemoveImage 删除本地镜像
+func (c *Client) RemoveImage(ctx context.Context, image string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/images/"+image, url.Values{"force": {"1"}}, nil, nil)
+}
+
+// ImageExists 判断本地是否存在镜像
+func (c *Client) ImageExists(ctx context.Context, image string) (bool, error) {
+	err := c.doJSON(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil, nil)
+	if err == nil {
+		return true, nil
+	}
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// BuildOptions 构建镜像的参数
+type BuildOptions struct {
+	Tags []string
+	// Dockerfile 构建上下文中 Dockerfile 的相对路径
+	Dockerfile string
+	CacheFrom  []string
+	BuildArgs  map[string]string
+	Platform   string
+	// Auths 拉取基础镜像使用的仓库认证信息，key 为仓库地址
+	Auths map[string]AuthConfig
+}
+
+// BuildImage 使用 tar 格式的构建上下文构建镜像，构建输出写入 out，返回镜像 id
+func (c *Client) BuildImage(ctx context.Context, buildContext io.Reader, options *BuildOptions, out io.Writer) (string, error) {
+	query := url.Values{"rm": {"1"}, "forcerm": {"1"}}
+	for _, tag := range options.Tags {
+		query.Add("t", tag)
+	}
+	if options.Dockerfile != "" {
+		query.Set("dockerfile", options.Dockerfile)
+	}
+	if len(options.CacheFrom) > 0 {
+		cacheFrom, _ := json.Marshal(options.CacheFrom)
+		query.Set("cachefrom", string(cacheFrom))
+	}
+	if len(options.BuildArgs) > 0 {
+		buildArgs, _ := json.Marshal(options.BuildArgs)
+		query.Set("buildargs", string(buildArgs))
+	}
+	if options.Platform != "" {
+		query.Set("platform", options.Platform)
+	}
+	header := http.Header{"Content-Type": {"application/x-tar"}}
+	if len(options.Auths) > 0 {
+		header.Set("X-Registry-Config", encodeAuth(options.Auths))
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/build", query, header, buildContext)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	imageId := ""
+	err = readMessages(resp.Body, out, func(aux json.RawMessage) {
+		built := &struct {
+			ID string `json:"ID"`
+		}{}
+		if json.Unmarshal(aux, built) == nil && built.ID != "" {
+			imageId = built.ID
+		}
+	})
+	return imageId, err
+}
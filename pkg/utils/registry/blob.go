@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// BlobExists 判断仓库中是否已存在 blob
+func (c *Client) BlobExists(repository, digest string) (bool, error) {
+	resp, err := c.do(http.MethodHead, "/v2/"+repository+"/blobs/"+digest, []string{pullScope(repository)}, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, &Error{StatusCode: resp.StatusCode}
+}
+
+// GetBlob 获取 blob 内容，调用方负责关闭返回的 ReadCloser
+func (c *Client) GetBlob(repository, digest string) (io.ReadCloser, int64, error) {
+	resp, err := c.do(http.MethodGet, "/v2/"+repository+"/blobs/"+digest, []string{pullScope(repository)}, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, 0, newError(resp)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// mountScopes 挂载 blob 时申请的 scope，仓库拒绝挂载后继续上传也需使用相同 scope，才能复用已获取的 token
+func mountScopes(repository, from string) []string {
+	return []string{pushScope(repository), pullScope(from)}
+}
+
+// MountBlob 从同一仓库的其它镜像挂载 blob，不传输数据
+// 仓库不支持挂载时返回 false 及已开启的上传地址，可继续通过 uploadBlob 使用 mountScopes 上传
+func (c *Client) MountBlob(repository, from, digest string) (bool, string, error) {
+	query := url.Values{}
+	query.Set("mount", digest)
+	query.Set("from", from)
+	resp, err := c.do(http.MethodPost, "/v2/"+repository+"/blobs/uploads/?"+query.Encode(), mountScopes(repository, from), nil, nil)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, "", nil
+	case http.StatusAccepted:
+		return false, resp.Header.Get("Location"), nil
+	}
+	return false, "", newError(resp)
+}
+
+// UploadBlob 上传 blob，数据以流的方式写入仓库
+func (c *Client) UploadBlob(repository, digest string, size int64, content io.Reader) error {
+	location, err := c.startUpload(repository)
+	if err != nil {
+		return err
+	}
+	return c.uploadBlob(location, []string{pushScope(repository)}, digest, size, content)
+}
+
+func (c *Client) startUpload(repository string) (string, error) {
+	resp, err := c.do(http.MethodPost, "/v2/"+repository+"/blobs/uploads/", []string{pushScope(repository)}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", newError(resp)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("registry %s return empty upload location", c.registry)
+	}
+	return location, nil
+}
+
+// uploadBlob 向已开启的上传地址一次性写入 blob 数据，scopes 需与开启上传的请求一致，
+// 流式上传的请求体无法在认证后重试，只能使用已缓存的认证信息
+func (c *Client) uploadBlob(location string, scopes []string, digest string, size int64, content io.Reader) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+	header := http.Header{"Content-Type": []string{"application/octet-stream"}}
+	if size >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	resp, err := c.do(http.MethodPut, u.String(), scopes, header, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return newError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Options 访问镜像仓库的参数
+type Options struct {
+	Username string
+	Password string
+	// Insecure 跳过 https 证书校验，https 访问失败时回退为 http 协议
+	Insecure bool
+	// PlainHTTP 使用 http 协议访问镜像仓库
+	PlainHTTP bool
+	// Transport 自定义底层连接，为空时使用默认 Transport
+	Transport http.RoundTripper
+}
+
+// Client 基于 registry v2 api 的镜像仓库客户端，不依赖 docker 守护进程
+type Client struct {
+	registry string
+	endpoint string
+	options  Options
+	client   *http.Client
+
+	mu sync.Mutex
+	// basicAuth 仓库要求 Basic 认证时为 true
+	basicAuth bool
+	// tokens 按 scope 缓存的 Bearer token
+	tokens map[string]string
+}
+
+func NewClient(registry string, options *Options) *Client {
+	if options == nil {
+		options = &Options{}
+	}
+	transport := options.Transport
+	if transport == nil {
+		transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: options.Insecure},
+		}
+	}
+	scheme := "https"
+	if options.PlainHTTP {
+		scheme = "http"
+	}
+	host := endpointHost(registry)
+	return &Client{
+		registry: registry,
+		endpoint: scheme + "://" + host,
+		options:  *options,
+		client:   &http.Client{Transport: transport},
+		tokens:   make(map[string]string),
+	}
+}
+
+// Registry 客户端对应的镜像仓库地址
+func (c *Client) Registry() string {
+	return c.registry
+}
+
+// Error registry api 返回的错误
+type Error struct {
+	StatusCode int
+	Errors     []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	var msgs []string
+	for _, item := range e.Errors {
+		msgs = append(msgs, item.Code+": "+item.Message)
+	}
+	if len(msgs) == 0 {
+		return fmt.Sprintf("registry return status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("registry return status code %d: %s", e.StatusCode, strings.Join(msgs, "; "))
+}
+
+// IsNotFound 判断错误是否为镜像或 blob 不存在
+func IsNotFound(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+func newError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = json.Unmarshal(body, e)
+	return e
+}
+
+// pullScope/pushScope 请求 Bearer token 时使用的 scope
+func pullScope(repository string) string {
+	return "repository:" + repository + ":pull"
+}
+
+func pushScope(repository string) string {
+	return "repository:" + repository + ":pull,push"
+}
+
+// do 发送请求，仓库返回 401 时根据 WWW-Authenticate 完成认证后重试
+// 请求体只有为 *bytes.Reader 时才能重试，流式上传前需先通过其它请求完成认证
+func (c *Client) do(method, path string, scopes []string, header http.Header, body io.Reader) (*http.Response, error) {
+	reqUrl, err := c.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(method, reqUrl, scopes, header, body)
+	if err != nil && c.fallbackToHTTP(body) {
+		if reqUrl, err = c.resolve(path); err != nil {
+			return nil, err
+		}
+		resp, err = c.send(method, reqUrl, scopes, header, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err = c.authorize(challenge, scopes); err != nil {
+		return nil, err
+	}
+	if body != nil {
+		seeker, ok := body.(*bytes.Reader)
+		if !ok {
+			return nil, fmt.Errorf("%s %s unauthorized", method, reqUrl)
+		}
+		if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	resp, err = c.send(method, reqUrl, scopes, header, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s unauthorized, please check registry %s user and password", method, reqUrl, c.registry)
+	}
+	return resp, nil
+}
+
+// fallbackToHTTP 非安全仓库 https 访问失败时，切换为 http 协议
+func (c *Client) fallbackToHTTP(body io.Reader) bool {
+	if !c.options.Insecure || body != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !strings.HasPrefix(c.endpoint, "https://") {
+		return false
+	}
+	c.endpoint = "http://" + strings.TrimPrefix(c.endpoint, "https://")
+	return true
+}
+
+func (c *Client) resolve(path string) (string, error) {
+	c.mu.Lock()
+	endpoint := c.endpoint
+	c.mu.Unlock()
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (c *Client) send(method, reqUrl string, scopes []string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, reqUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if r, ok := body.(*bytes.Reader); ok {
+		req.ContentLength = int64(r.Len())
+	}
+	if l := header.Get("Content-Length"); l != "" {
+		fmt.Sscanf(l, "%d", &req.ContentLength)
+		req.Header.Del("Content-Length")
+	}
+	c.mu.Lock()
+	if token, ok := c.tokens[strings.Join(scopes, " ")]; ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.basicAuth {
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+	c.mu.Unlock()
+	return c.client.Do(req)
+}
+
+// authorize 处理仓库返回的认证要求，Bearer 认证时向认证服务申请 token
+func (c *Client) authorize(challenge string, scopes []string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.options.Username == "" {
+			return fmt.Errorf("registry %s requires basic auth, but user is empty", c.registry)
+		}
+		c.mu.Lock()
+		c.basicAuth = true
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+		token, err := c.fetchToken(params["realm"], params["service"], scopes)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.tokens[strings.Join(scopes, " ")] = token
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("registry %s unsupported auth challenge %q", c.registry, challenge)
+}
+
+func (c *Client) fetchToken(realm, service string, scopes []string) (string, error) {
+	if realm == "" {
+		return "", fmt.Errorf("registry %s auth challenge has no realm", c.registry)
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if service != "" {
+		query.Set("service", service)
+	}
+	for _, scope := range scopes {
+		query.Add("scope", scope)
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.options.Username != "" {
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch registry %s token error: %v", c.registry, newError(resp))
+	}
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("decode registry %s token error: %v", c.registry, err)
+	}
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	if tokenResp.AccessToken != "" {
+		return tokenResp.AccessToken, nil
+	}
+	return "", fmt.Errorf("registry %s return empty token", c.registry)
+}
+
+// parseChallenge 解析 WWW-Authenticate 头，如：
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	challenge = strings.TrimSpace(challenge)
+	i := strings.Index(challenge, " ")
+	if i < 0 {
+		return challenge, params
+	}
+	scheme := challenge[:i]
+	rest := challenge[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end+1:]
+			}
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,215 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// CopyOptions 镜像复制参数
+type CopyOptions struct {
+	// Platforms 只复制指定平台的镜像，如 linux/amd64，为空时复制所有平台
+	Platforms []string
+	// Progress 复制过程日志输出
+	Progress io.Writer
+}
+
+// CopyResult 镜像复制结果
+type CopyResult struct {
+	Digest    string
+	MediaType string
+	// Platforms 多平台镜像中各平台镜像的 digest
+	Platforms map[string]string
+}
+
+// CopyImage 将 src 镜像复制到 dst 镜像仓库，不依赖 docker 守护进程
+// 同一镜像仓库下通过 blob mount 在服务端完成复制，跨仓库时以流的方式拷贝镜像层
+func CopyImage(src *Client, srcRef *Reference, dst *Client, dstRef *Reference, options *CopyOptions) (*CopyResult, error) {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+	c := &copier{src: src, dst: dst, srcRepo: srcRef.Repository, dstRepo: dstRef.Repository, options: options}
+	content, err := src.GetManifest(srcRef.Repository, srcRef.Identifier())
+	if err != nil {
+		return nil, fmt.Errorf("get manifest of %s error: %v", srcRef, err)
+	}
+	result := &CopyResult{MediaType: content.MediaType}
+	if IsIndexMediaType(content.MediaType) {
+		content, result.Platforms, err = c.copyIndex(content)
+	} else {
+		err = c.copyManifest(content, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.logf("put manifest %s", dstRef)
+	result.Digest, err = dst.PutManifest(dstRef.Repository, dstRef.Identifier(), content.MediaType, content.Body)
+	if err != nil {
+		return nil, fmt.Errorf("put manifest %s error: %v", dstRef, err)
+	}
+	return result, nil
+}
+
+type copier struct {
+	src     *Client
+	dst     *Client
+	srcRepo string
+	dstRepo string
+	options *CopyOptions
+}
+
+func (c *copier) logf(format string, a ...interface{}) {
+	if c.options.Progress != nil {
+		fmt.Fprintf(c.options.Progress, format+"\n", a...)
+	}
+}
+
+// copyIndex 复制多平台镜像索引中的各平台镜像，指定平台时生成只包含这些平台的新索引
+func (c *copier) copyIndex(content *ManifestContent) (*ManifestContent, map[string]string, error) {
+	index, err := content.Manifest()
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifests []Descriptor
+	platforms := make(map[string]string)
+	for _, desc := range index.Manifests {
+		if desc.Platform != nil && !c.wantPlatform(desc.Platform) {
+			continue
+		}
+		child, err := c.src.GetManifest(c.srcRepo, desc.Digest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("get manifest %s error: %v", desc.Digest, err)
+		}
+		if err = c.copyManifest(child, false); err != nil {
+			return nil, nil, err
+		}
+		if _, err = c.dst.PutManifest(c.dstRepo, desc.Digest, child.MediaType, child.Body); err != nil {
+			return nil, nil, fmt.Errorf("put manifest %s error: %v", desc.Digest, err)
+		}
+		if desc.Platform != nil {
+			platforms[desc.Platform.String()] = desc.Digest
+		}
+		manifests = append(manifests, desc)
+	}
+	if len(manifests) == 0 {
+		return nil, nil, fmt.Errorf("not found platforms %s in image", strings.Join(c.options.Platforms, ","))
+	}
+	if len(manifests) == len(index.Manifests) {
+		return content, platforms, nil
+	}
+	// 过滤了部分平台，需要重新生成索引，新索引的 digest 与源镜像不同
+	var raw map[string]interface{}
+	if err = json.Unmarshal(content.Body, &raw); err != nil {
+		return nil, nil, err
+	}
+	raw["manifests"] = manifests
+	body, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &ManifestContent{MediaType: content.MediaType, Digest: Digest(body), Body: body}, platforms, nil
+}
+
+func (c *copier) wantPlatform(platform *Platform) bool {
+	if len(c.options.Platforms) == 0 {
+		return true
+	}
+	for _, p := range c.options.Platforms {
+		if platform.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyManifest 复制单平台镜像的 config 及所有镜像层
+func (c *copier) copyManifest(content *ManifestContent, checkPlatform bool) error {
+	manifest, err := content.Manifest()
+	if err != nil {
+		return err
+	}
+	var blobs []Descriptor
+	if manifest.Config != nil {
+		blobs = append(blobs, *manifest.Config)
+	}
+	blobs = append(blobs, manifest.Layers...)
+	if checkPlatform && len(c.options.Platforms) > 0 && manifest.Config != nil {
+		platform, err := c.configPlatform(manifest.Config.Digest)
+		if err != nil {
+			return err
+		}
+		if !c.wantPlatform(platform) {
+			return fmt.Errorf("image platform %s not in %s", platform, strings.Join(c.options.Platforms, ","))
+		}
+	}
+	for _, blob := range blobs {
+		if err = c.copyBlob(blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *copier) configPlatform(digest string) (*Platform, error) {
+	reader, _, err := c.src.GetBlob(c.srcRepo, digest)
+	if err != nil {
+		return nil, fmt.Errorf("get image config %s error: %v", digest, err)
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	platform := &Platform{}
+	if err = json.Unmarshal(body, platform); err != nil {
+		return nil, fmt.Errorf("unmarshal image config %s error: %v", digest, err)
+	}
+	return platform, nil
+}
+
+func (c *copier) copyBlob(blob Descriptor) error {
+	exists, err := c.dst.BlobExists(c.dstRepo, blob.Digest)
+	if err != nil {
+		return fmt.Errorf("check blob %s error: %v", blob.Digest, err)
+	}
+	if exists {
+		c.logf("blob %s already exists", blob.Digest)
+		return nil
+	}
+	var location string
+	scopes := []string{pushScope(c.dstRepo)}
+	if SameRegistry(c.src.registry, c.dst.registry) && c.srcRepo != c.dstRepo {
+		var mounted bool
+		mounted, location, err = c.dst.MountBlob(c.dstRepo, c.srcRepo, blob.Digest)
+		if err != nil {
+			return fmt.Errorf("mount blob %s error: %v", blob.Digest, err)
+		}
+		if mounted {
+			c.logf("mounted blob %s from %s", blob.Digest, c.srcRepo)
+			return nil
+		}
+		if location != "" {
+			scopes = mountScopes(c.dstRepo, c.srcRepo)
+		}
+	}
+	if location == "" {
+		if location, err = c.dst.startUpload(c.dstRepo); err != nil {
+			return fmt.Errorf("start upload blob %s error: %v", blob.Digest, err)
+		}
+	}
+	reader, size, err := c.src.GetBlob(c.srcRepo, blob.Digest)
+	if err != nil {
+		return fmt.Errorf("get blob %s error: %v", blob.Digest, err)
+	}
+	defer reader.Close()
+	if size < 0 {
+		size = blob.Size
+	}
+	c.logf("copying blob %s (%d bytes)", blob.Digest, size)
+	if err = c.dst.uploadBlob(location, scopes, blob.Digest, size, reader); err != nil {
+		return fmt.Errorf("upload blob %s error: %v", blob.Digest, err)
+	}
+	return nil
+}
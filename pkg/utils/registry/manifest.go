@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+var manifestAccept = strings.Join([]string{
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+}, ", ")
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String 平台的字符串表示，如：linux/arm64/v8
+func (p *Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Match 判断平台是否与 os/arch[/variant] 格式的平台匹配，未指定 variant 时匹配所有 variant
+func (p *Platform) Match(platform string) bool {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || parts[0] != p.OS || parts[1] != p.Architecture {
+		return false
+	}
+	return len(parts) == 2 || parts[2] == p.Variant
+}
+
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest 镜像清单，同时兼容单平台镜像清单及多平台镜像索引
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        *Descriptor       `json:"config,omitempty"`
+	Layers        []Descriptor      `json:"layers,omitempty"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// IsIndex 是否为多平台镜像索引
+func (m *Manifest) IsIndex() bool {
+	return IsIndexMediaType(m.MediaType)
+}
+
+func IsIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex
+}
+
+// ManifestContent 从仓库获取的镜像清单原始内容
+type ManifestContent struct {
+	MediaType string
+	Digest    string
+	Body      []byte
+}
+
+// Manifest 解析镜像清单内容
+func (m *ManifestContent) Manifest() (*Manifest, error) {
+	manifest := &Manifest{}
+	if err := json.Unmarshal(m.Body, manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest %s error: %v", m.Digest, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = m.MediaType
+	}
+	return manifest, nil
+}
+
+// Descriptor 镜像清单的描述信息，用于写入镜像索引
+func (m *ManifestContent) Descriptor() Descriptor {
+	return Descriptor{MediaType: m.MediaType, Digest: m.Digest, Size: int64(len(m.Body))}
+}
+
+// Digest 计算内容的 sha256 digest
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// GetManifest 获取镜像清单，reference 可以是 tag 或 digest
+func (c *Client) GetManifest(repository, reference string) (*ManifestContent, error) {
+	header := http.Header{"Accept": []string{manifestAccept}}
+	resp, err := c.do(http.MethodGet, "/v2/"+repository+"/manifests/"+reference, []string{pullScope(repository)}, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newError(resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	content := &ManifestContent{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		Body:      body,
+	}
+	if content.Digest == "" {
+		content.Digest = Digest(body)
+	}
+	if content.MediaType == "" || content.MediaType == "application/json" {
+		var m Manifest
+		if err = json.Unmarshal(body, &m); err == nil && m.MediaType != "" {
+			content.MediaType = m.MediaType
+		}
+	}
+	return content, nil
+}
+
+// HeadManifest 获取镜像清单的描述信息，不下载清单内容
+func (c *Client) HeadManifest(repository, reference string) (*Descriptor, error) {
+	header := http.Header{"Accept": []string{manifestAccept}}
+	resp, err := c.do(http.MethodHead, "/v2/"+repository+"/manifests/"+reference, []string{pullScope(repository)}, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{StatusCode: resp.StatusCode}
+	}
+	return &Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// PutManifest 上传镜像清单，reference 可以是 tag 或 digest，返回清单的 digest
+func (c *Client) PutManifest(repository, reference, mediaType string, body []byte) (string, error) {
+	header := http.Header{"Content-Type": []string{mediaType}}
+	resp, err := c.do(http.MethodPut, "/v2/"+repository+"/manifests/"+reference, []string{pushScope(repository)}, header, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", newError(resp)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = Digest(body)
+	}
+	return digest, nil
+}
+
+// Tag 在仓库服务端为镜像打标签，直接复制镜像清单，无需拉取镜像层
+func (c *Client) Tag(repository, reference, tag string) (string, error) {
+	content, err := c.GetManifest(repository, reference)
+	if err != nil {
+		return "", err
+	}
+	return c.PutManifest(repository, tag, content.MediaType, content.Body)
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	DefaultRegistry = "docker.io"
+	DefaultTag      = "latest"
+
+	// dockerHubEndpoint docker.io 实际提供 registry api 的地址
+	dockerHubEndpoint = "registry-1.docker.io"
+)
+
+// tagRe 镜像 tag 允许的字符，与 docker distribution 一致
+var tagRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// ValidTag 判断是否为合法的镜像 tag
+func ValidTag(tag string) bool {
+	return tagRe.MatchString(tag)
+}
+
+// Reference 镜像地址解析后的各个部分
+// 如：registry.cn-hangzhou.aliyuncs.com/kubespace/pipeline-plugin:v1
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference 解析镜像地址，未指定仓库时默认为 docker.io，未指定 tag 及 digest 时默认为 latest
+func ParseReference(image string) (*Reference, error) {
+	image = strings.TrimSpace(image)
+	if image == "" {
+		return nil, fmt.Errorf("image reference is empty")
+	}
+	ref := &Reference{}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+		if !strings.HasPrefix(ref.Digest, "sha256:") {
+			return nil, fmt.Errorf("invalid image digest %q", ref.Digest)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i+1:], "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry = first
+			name = name[i+1:]
+		}
+	}
+	if ref.Registry == "" {
+		ref.Registry = DefaultRegistry
+	}
+	if ref.Registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || ref.Tag == "" && strings.HasSuffix(image, ":") {
+		return nil, fmt.Errorf("invalid image reference %q", image)
+	}
+	ref.Repository = name
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// Name 不带 tag 及 digest 的镜像名
+func (r *Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// Identifier 获取镜像清单时使用的标识，优先使用 digest
+func (r *Reference) Identifier() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// WithTag 返回同一镜像仓库下指定 tag 的镜像地址
+func (r *Reference) WithTag(tag string) *Reference {
+	return &Reference{Registry: r.Registry, Repository: r.Repository, Tag: tag}
+}
+
+// WithDigest 返回同一镜像仓库下指定 digest 的镜像地址
+func (r *Reference) WithDigest(digest string) *Reference {
+	return &Reference{Registry: r.Registry, Repository: r.Repository, Digest: digest}
+}
+
+func (r *Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// SameRegistry 判断两个仓库地址是否指向同一个镜像仓库
+func SameRegistry(a, b string) bool {
+	return endpointHost(a) == endpointHost(b)
+}
+
+func endpointHost(registry string) string {
+	registry = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://"), "/")
+	if registry == "" || registry == DefaultRegistry || registry == "index.docker.io" {
+		return dockerHubEndpoint
+	}
+	return registry
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	// MediaTypeOCIEmpty OCI artifact 清单使用的空配置
+	MediaTypeOCIEmpty = "application/vnd.oci.empty.v1+json"
+)
+
+var emptyConfig = []byte("{}")
+
+// PushReferrer 将 content 作为 OCI artifact 推送到仓库，通过 subject 关联到已存在的镜像清单，返回 artifact 清单的 digest。
+// 仓库不支持 referrers api 时按 OCI 1.1 规范更新 sha256-<digest> tag 的镜像索引
+func (c *Client) PushReferrer(repository string, subject *Descriptor, artifactType string, content []byte, annotations map[string]string) (string, error) {
+	layer := Descriptor{MediaType: artifactType, Digest: Digest(content), Size: int64(len(content))}
+	config := Descriptor{MediaType: MediaTypeOCIEmpty, Digest: Digest(emptyConfig), Size: int64(len(emptyConfig))}
+	for _, blob := range []struct {
+		desc    Descriptor
+		content []byte
+	}{{layer, content}, {config, emptyConfig}} {
+		exists, err := c.BlobExists(repository, blob.desc.Digest)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			if err = c.UploadBlob(repository, blob.desc.Digest, blob.desc.Size, bytes.NewReader(blob.content)); err != nil {
+				return "", err
+			}
+		}
+	}
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  artifactType,
+		Config:        &config,
+		Layers:        []Descriptor{layer},
+		Subject:       &Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+		Annotations:   annotations,
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	digest := Digest(body)
+	header := http.Header{"Content-Type": []string{MediaTypeOCIManifest}}
+	resp, err := c.do(http.MethodPut, "/v2/"+repository+"/manifests/"+digest, []string{pushScope(repository)}, header, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", newError(resp)
+	}
+	if resp.Header.Get("OCI-Subject") != "" {
+		return digest, nil
+	}
+	desc := Descriptor{
+		MediaType:    MediaTypeOCIManifest,
+		Digest:       digest,
+		Size:         int64(len(body)),
+		ArtifactType: artifactType,
+		Annotations:  annotations,
+	}
+	if err = c.addReferrerTag(repository, subject.Digest, desc); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// referrerTag 仓库不支持 referrers api 时保存 referrer 索引的 tag
+func referrerTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+// addReferrerTag 将 referrer 加入 subject 的 tag 索引，已存在时不重复添加
+func (c *Client) addReferrerTag(repository, subjectDigest string, desc Descriptor) error {
+	tag := referrerTag(subjectDigest)
+	index := &Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	content, err := c.GetManifest(repository, tag)
+	if err == nil {
+		if index, err = content.Manifest(); err != nil {
+			return err
+		}
+		if !IsIndexMediaType(index.MediaType) {
+			index = &Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+		}
+	} else if !IsNotFound(err) {
+		return err
+	}
+	for _, m := range index.Manifests {
+		if m.Digest == desc.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, desc)
+	body, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	_, err = c.PutManifest(repository, tag, MediaTypeOCIIndex, body)
+	return err
+}
@@ -0,0 +1,430 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	authNone   = ""
+	authBasic  = "basic"
+	authBearer = "bearer"
+
+	testUser     = "user"
+	testPassword = "password"
+)
+
+type testManifest struct {
+	mediaType string
+	body      []byte
+}
+
+// testRegistry 内存中的 registry v2 服务，支持 Basic 及 Bearer 认证，Bearer token 中记录授权的 scope
+type testRegistry struct {
+	t      *testing.T
+	server *httptest.Server
+	auth   string
+	// refuseMount 为 true 时拒绝跨仓库挂载 blob，返回 202 及上传地址
+	refuseMount bool
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]*testManifest
+	uploads   int
+	mounts    int
+	tokens    int
+}
+
+func newTestRegistry(t *testing.T, auth string) *testRegistry {
+	r := &testRegistry{
+		t:         t,
+		auth:      auth,
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]*testManifest),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func (r *testRegistry) client(user, password string) *Client {
+	return NewClient(r.host(), &Options{Username: user, Password: password, PlainHTTP: true})
+}
+
+// splitPath 将 /v2/<repo>/<kind>/<rest> 拆分为仓库名、类型及剩余部分
+func splitPath(path string) (string, string, string) {
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, kind := range []string{"/blobs/uploads/", "/blobs/", "/manifests/"} {
+		if i := strings.Index(path, kind); i >= 0 {
+			return path[:i], strings.Trim(kind, "/"), path[i+len(kind):]
+		}
+	}
+	return "", "", ""
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	repo, kind, rest := splitPath(req.URL.Path)
+	if repo == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	action := "pull"
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		action = "push"
+	}
+	if !r.authorized(req, repo, action) || kind == "blobs/uploads" && req.URL.Query().Get("from") != "" &&
+		!r.authorized(req, req.URL.Query().Get("from"), "pull") {
+		// 未认证的流式请求体需要丢弃，与真实仓库一致
+		ioutil.ReadAll(req.Body)
+		r.challenge(w, repo, action)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch kind {
+	case "blobs/uploads":
+		r.serveUpload(w, req, repo, rest)
+	case "blobs":
+		blob, ok := r.blobs[repo+"@"+rest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		if req.Method == http.MethodGet {
+			w.Write(blob)
+		}
+	case "manifests":
+		r.serveManifest(w, req, repo, rest)
+	}
+}
+
+func (r *testRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	switch req.Method {
+	case http.MethodPost:
+		if from, digest := req.URL.Query().Get("from"), req.URL.Query().Get("mount"); from != "" && !r.refuseMount {
+			if blob, ok := r.blobs[from+"@"+digest]; ok {
+				r.blobs[repo+"@"+digest] = blob
+				r.mounts++
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/upload-id")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if id != "upload-id" || Digest(body) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[repo+"@"+digest] = body
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (r *testRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, reference string) {
+	switch req.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(req.Body)
+		m := &testManifest{mediaType: req.Header.Get("Content-Type"), body: body}
+		digest := Digest(body)
+		r.manifests[repo+"@"+digest] = m
+		if !strings.HasPrefix(reference, "sha256:") {
+			r.manifests[repo+":"+reference] = m
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		key := repo + ":" + reference
+		if strings.HasPrefix(reference, "sha256:") {
+			key = repo + "@" + reference
+		}
+		m, ok := r.manifests[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", Digest(m.body))
+		w.Header().Set("Content-Length", fmt.Sprint(len(m.body)))
+		if req.Method == http.MethodGet {
+			w.Write(m.body)
+		}
+	}
+}
+
+func (r *testRegistry) challenge(w http.ResponseWriter, repo, action string) {
+	switch r.auth {
+	case authBasic:
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+	case authBearer:
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:%s:%s"`,
+			r.server.URL, repo, action))
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// serveToken 校验用户密码后签发 token，token 内容为授权的 scope 列表
+func (r *testRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if user, password, _ := req.BasicAuth(); user != testUser || password != testPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.mu.Lock()
+	r.tokens++
+	r.mu.Unlock()
+	scopes := strings.Join(req.URL.Query()["scope"], " ")
+	json.NewEncoder(w).Encode(map[string]string{"token": base64.StdEncoding.EncodeToString([]byte(scopes))})
+}
+
+func (r *testRegistry) authorized(req *http.Request, repo, action string) bool {
+	header := req.Header.Get("Authorization")
+	switch r.auth {
+	case authBasic:
+		user, password, ok := req.BasicAuth()
+		return ok && user == testUser && password == testPassword
+	case authBearer:
+		if !strings.HasPrefix(header, "Bearer ") {
+			return false
+		}
+		scopes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			return false
+		}
+		for _, scope := range strings.Fields(string(scopes)) {
+			parts := strings.SplitN(scope, ":", 3)
+			if len(parts) == 3 && parts[1] == repo && strings.Contains(parts[2], action) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// pushImage 直接写入存储一个单平台镜像，返回镜像清单的 digest
+func (r *testRegistry) pushImage(repo, tag, platform string) string {
+	parts := strings.Split(platform, "/")
+	config := []byte(fmt.Sprintf(`{"os":%q,"architecture":%q}`, parts[0], parts[1]))
+	layer := []byte("layer of " + repo + " " + platform)
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        &Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: Digest(config), Size: int64(len(config))},
+		Layers:        []Descriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: Digest(layer), Size: int64(len(layer))}},
+	}
+	body, _ := json.Marshal(manifest)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[repo+"@"+Digest(config)] = config
+	r.blobs[repo+"@"+Digest(layer)] = layer
+	m := &testManifest{mediaType: MediaTypeOCIManifest, body: body}
+	r.manifests[repo+"@"+Digest(body)] = m
+	if tag != "" {
+		r.manifests[repo+":"+tag] = m
+	}
+	return Digest(body)
+}
+
+// pushIndex 写入包含多个平台镜像的镜像索引
+func (r *testRegistry) pushIndex(repo, tag string, platforms ...string) string {
+	index := &Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	for _, platform := range platforms {
+		digest := r.pushImage(repo, "", platform)
+		parts := strings.Split(platform, "/")
+		r.mu.Lock()
+		size := len(r.manifests[repo+"@"+digest].body)
+		r.mu.Unlock()
+		index.Manifests = append(index.Manifests, Descriptor{
+			MediaType: MediaTypeOCIManifest,
+			Digest:    digest,
+			Size:      int64(size),
+			Platform:  &Platform{OS: parts[0], Architecture: parts[1]},
+		})
+	}
+	body, _ := json.Marshal(index)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := &testManifest{mediaType: MediaTypeOCIIndex, body: body}
+	r.manifests[repo+"@"+Digest(body)] = m
+	r.manifests[repo+":"+tag] = m
+	return Digest(body)
+}
+
+func (r *testRegistry) hasBlob(repo, digest string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.blobs[repo+"@"+digest]
+	return ok
+}
+
+func TestBearerAuth(t *testing.T) {
+	reg := newTestRegistry(t, authBearer)
+	digest := reg.pushImage("library/app", "v1", "linux/amd64")
+	client := reg.client(testUser, testPassword)
+	for i := 0; i < 2; i++ {
+		content, err := client.GetManifest("library/app", "v1")
+		if err != nil {
+			t.Fatalf("get manifest error: %v", err)
+		}
+		if content.Digest != digest {
+			t.Fatalf("got digest %s, want %s", content.Digest, digest)
+		}
+	}
+	if reg.tokens != 1 {
+		t.Errorf("token should be cached, fetched %d times", reg.tokens)
+	}
+	if _, err := reg.client(testUser, "wrong").GetManifest("library/app", "v1"); err == nil {
+		t.Error("expected error with wrong password")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	reg := newTestRegistry(t, authBasic)
+	reg.pushImage("app", "v1", "linux/amd64")
+	if _, err := reg.client(testUser, testPassword).GetManifest("app", "v1"); err != nil {
+		t.Fatalf("get manifest error: %v", err)
+	}
+	if _, err := reg.client(testUser, "wrong").GetManifest("app", "v1"); err == nil {
+		t.Error("expected error with wrong password")
+	}
+	if _, err := reg.client("", "").GetManifest("app", "v1"); err == nil {
+		t.Error("expected error without user")
+	}
+}
+
+func TestManifestGetPut(t *testing.T) {
+	reg := newTestRegistry(t, authBearer)
+	client := reg.client(testUser, testPassword)
+	body := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+	digest, err := client.PutManifest("app", "v1", MediaTypeOCIManifest, body)
+	if err != nil {
+		t.Fatalf("put manifest error: %v", err)
+	}
+	if digest != Digest(body) {
+		t.Fatalf("got digest %s, want %s", digest, Digest(body))
+	}
+	for _, reference := range []string{"v1", digest} {
+		content, err := client.GetManifest("app", reference)
+		if err != nil {
+			t.Fatalf("get manifest %s error: %v", reference, err)
+		}
+		if content.MediaType != MediaTypeOCIManifest || !bytes.Equal(content.Body, body) {
+			t.Errorf("get manifest %s got %s %s", reference, content.MediaType, content.Body)
+		}
+	}
+	desc, err := client.HeadManifest("app", "v1")
+	if err != nil {
+		t.Fatalf("head manifest error: %v", err)
+	}
+	if desc.Digest != digest || desc.Size != int64(len(body)) {
+		t.Errorf("head manifest got %+v", desc)
+	}
+	if _, err = client.Tag("app", "v1", "v2"); err != nil {
+		t.Fatalf("tag error: %v", err)
+	}
+	if content, err := client.GetManifest("app", "v2"); err != nil || content.Digest != digest {
+		t.Errorf("tag v2 got %v %v", content, err)
+	}
+	_, err = client.GetManifest("app", "missing")
+	if !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestCopyMountBlob(t *testing.T) {
+	reg := newTestRegistry(t, authBearer)
+	digest := reg.pushImage("src/app", "v1", "linux/amd64")
+	client := reg.client(testUser, testPassword)
+	srcRef, _ := ParseReference(reg.host() + "/src/app:v1")
+	dstRef, _ := ParseReference(reg.host() + "/dst/app:v1")
+	result, err := CopyImage(client, srcRef, client, dstRef, nil)
+	if err != nil {
+		t.Fatalf("copy image error: %v", err)
+	}
+	if result.Digest != digest {
+		t.Errorf("got digest %s, want %s", result.Digest, digest)
+	}
+	if reg.mounts != 2 || reg.uploads != 0 {
+		t.Errorf("expected 2 mounts and no uploads, got %d mounts %d uploads", reg.mounts, reg.uploads)
+	}
+}
+
+func TestCopyMountRefused(t *testing.T) {
+	reg := newTestRegistry(t, authBearer)
+	reg.refuseMount = true
+	digest := reg.pushImage("src/app", "v1", "linux/amd64")
+	// 源与目标使用不同客户端，目标客户端没有缓存任何 token，流式上传只能依赖挂载请求获取的 token
+	srcRef, _ := ParseReference(reg.host() + "/src/app:v1")
+	dstRef, _ := ParseReference(reg.host() + "/dst/app:v1")
+	result, err := CopyImage(reg.client(testUser, testPassword), srcRef, reg.client(testUser, testPassword), dstRef, nil)
+	if err != nil {
+		t.Fatalf("copy image error: %v", err)
+	}
+	if result.Digest != digest {
+		t.Errorf("got digest %s, want %s", result.Digest, digest)
+	}
+	if reg.mounts != 0 || reg.uploads != 2 {
+		t.Errorf("expected 2 uploads and no mounts, got %d mounts %d uploads", reg.mounts, reg.uploads)
+	}
+}
+
+func TestCopyCrossRegistry(t *testing.T) {
+	src := newTestRegistry(t, authBasic)
+	dst := newTestRegistry(t, authBearer)
+	src.pushIndex("app", "v1", "linux/amd64", "linux/arm64")
+	srcRef, _ := ParseReference(src.host() + "/app:v1")
+	dstRef, _ := ParseReference(dst.host() + "/mirror/app:v1")
+	dstClient := dst.client(testUser, testPassword)
+	result, err := CopyImage(src.client(testUser, testPassword), srcRef, dstClient, dstRef, &CopyOptions{Platforms: []string{"linux/arm64"}})
+	if err != nil {
+		t.Fatalf("copy image error: %v", err)
+	}
+	if len(result.Platforms) != 1 || result.Platforms["linux/arm64"] == "" {
+		t.Fatalf("got platforms %v", result.Platforms)
+	}
+	content, err := dstClient.GetManifest("mirror/app", "v1")
+	if err != nil {
+		t.Fatalf("get copied manifest error: %v", err)
+	}
+	index, err := content.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != result.Platforms["linux/arm64"] {
+		t.Fatalf("copied index got %s", content.Body)
+	}
+	child, err := dstClient.GetManifest("mirror/app", index.Manifests[0].Digest)
+	if err != nil {
+		t.Fatalf("get copied platform manifest error: %v", err)
+	}
+	manifest, _ := child.Manifest()
+	for _, blob := range append(manifest.Layers, *manifest.Config) {
+		if !dst.hasBlob("mirror/app", blob.Digest) {
+			t.Errorf("blob %s not copied", blob.Digest)
+		}
+	}
+	if dst.uploads != 2 {
+		t.Errorf("expected 2 uploads, got %d", dst.uploads)
+	}
+}
@@ -0,0 +1,285 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatCobertura = "cobertura"
+	FormatJaCoCo    = "jacoco"
+	// FormatGo go test -coverprofile 生成的覆盖率文件，按语句统计
+	FormatGo   = "go"
+	FormatLcov = "lcov"
+)
+
+// Formats 支持的覆盖率报告格式
+var Formats = []string{FormatCobertura, FormatJaCoCo, FormatGo, FormatLcov}
+
+// Coverage 行覆盖率统计，go 格式为语句覆盖率
+type Coverage struct {
+	Format       string `json:"format"`
+	LinesCovered int64  `json:"lines_covered"`
+	LinesValid   int64  `json:"lines_valid"`
+}
+
+// Percent 覆盖率百分比，没有可统计的行时为 0
+func (c *Coverage) Percent() float64 {
+	if c.LinesValid == 0 {
+		return 0
+	}
+	return float64(c.LinesCovered) * 100 / float64(c.LinesValid)
+}
+
+// Merge 合并另一个覆盖率报告，如多模块项目的多个报告，格式不同时使用逗号分隔的格式列表
+func (c *Coverage) Merge(o *Coverage) {
+	c.LinesCovered += o.LinesCovered
+	c.LinesValid += o.LinesValid
+	formats := strings.Split(c.Format, ",")
+	for _, f := range formats {
+		if f == o.Format {
+			return
+		}
+	}
+	formats = append(formats, o.Format)
+	sort.Strings(formats)
+	c.Format = strings.Join(formats, ",")
+}
+
+// DetectFormat 根据内容识别覆盖率报告格式，无法识别时返回空字符串
+func DetectFormat(content []byte) string {
+	trimmed := bytes.TrimSpace(content)
+	if bytes.HasPrefix(trimmed, []byte("mode:")) {
+		return FormatGo
+	}
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return ""
+			}
+			if start, ok := token.(xml.StartElement); ok {
+				switch start.Name.Local {
+				case "coverage":
+					return FormatCobertura
+				case "report":
+					return FormatJaCoCo
+				}
+				return ""
+			}
+		}
+	}
+	for _, line := range strings.SplitN(string(trimmed), "\n", 20) {
+		if strings.HasPrefix(line, "TN:") || strings.HasPrefix(line, "SF:") {
+			return FormatLcov
+		}
+	}
+	return ""
+}
+
+// ParseCoverage 解析覆盖率报告，format 为空时根据内容识别格式
+func ParseCoverage(content []byte, format string) (*Coverage, error) {
+	if format == "" {
+		if format = DetectFormat(content); format == "" {
+			return nil, fmt.Errorf("unknown coverage report format")
+		}
+	}
+	var cov *Coverage
+	var err error
+	switch format {
+	case FormatCobertura:
+		cov, err = parseCobertura(content)
+	case FormatJaCoCo:
+		cov, err = parseJaCoCo(content)
+	case FormatGo:
+		cov, err = parseGoCover(content)
+	case FormatLcov:
+		cov, err = parseLcov(content)
+	default:
+		return nil, fmt.Errorf("unsupported coverage format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cov.Format = format
+	return cov, nil
+}
+
+type coberturaReport struct {
+	XMLName      xml.Name `xml:"coverage"`
+	LinesCovered *int64   `xml:"lines-covered,attr"`
+	LinesValid   *int64   `xml:"lines-valid,attr"`
+	Classes      []struct {
+		Filename string `xml:"filename,attr"`
+		Lines    []struct {
+			Number int64 `xml:"number,attr"`
+			Hits   int64 `xml:"hits,attr"`
+		} `xml:"lines>line"`
+	} `xml:"packages>package>classes>class"`
+}
+
+// parseCobertura 优先使用根元素的 lines-covered、lines-valid 属性，旧版本报告没有这两个属性时按文件及行号统计
+func parseCobertura(content []byte) (*Coverage, error) {
+	var r coberturaReport
+	if err := xml.Unmarshal(content, &r); err != nil {
+		return nil, err
+	}
+	if r.LinesCovered != nil && r.LinesValid != nil {
+		return &Coverage{LinesCovered: *r.LinesCovered, LinesValid: *r.LinesValid}, nil
+	}
+	lines := make(map[string]bool)
+	for _, class := range r.Classes {
+		for _, line := range class.Lines {
+			key := fmt.Sprintf("%s:%d", class.Filename, line.Number)
+			lines[key] = lines[key] || line.Hits > 0
+		}
+	}
+	return countLines(lines), nil
+}
+
+type jacocoReport struct {
+	XMLName  xml.Name `xml:"report"`
+	Counters []struct {
+		Type    string `xml:"type,attr"`
+		Missed  int64  `xml:"missed,attr"`
+		Covered int64  `xml:"covered,attr"`
+	} `xml:"counter"`
+}
+
+// parseJaCoCo 使用 report 元素下汇总的 LINE 计数器
+func parseJaCoCo(content []byte) (*Coverage, error) {
+	var r jacocoReport
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	// JaCoCo 报告包含指向 report.dtd 的 DOCTYPE，不需要加载
+	decoder.Strict = false
+	if err := decoder.Decode(&r); err != nil {
+		return nil, err
+	}
+	for _, counter := range r.Counters {
+		if counter.Type == "LINE" {
+			return &Coverage{LinesCovered: counter.Covered, LinesValid: counter.Covered + counter.Missed}, nil
+		}
+	}
+	return nil, fmt.Errorf("LINE counter not found in jacoco report")
+}
+
+// parseGoCover 解析 go coverprofile，同一代码块在合并的报告中出现多次时只统计一次，任一次执行即为覆盖
+func parseGoCover(content []byte) (*Coverage, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	stmts := make(map[string]int64)
+	covered := make(map[string]bool)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// 格式为 file:startLine.startCol,endLine.endCol numStmts count
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: invalid coverprofile line", lineNo)
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid statement count %s", lineNo, fields[1])
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid hit count %s", lineNo, fields[2])
+		}
+		stmts[fields[0]] = n
+		covered[fields[0]] = covered[fields[0]] || count > 0
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	cov := &Coverage{}
+	for block, n := range stmts {
+		cov.LinesValid += n
+		if covered[block] {
+			cov.LinesCovered += n
+		}
+	}
+	return cov, nil
+}
+
+// parseLcov 解析 lcov tracefile，优先按 DA 行统计并合并同一文件的多条记录，没有 DA 行的记录使用 LF、LH 汇总值
+func parseLcov(content []byte) (*Coverage, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lines := make(map[string]bool)
+	cov := &Coverage{}
+	file := ""
+	hasDA := false
+	var lf, lh int64
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file, hasDA, lf, lh = strings.TrimPrefix(line, "SF:"), false, 0, 0
+		case strings.HasPrefix(line, "DA:"):
+			// DA:行号,执行次数[,校验和]
+			parts := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("line %d: invalid DA record", lineNo)
+			}
+			hits, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid DA record", lineNo)
+			}
+			key := file + ":" + parts[0]
+			lines[key] = lines[key] || hits > 0
+			hasDA = true
+		case strings.HasPrefix(line, "LF:"):
+			lf, _ = strconv.ParseInt(strings.TrimPrefix(line, "LF:"), 10, 64)
+		case strings.HasPrefix(line, "LH:"):
+			lh, _ = strconv.ParseInt(strings.TrimPrefix(line, "LH:"), 10, 64)
+		case line == "end_of_record":
+			if !hasDA {
+				cov.LinesValid += lf
+				cov.LinesCovered += lh
+			}
+			file = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	daCov := countLines(lines)
+	cov.LinesValid += daCov.LinesValid
+	cov.LinesCovered += daCov.LinesCovered
+	return cov, nil
+}
+
+func countLines(lines map[string]bool) *Coverage {
+	cov := &Coverage{LinesValid: int64(len(lines))}
+	for _, covered := range lines {
+		if covered {
+			cov.LinesCovered++
+		}
+	}
+	return cov
+}
+
+// ReadLimited 读取报告内容，超过 limit 字节时返回错误
+func ReadLimited(r io.Reader, limit int64) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("report larger than %d bytes", limit)
+	}
+	return content, nil
+}
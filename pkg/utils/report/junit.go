@@ -0,0 +1,159 @@
+package report
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+)
+
+// TestCase JUnit 报告中的一个测试用例，Duration 单位为秒
+type TestCase struct {
+	Suite     string  `json:"suite"`
+	ClassName string  `json:"class_name"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Message   string  `json:"message,omitempty"`
+	Output    string  `json:"output,omitempty"`
+	Duration  float64 `json:"duration"`
+}
+
+// TestSummary 测试报告汇总，Cases 包含所有测试用例
+type TestSummary struct {
+	Tests    int         `json:"tests"`
+	Failures int         `json:"failures"`
+	Errors   int         `json:"errors"`
+	Skipped  int         `json:"skipped"`
+	Duration float64     `json:"duration"`
+	Cases    []*TestCase `json:"-"`
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string       `xml:"name,attr"`
+	ClassName string       `xml:"classname,attr"`
+	Time      string       `xml:"time,attr"`
+	Failure   *junitResult `xml:"failure"`
+	Error     *junitResult `xml:"error"`
+	Skipped   *junitResult `xml:"skipped"`
+	SystemOut string       `xml:"system-out"`
+	SystemErr string       `xml:"system-err"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// ParseJUnit 解析 JUnit XML 报告，根元素可以为 testsuites 或 testsuite，支持嵌套的 testsuite，
+// 统计数据根据测试用例计算，不使用 testsuite 上的统计属性
+func ParseJUnit(r io.Reader) (*TestSummary, error) {
+	root := &junitSuite{}
+	if err := xml.NewDecoder(r).Decode(root); err != nil {
+		return nil, err
+	}
+	summary := &TestSummary{}
+	summary.addSuite(root, "")
+	return summary, nil
+}
+
+func (s *TestSummary) addSuite(suite *junitSuite, parent string) {
+	name := suite.Name
+	if name == "" {
+		name = parent
+	}
+	for i := range suite.Cases {
+		s.add(newTestCase(&suite.Cases[i], name))
+	}
+	for i := range suite.Suites {
+		s.addSuite(&suite.Suites[i], name)
+	}
+}
+
+func newTestCase(c *junitCase, suite string) *TestCase {
+	tc := &TestCase{
+		Suite:     suite,
+		ClassName: c.ClassName,
+		Name:      c.Name,
+		Status:    StatusPassed,
+		Duration:  parseSeconds(c.Time),
+	}
+	var result *junitResult
+	switch {
+	case c.Failure != nil:
+		tc.Status, result = StatusFailed, c.Failure
+	case c.Error != nil:
+		tc.Status, result = StatusError, c.Error
+	case c.Skipped != nil:
+		tc.Status, result = StatusSkipped, c.Skipped
+	}
+	if result != nil {
+		tc.Message = strings.TrimSpace(result.Message)
+		if tc.Message == "" {
+			tc.Message = strings.TrimSpace(result.Type)
+		}
+		tc.Output = strings.TrimSpace(result.Body)
+	}
+	if tc.Status == StatusFailed || tc.Status == StatusError {
+		for _, out := range []string{c.SystemOut, c.SystemErr} {
+			if out = strings.TrimSpace(out); out != "" {
+				tc.Output = strings.TrimSpace(tc.Output + "\n" + out)
+			}
+		}
+	}
+	return tc
+}
+
+// parseSeconds 解析 time 属性，部分工具会输出千分位分隔符
+func parseSeconds(s string) float64 {
+	v, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+func (s *TestSummary) add(tc *TestCase) {
+	s.Tests++
+	s.Duration += tc.Duration
+	switch tc.Status {
+	case StatusFailed:
+		s.Failures++
+	case StatusError:
+		s.Errors++
+	case StatusSkipped:
+		s.Skipped++
+	}
+	s.Cases = append(s.Cases, tc)
+}
+
+// Merge 合并另一个测试报告
+func (s *TestSummary) Merge(o *TestSummary) {
+	for _, tc := range o.Cases {
+		s.add(tc)
+	}
+}
+
+// FailedCases 返回失败及出错的测试用例
+func (s *TestSummary) FailedCases() []*TestCase {
+	var cases []*TestCase
+	for _, tc := range s.Cases {
+		if tc.Status == StatusFailed || tc.Status == StatusError {
+			cases = append(cases, tc)
+		}
+	}
+	return cases
+}
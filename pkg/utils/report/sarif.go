@@ -0,0 +1,211 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SARIF 结果级别，none 及通过的结果不作为问题
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+)
+
+// Severities 按严重程度从高到低排列的问题级别
+var Severities = []string{SeverityError, SeverityWarning, SeverityNote}
+
+// Finding 静态分析发现的问题，File 为相对于代码目录的路径，Fingerprint 用于与基线结果比较
+type Finding struct {
+	Tool        string `json:"tool"`
+	RuleId      string `json:"rule_id"`
+	Severity    string `json:"severity"`
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	Message     string `json:"message"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type sarifLog struct {
+	Runs []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	Id                   string        `json:"id"`
+	ShortDescription     *sarifMessage `json:"shortDescription"`
+	DefaultConfiguration *struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleId    string `json:"ruleId"`
+	RuleIndex *int   `json:"ruleIndex"`
+	Rule      *struct {
+		Id    string `json:"id"`
+		Index *int   `json:"index"`
+	} `json:"rule"`
+	Kind      string       `json:"kind"`
+	Level     string       `json:"level"`
+	Message   sarifMessage `json:"message"`
+	Locations []struct {
+		PhysicalLocation *struct {
+			ArtifactLocation *struct {
+				Uri string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region *struct {
+				StartLine int `json:"startLine"`
+			} `json:"region"`
+		} `json:"physicalLocation"`
+	} `json:"locations"`
+	Fingerprints        map[string]string `json:"fingerprints"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Suppressions        []struct {
+		Status string `json:"status"`
+	} `json:"suppressions"`
+}
+
+// ParseSarif 解析 SARIF 2.1 日志中的问题，跳过已抑制及非失败类型的结果，
+// 文件路径去掉 file:// 前缀及 sourceRoot 目录前缀
+func ParseSarif(content []byte, sourceRoot string) ([]*Finding, error) {
+	var log sarifLog
+	if err := json.Unmarshal(content, &log); err != nil {
+		return nil, err
+	}
+	var findings []*Finding
+	for _, run := range log.Runs {
+		tool := run.Tool.Driver.Name
+		rules := run.Tool.Driver.Rules
+		ruleIndex := make(map[string]int)
+		for i, rule := range rules {
+			ruleIndex[rule.Id] = i
+		}
+		for i := range run.Results {
+			result := &run.Results[i]
+			if result.Kind != "" && result.Kind != "fail" || suppressed(result) {
+				continue
+			}
+			var rule *sarifRule
+			ruleId := result.RuleId
+			index := result.RuleIndex
+			if result.Rule != nil {
+				if ruleId == "" {
+					ruleId = result.Rule.Id
+				}
+				if index == nil {
+					index = result.Rule.Index
+				}
+			}
+			if index != nil && *index >= 0 && *index < len(rules) {
+				rule = &rules[*index]
+			} else if j, ok := ruleIndex[ruleId]; ok {
+				rule = &rules[j]
+			}
+			if ruleId == "" && rule != nil {
+				ruleId = rule.Id
+			}
+			severity := result.Level
+			if severity == "" && rule != nil && rule.DefaultConfiguration != nil {
+				severity = rule.DefaultConfiguration.Level
+			}
+			if severity == "none" {
+				continue
+			}
+			if SeverityRank(severity) == len(Severities) {
+				severity = SeverityWarning
+			}
+			finding := &Finding{
+				Tool:     tool,
+				RuleId:   ruleId,
+				Severity: severity,
+				Message:  strings.TrimSpace(result.Message.Text),
+			}
+			if finding.Message == "" && rule != nil && rule.ShortDescription != nil {
+				finding.Message = rule.ShortDescription.Text
+			}
+			if len(result.Locations) > 0 && result.Locations[0].PhysicalLocation != nil {
+				loc := result.Locations[0].PhysicalLocation
+				if loc.ArtifactLocation != nil {
+					finding.File = normalizeUri(loc.ArtifactLocation.Uri, sourceRoot)
+				}
+				if loc.Region != nil {
+					finding.Line = loc.Region.StartLine
+				}
+			}
+			finding.Fingerprint = fingerprint(finding, result)
+			findings = append(findings, finding)
+		}
+	}
+	return findings, nil
+}
+
+func suppressed(result *sarifResult) bool {
+	for _, s := range result.Suppressions {
+		if s.Status == "" || s.Status == "accepted" {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeUri(uri, sourceRoot string) string {
+	if u, err := url.Parse(uri); err == nil && (u.Scheme == "file" || u.Scheme == "") {
+		uri = u.Path
+	}
+	root := strings.TrimSuffix(sourceRoot, "/") + "/"
+	if sourceRoot != "" && strings.HasPrefix(uri, root) {
+		uri = uri[len(root):]
+	}
+	return strings.TrimPrefix(uri, "./")
+}
+
+// fingerprint 优先使用分析器输出的指纹，否则使用规则、文件及消息计算，不包含行号，代码移动后仍能与基线匹配
+func fingerprint(finding *Finding, result *sarifResult) string {
+	prints := result.Fingerprints
+	if len(prints) == 0 {
+		prints = result.PartialFingerprints
+	}
+	var parts []string
+	if len(prints) > 0 {
+		var keys []string
+		for k := range prints {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			parts = append(parts, k+"="+prints[k])
+		}
+	} else {
+		parts = []string{finding.File, finding.Message}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(append([]string{finding.Tool, finding.RuleId}, parts...), "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// SeverityRank 问题级别的严重程度，数值越小越严重，未知级别排在最后
+func SeverityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return len(Severities)
+}
@@ -0,0 +1,80 @@
+package report
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// VulnSeverities trivy 漏洞级别，按严重程度从高到低排列
+var VulnSeverities = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN"}
+
+// Vulnerability 镜像中的一个漏洞，Target 为漏洞所在的系统包或依赖文件
+type Vulnerability struct {
+	Target           string `json:"target"`
+	VulnerabilityId  string `json:"vulnerability_id"`
+	PkgName          string `json:"pkg_name"`
+	InstalledVersion string `json:"installed_version"`
+	FixedVersion     string `json:"fixed_version"`
+	Severity         string `json:"severity"`
+	Title            string `json:"title"`
+	PrimaryUrl       string `json:"primary_url"`
+}
+
+type trivyReport struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+			PrimaryURL       string `json:"PrimaryURL"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// ParseTrivy 解析 trivy --format json 输出的漏洞，同一目标中重复的漏洞只保留一个，未知级别归为 UNKNOWN
+func ParseTrivy(content []byte) ([]*Vulnerability, error) {
+	var r trivyReport
+	if err := json.Unmarshal(content, &r); err != nil {
+		return nil, err
+	}
+	var vulns []*Vulnerability
+	seen := make(map[string]bool)
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			key := strings.Join([]string{result.Target, v.VulnerabilityID, v.PkgName, v.InstalledVersion}, "\x00")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			severity := strings.ToUpper(v.Severity)
+			if VulnSeverityRank(severity) == len(VulnSeverities) {
+				severity = "UNKNOWN"
+			}
+			vulns = append(vulns, &Vulnerability{
+				Target:           result.Target,
+				VulnerabilityId:  v.VulnerabilityID,
+				PkgName:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         severity,
+				Title:            v.Title,
+				PrimaryUrl:       v.PrimaryURL,
+			})
+		}
+	}
+	return vulns, nil
+}
+
+// VulnSeverityRank 漏洞级别的严重程度，数值越小越严重，未知级别返回 len(VulnSeverities)
+func VulnSeverityRank(severity string) int {
+	for i, s := range VulnSeverities {
+		if s == severity {
+			return i
+		}
+	}
+	return len(VulnSeverities)
+}
@@ -0,0 +1,34 @@
+package semver
+
+import (
+	"regexp"
+	"strings"
+)
+
+// conventionalRe conventional commits 的标题格式：type(scope)!: description
+var conventionalRe = regexp.MustCompile(`^([a-zA-Z]+)(\([^()]*\))?(!)?: \S`)
+
+// ConventionalBump 根据 conventional commits 提交信息计算版本升级部分：
+// 包含 ! 或 BREAKING CHANGE 时升级 major，包含 feat 时升级 minor，其它情况升级 patch
+func ConventionalBump(messages []string) string {
+	bump := BumpPatch
+	for _, message := range messages {
+		lines := strings.Split(strings.TrimSpace(message), "\n")
+		m := conventionalRe.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			continue
+		}
+		if m[3] == "!" {
+			return BumpMajor
+		}
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(line, "BREAKING CHANGE:") || strings.HasPrefix(line, "BREAKING-CHANGE:") {
+				return BumpMajor
+			}
+		}
+		if strings.ToLower(m[1]) == "feat" {
+			bump = BumpMinor
+		}
+	}
+	return bump
+}
@@ -0,0 +1,138 @@
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+)
+
+// versionRe 语义化版本 2.0.0 格式，允许 v 前缀
+var versionRe = regexp.MustCompile(`^(v?)(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// Version 语义化版本，Prefix 为版本号前的 v，比较版本时忽略 Prefix 及 Build
+type Version struct {
+	Prefix     string
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      string
+}
+
+// Parse 解析语义化版本，如：1.2.3、v1.2.3-rc.1+build.5
+func Parse(s string) (*Version, error) {
+	m := versionRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%s is not a semantic version", s)
+	}
+	v := &Version{Prefix: m[1], Build: m[6]}
+	var err error
+	for i, n := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		if *n, err = strconv.ParseUint(m[i+2], 10, 64); err != nil {
+			return nil, fmt.Errorf("%s is not a semantic version: %v", s, err)
+		}
+	}
+	if m[5] != "" {
+		v.Prerelease = strings.Split(m[5], ".")
+	}
+	return v, nil
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%s%d.%d.%d", v.Prefix, v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare 按语义化版本的优先级比较，v 小于、等于、大于 o 时分别返回 -1、0、1
+func (v *Version) Compare(o *Version) int {
+	for _, pair := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	// 预发布版本低于正式版本
+	if len(v.Prerelease) == 0 || len(o.Prerelease) == 0 {
+		return compareInt(len(o.Prerelease), len(v.Prerelease))
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(v.Prerelease), len(o.Prerelease))
+}
+
+// compareIdentifier 数字标识按数值比较且低于非数字标识，非数字标识按 ASCII 顺序比较
+func compareIdentifier(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if an == bn {
+			return 0
+		}
+		if an < bn {
+			return -1
+		}
+		return 1
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Bump 返回升级后的版本，保留 Prefix，去掉预发布及构建信息。
+// 预发布版本升级为对应的正式版本，如 1.2.0-rc.1 升级 minor 为 1.2.0
+func (v *Version) Bump(part string) (*Version, error) {
+	next := &Version{Prefix: v.Prefix, Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	pre := len(v.Prerelease) > 0
+	switch part {
+	case BumpMajor:
+		if !pre || v.Minor != 0 || v.Patch != 0 {
+			next.Major++
+			next.Minor, next.Patch = 0, 0
+		}
+	case BumpMinor:
+		if !pre || v.Patch != 0 {
+			next.Minor++
+			next.Patch = 0
+		}
+	case BumpPatch:
+		if !pre {
+			next.Patch++
+		}
+	default:
+		return nil, fmt.Errorf("unknown version part %s", part)
+	}
+	return next, nil
+}
@@ -0,0 +1,189 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/registry"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// MediaTypeSimpleSigning cosign 签名载荷的媒体类型
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// AnnotationSignature 签名层中保存 base64 签名的注解
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+
+	signatureType = "cosign container image signature"
+	// maxPayloadSize 签名载荷大小上限，正常的载荷只有几百字节
+	maxPayloadSize = 1 << 20
+)
+
+var ErrNoSignature = errors.New("no valid signature")
+
+// Payload cosign 签名载荷，即 Red Hat simple signing 格式
+type Payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// SignatureTag 镜像签名保存的 tag，与 cosign 一致为 sha256-<hex>.sig
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// Sign 为仓库中 digest 对应的镜像生成 cosign 格式的签名并推送到 sha256-<hex>.sig tag，
+// tag 已存在时追加签名层，dockerReference 为不带 tag 的镜像名
+func Sign(client *registry.Client, repository, dockerReference, digest string, signer crypto.Signer) error {
+	var payload Payload
+	payload.Critical.Identity.DockerReference = dockerReference
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = signatureType
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	sig, err := sign(signer, body)
+	if err != nil {
+		return fmt.Errorf("sign payload error: %v", err)
+	}
+	layer := registry.Descriptor{
+		MediaType:   MediaTypeSimpleSigning,
+		Digest:      registry.Digest(body),
+		Size:        int64(len(body)),
+		Annotations: map[string]string{AnnotationSignature: base64.StdEncoding.EncodeToString(sig)},
+	}
+	if err = pushBlob(client, repository, layer.Digest, body); err != nil {
+		return err
+	}
+	tag := SignatureTag(digest)
+	manifest := &registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIManifest}
+	content, err := client.GetManifest(repository, tag)
+	if err == nil {
+		if manifest, err = content.Manifest(); err != nil {
+			return err
+		}
+	} else if !registry.IsNotFound(err) {
+		return err
+	}
+	// 同一密钥已签名时不重复添加签名层
+	for _, l := range manifest.Layers {
+		if l.Digest != layer.Digest {
+			continue
+		}
+		if existing, err := base64.StdEncoding.DecodeString(l.Annotations[AnnotationSignature]); err == nil && verify(signer.Public(), body, existing) {
+			return nil
+		}
+	}
+	manifest.Layers = append(manifest.Layers, layer)
+	// 配置与 cosign 相同，rootfs 包含所有签名层
+	var diffIds []string
+	for _, l := range manifest.Layers {
+		diffIds = append(diffIds, l.Digest)
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"config":       map[string]interface{}{},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIds},
+	})
+	if err != nil {
+		return err
+	}
+	if err = pushBlob(client, repository, registry.Digest(config), config); err != nil {
+		return err
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = registry.MediaTypeOCIManifest
+	}
+	configMediaType := "application/vnd.oci.image.config.v1+json"
+	if mediaType == registry.MediaTypeDockerManifest {
+		configMediaType = "application/vnd.docker.container.image.v1+json"
+	}
+	manifest.Config = &registry.Descriptor{MediaType: configMediaType, Digest: registry.Digest(config), Size: int64(len(config))}
+	body, err = json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutManifest(repository, tag, mediaType, body)
+	return err
+}
+
+// Verify 校验仓库中 digest 对应镜像的 cosign 签名，返回第一个验证通过的公钥序号，没有有效签名时返回 ErrNoSignature
+func Verify(client *registry.Client, repository, digest string, publicKeys []crypto.PublicKey) (int, error) {
+	content, err := client.GetManifest(repository, SignatureTag(digest))
+	if registry.IsNotFound(err) {
+		return -1, ErrNoSignature
+	}
+	if err != nil {
+		return -1, err
+	}
+	manifest, err := content.Manifest()
+	if err != nil {
+		return -1, err
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != MediaTypeSimpleSigning || layer.Size > maxPayloadSize {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(layer.Annotations[AnnotationSignature])
+		if err != nil || len(sig) == 0 {
+			continue
+		}
+		body, err := fetchBlob(client, repository, layer.Digest)
+		if err != nil {
+			return -1, err
+		}
+		var payload Payload
+		if registry.Digest(body) != layer.Digest || json.Unmarshal(body, &payload) != nil {
+			continue
+		}
+		if payload.Critical.Type != signatureType || payload.Critical.Image.DockerManifestDigest != digest {
+			continue
+		}
+		for i, key := range publicKeys {
+			if verify(key, body, sig) {
+				return i, nil
+			}
+		}
+	}
+	return -1, ErrNoSignature
+}
+
+func pushBlob(client *registry.Client, repository, digest string, content []byte) error {
+	exists, err := client.BlobExists(repository, digest)
+	if err != nil || exists {
+		return err
+	}
+	return client.UploadBlob(repository, digest, int64(len(content)), bytes.NewReader(content))
+}
+
+func fetchBlob(client *registry.Client, repository, digest string) ([]byte, error) {
+	reader, _, err := client.GetBlob(repository, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(reader, maxPayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxPayloadSize {
+		return nil, fmt.Errorf("signature payload %s too large", digest)
+	}
+	return body, nil
+}
@@ -0,0 +1,131 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// cosign generate-key-pair 生成的加密私钥，新版本 cosign 使用 SIGSTORE 类型
+	pemTypeCosignPrivate   = "ENCRYPTED COSIGN PRIVATE KEY"
+	pemTypeSigstorePrivate = "ENCRYPTED SIGSTORE PRIVATE KEY"
+)
+
+// encryptedKey cosign 加密私钥的内容，使用 scrypt 从密码派生密钥，nacl/secretbox 加密 PKCS8 格式的私钥
+type encryptedKey struct {
+	Kdf struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadPrivateKey 解析 PEM 格式的私钥，支持 cosign 加密私钥及未加密的 PKCS8、EC、PKCS1 私钥
+func LoadPrivateKey(content []byte, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("invalid pem private key")
+	}
+	der := block.Bytes
+	switch block.Type {
+	case pemTypeCosignPrivate, pemTypeSigstorePrivate:
+		var err error
+		if der, err = decryptKey(block.Bytes, password); err != nil {
+			return nil, err
+		}
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("unsupported private key type %s", block.Type)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
+
+func decryptKey(content []byte, password []byte) ([]byte, error) {
+	var key encryptedKey
+	if err := json.Unmarshal(content, &key); err != nil {
+		return nil, fmt.Errorf("unmarshal encrypted private key error: %v", err)
+	}
+	if key.Kdf.Name != "scrypt" || key.Cipher.Name != "nacl/secretbox" {
+		return nil, fmt.Errorf("unsupported private key encryption %s %s", key.Kdf.Name, key.Cipher.Name)
+	}
+	if len(key.Cipher.Nonce) != 24 {
+		return nil, errors.New("invalid private key nonce")
+	}
+	secret, err := scrypt.Key(password, key.Kdf.Salt, key.Kdf.Params.N, key.Kdf.Params.R, key.Kdf.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	var secretKey [32]byte
+	copy(nonce[:], key.Cipher.Nonce)
+	copy(secretKey[:], secret)
+	der, ok := secretbox.Open(nil, key.Ciphertext, &nonce, &secretKey)
+	if !ok {
+		return nil, errors.New("decrypt private key failed, the password is wrong")
+	}
+	return der, nil
+}
+
+// LoadPublicKey 解析 PEM 格式的 PKIX 公钥
+func LoadPublicKey(content []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("invalid pem public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// sign 使用与 cosign 相同的算法签名：ecdsa、rsa 对 sha256 摘要签名，ed25519 直接签名原文
+func sign(signer crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// verify 校验签名，支持 ecdsa、rsa 及 ed25519 公钥
+func verify(publicKey crypto.PublicKey, payload, sig []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -13,3 +16,63 @@ func GetCodeRepoName(codeUrl string) string {
 	codeSplit = strings.Split(codeDir, ".")
 	return codeSplit[0]
 }
+
+// ParseMemorySize 解析内存大小，返回字节数
+// 如：1024 -> 1024，512m -> 536870912，2g/2Gi -> 2147483648
+func ParseMemorySize(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "b"), "i")
+	if s == "" {
+		return 0, fmt.Errorf("invalid memory size %q", size)
+	}
+	unit := int64(1)
+	switch s[len(s)-1] {
+	case 'k':
+		unit = 1 << 10
+	case 'm':
+		unit = 1 << 20
+	case 'g':
+		unit = 1 << 30
+	case 't':
+		unit = 1 << 40
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", size)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+var shellSafeRe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// ShellQuote 将参数转义为 POSIX shell 中的单个字符串，单引号的转义如：
+//
+//	it's -> 'it'\''s'
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if shellSafeRe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ShellJoin 转义并拼接命令参数，用于打印命令行或在远程 shell 中执行
+func ShellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// IsEnvName 判断是否为合法的 shell 环境变量名
+func IsEnvName(name string) bool {
+	return envNameRe.MatchString(name)
+}
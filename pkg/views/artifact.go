@@ -0,0 +1,63 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/plugins"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"gorm.io/gorm"
+	"k8s.io/klog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+type ArtifactViews struct {
+	Views []*View
+}
+
+func NewArtifactViews() *ArtifactViews {
+	av := &ArtifactViews{}
+	av.Views = []*View{
+		NewView(http.MethodGet, "/:jobId", av.list),
+		NewView(http.MethodGet, "/:jobId/:name", av.download),
+	}
+	return av
+}
+
+func (a *ArtifactViews) list(c *Context) *utils.Response {
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "job id error: " + err.Error()}
+	}
+	artifacts, err := models.Models.ArtifactManager.List(uint(jobId))
+	if err != nil {
+		return &utils.Response{Code: code.DBError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Data: artifacts}
+}
+
+// download 下载产物的 tar.gz 文件，成功时直接写入响应，不返回 json
+func (a *ArtifactViews) download(c *Context) *utils.Response {
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "job id error: " + err.Error()}
+	}
+	name := c.Param("name")
+	artifact, reader, size, err := plugins.OpenArtifact(uint(jobId), name)
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, os.ErrNotExist) {
+		return &utils.Response{Code: code.DataNotExists, Msg: fmt.Sprintf("任务%d产物%s不存在", jobId, name)}
+	}
+	if err != nil {
+		klog.Errorf("open job %d artifact %s error: %v", jobId, name, err)
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	defer reader.Close()
+	c.DataFromReader(http.StatusOK, size, "application/gzip", reader, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s.tar.gz"`, artifact.Name),
+		"X-Artifact-Sha256":   artifact.Sha256,
+	})
+	return nil
+}
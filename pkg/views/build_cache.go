@@ -0,0 +1,44 @@
+package views
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/plugins"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"net/http"
+)
+
+type BuildCacheViews struct {
+	Views []*View
+}
+
+func NewBuildCacheViews() *BuildCacheViews {
+	bv := &BuildCacheViews{}
+	bv.Views = []*View{
+		NewView(http.MethodGet, "", bv.list),
+		NewView(http.MethodDelete, "", bv.purgeAll),
+		NewView(http.MethodDelete, "/:key", bv.purge),
+	}
+	return bv
+}
+
+func (b *BuildCacheViews) list(c *Context) *utils.Response {
+	caches, err := plugins.BuildCaches.List()
+	if err != nil {
+		return &utils.Response{Code: code.OSError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Data: caches}
+}
+
+func (b *BuildCacheViews) purge(c *Context) *utils.Response {
+	if err := plugins.BuildCaches.Purge(c.Param("key")); err != nil {
+		return &utils.Response{Code: code.DeleteError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success}
+}
+
+func (b *BuildCacheViews) purgeAll(c *Context) *utils.Response {
+	if err := plugins.BuildCaches.PurgeAll(); err != nil {
+		return &utils.Response{Code: code.DeleteError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success}
+}
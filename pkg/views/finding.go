@@ -0,0 +1,46 @@
+package views
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"net/http"
+	"strconv"
+)
+
+type FindingViews struct {
+	Views []*View
+}
+
+func NewFindingViews() *FindingViews {
+	fv := &FindingViews{}
+	fv.Views = []*View{
+		NewView(http.MethodGet, "/:jobId", fv.list),
+	}
+	return fv
+}
+
+// list 获取代码扫描任务发现的问题，可以通过 severity、new 参数过滤
+func (f *FindingViews) list(c *Context) *utils.Response {
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "job id error: " + err.Error()}
+	}
+	findings, err := models.Models.FindingManager.List(uint(jobId))
+	if err != nil {
+		return &utils.Response{Code: code.DBError, Msg: err.Error()}
+	}
+	severity := c.Query("severity")
+	newOnly := c.Query("new") == "true"
+	filtered := findings[:0]
+	for _, finding := range findings {
+		if severity != "" && finding.Severity != severity {
+			continue
+		}
+		if newOnly && !finding.IsNew {
+			continue
+		}
+		filtered = append(filtered, finding)
+	}
+	return &utils.Response{Code: code.Success, Data: filtered}
+}
@@ -13,6 +13,8 @@ type PluginViews struct {
 	builder   *plugins.CodeBuilder
 	releaser  *plugins.Releaser
 	execShell *plugins.ExecShell
+	promoter  *plugins.ImagePromoter
+	scanner   *plugins.CodeScanner
 }
 
 func NewPluginViews() *PluginViews {
@@ -20,11 +22,15 @@ func NewPluginViews() *PluginViews {
 		builder:   plugins.NewBuilder(),
 		releaser:  plugins.NewReleaser(),
 		execShell: plugins.NewExecShell(),
+		promoter:  plugins.NewImagePromoter(),
+		scanner:   plugins.NewCodeScanner(),
 	}
 	pv.Views = []*View{
 		NewView(http.MethodPost, "/build_code_to_image", pv.buildCodeToImage),
 		NewView(http.MethodPost, "/release", pv.release),
 		NewView(http.MethodPost, "/execute_shell", pv.shell),
+		NewView(http.MethodPost, "/promote_image", pv.promoteImage),
+		NewView(http.MethodPost, "/code_scan", pv.codeScan),
 	}
 	return pv
 }
@@ -55,3 +61,21 @@ func (p *PluginViews) shell(c *Context) *utils.Response {
 	}
 	return p.execShell.ExecuteShell(&ser)
 }
+
+func (p *PluginViews) promoteImage(c *Context) *utils.Response {
+	var ser serializers.PromoteImageSerializer
+
+	if err := c.ShouldBind(&ser); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	return p.promoter.PromoteImage(&ser)
+}
+
+func (p *PluginViews) codeScan(c *Context) *utils.Response {
+	var ser serializers.CodeScanSerializer
+
+	if err := c.ShouldBind(&ser); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	return p.scanner.CodeScan(&ser)
+}
@@ -1,8 +1,40 @@
 package serializers
 
 type ImageBuilds struct {
-	Dockerfile string `json:"dockerfile"`
-	Image      string `json:"image"`
+	// Dockerfile 代码仓库中 Dockerfile 的相对路径，为 auto 时根据项目类型自动生成
+	Dockerfile string           `json:"dockerfile"`
+	Image      string           `json:"image"`
+	Platforms  []string         `json:"platforms"`
+	Cache      *ImageBuildCache `json:"cache"`
+}
+
+type ImageBuildCache struct {
+	// Mode 缓存导出方式：inline 将缓存元数据写入镜像，registry 将缓存单独推送到 Ref
+	Mode string   `json:"mode"`
+	Ref  string   `json:"ref"`
+	From []string `json:"from"`
+}
+
+type BuildCache struct {
+	// Path 容器内的缓存目录，如 /root/.m2
+	Path string `json:"path"`
+	// Key 缓存 key 模板，如 maven-{{ hashFiles "pom.xml" }}
+	Key string `json:"key"`
+}
+
+type ContainerResources struct {
+	// Cpus 容器可使用的 cpu 核数，如 1.5
+	Cpus string `json:"cpus"`
+	// Memory 容器内存上限，如 512m、2g
+	Memory string `json:"memory"`
+	// Pids 容器内进程数上限
+	Pids int64 `json:"pids"`
+	// Network 容器网络模式：none、bridge、host
+	Network string `json:"network"`
+	// ReadOnly 容器根文件系统只读
+	ReadOnly bool `json:"read_only"`
+	// User 容器运行用户，格式为 uid[:gid]，为空时使用插件服务的用户
+	User string `json:"user"`
 }
 
 type ImageRegistry struct {
@@ -27,6 +59,8 @@ type PipelineResource struct {
 
 type BuildCodeToImageSerializer struct {
 	JobId uint `json:"job_id"`
+	// WorkspaceId 流水线所属空间，构建缓存按空间隔离
+	WorkspaceId uint `json:"workspace_id"`
 
 	CodeUrl         string           `json:"code_url"`
 	CodeBranch      string           `json:"code_branch"`
@@ -38,10 +72,143 @@ type BuildCodeToImageSerializer struct {
 	CodeBuildFile   string           `json:"code_build_file"`
 	CodeBuildScript string           `json:"code_build_script"`
 	CodeBuildExec   string           `json:"code_build_exec"`
+	CodeBuildCaches []BuildCache     `json:"code_build_caches"`
+
+	CodeBuildResources ContainerResources `json:"code_build_resources"`
+	// Services 代码构建前在任务网络中启动的服务容器，构建脚本通过别名访问
+	Services []BuildService `json:"services"`
+	// CodeBuildMatrix 代码构建矩阵，为空时使用 CodeBuildImage 执行一次构建
+	CodeBuildMatrix *CodeBuildMatrix `json:"code_build_matrix"`
+	// CodeBuildDefinition 代码仓库中构建定义文件的相对路径，为空时使用 .kubespace/build.yaml
+	CodeBuildDefinition string `json:"code_build_definition"`
+	// CodeBuildDefinitionPrecedence 构建定义文件与流水线配置的优先级：pipeline（默认）流水线配置优先，
+	// repository 构建定义文件优先，未配置的项使用另一方的配置
+	CodeBuildDefinitionPrecedence string `json:"code_build_definition_precedence"`
+	// CodeBuildReports 代码构建生成的测试及覆盖率报告，为空时不解析
+	CodeBuildReports *CodeBuildReports `json:"code_build_reports"`
 
 	ImageBuildRegistryId int           `json:"image_registry_id"`
 	ImageBuildRegistry   ImageRegistry `json:"image_build_registry"`
 	ImageBuilds          []ImageBuilds `json:"image_builds"`
+	ImageBuilder         string        `json:"image_builder"`
+	// DockerfilePolicy 镜像构建前检查 Dockerfile 的规则，为空时不检查
+	DockerfilePolicy *DockerfilePolicy `json:"dockerfile_policy"`
+	// ImageScan 镜像推送后的漏洞扫描，为空时不扫描
+	ImageScan *ImageScan `json:"image_scan"`
+	// Sbom 镜像推送后生成软件物料清单，为空时不生成
+	Sbom *Sbom `json:"sbom"`
+	// Signing 使用服务端密钥签名推送的镜像，为空时不签名
+	Signing *ImageSigning `json:"signing"`
+
+	// ContainerRuntime 执行构建容器的容器运行时，为空时使用服务默认配置
+	ContainerRuntime string `json:"container_runtime"`
+	// Artifacts 代码构建结束后归档的构建产物，路径相对于代码目录
+	Artifacts []Artifact `json:"artifacts"`
+	// Inputs 代码构建前下载的之前任务的产物，解压到代码目录
+	Inputs []ArtifactInput `json:"inputs"`
+}
+
+// Artifact 归档的构建产物，匹配的文件打包为一个 tar.gz 文件
+type Artifact struct {
+	// Name 产物名称，同一任务中唯一
+	Name string `json:"name"`
+	// Paths glob 路径，支持 **，匹配目录时归档目录下的所有文件
+	Paths []string `json:"paths"`
+	// Optional 没有匹配的文件时不报错
+	Optional bool `json:"optional"`
+}
+
+// ArtifactInput 任务执行前下载并解压到工作目录的之前任务的产物
+type ArtifactInput struct {
+	// JobId 产生产物的任务 id
+	JobId uint   `json:"job_id"`
+	Name  string `json:"name"`
+	// Path 解压到的目录，相对于工作目录，为空时解压到工作目录下
+	Path string `json:"path"`
+}
+
+// CodeBuildReports 代码构建生成的测试及覆盖率报告，路径为相对于代码目录的 glob 路径
+type CodeBuildReports struct {
+	// JUnit JUnit XML 测试报告路径
+	JUnit []string `json:"junit"`
+	// Coverage 覆盖率报告路径
+	Coverage []string `json:"coverage"`
+	// CoverageFormat 覆盖率报告格式：cobertura、jacoco、go、lcov，为空时根据内容识别
+	CoverageFormat string `json:"coverage_format"`
+	// CoverageThreshold 行覆盖率下限百分比，低于下限时任务失败，0 为不检查
+	CoverageThreshold float64 `json:"coverage_threshold"`
+}
+
+// DockerfilePolicy Dockerfile 检查规则
+type DockerfilePolicy struct {
+	// Action 违反规则时的处理方式：warn 只在日志中输出（默认），fail 任务失败
+	Action string `json:"action"`
+	// AllowedRegistries 允许使用的基础镜像仓库，可以包含仓库路径前缀，如 registry.example.com/base，为空时不限制
+	AllowedRegistries []string `json:"allowed_registries"`
+	// ForbidLatest 禁止基础镜像使用 latest tag 或不指定 tag
+	ForbidLatest bool `json:"forbid_latest"`
+	// RequireDigest 基础镜像必须通过 digest 固定版本
+	RequireDigest bool `json:"require_digest"`
+	// RequireNonRootUser 最终阶段必须通过 USER 指令使用非 root 用户
+	RequireNonRootUser bool `json:"require_non_root_user"`
+	// ForbidAddUrl 禁止使用 ADD 下载远程文件
+	ForbidAddUrl bool `json:"forbid_add_url"`
+}
+
+// ImageScan 使用 trivy 离线漏洞库扫描推送的镜像，阈值为各级别允许的最大漏洞数，
+// 级别为 CRITICAL、HIGH、MEDIUM、LOW、UNKNOWN，未配置的级别不限制
+type ImageScan struct {
+	// FailThresholds 超过时任务失败
+	FailThresholds map[string]int `json:"fail_thresholds"`
+	// UnstableThresholds 超过时任务标记为不稳定
+	UnstableThresholds map[string]int `json:"unstable_thresholds"`
+	// IgnoreUnfixed 忽略没有修复版本的漏洞
+	IgnoreUnfixed bool `json:"ignore_unfixed"`
+}
+
+// Sbom 使用 syft 为推送的镜像及代码目录生成软件物料清单，清单保存为任务的 sbom 产物
+type Sbom struct {
+	// Formats 清单格式，支持 spdx-json、cyclonedx-json，为空时生成两种格式
+	Formats []string `json:"formats"`
+	// Attach 将镜像的物料清单作为 OCI referrer 推送到镜像仓库，关联到镜像 digest
+	Attach bool `json:"attach"`
+}
+
+// ImageSigning 镜像签名参数，签名格式与 cosign 兼容，保存在镜像仓库的 sha256-<digest>.sig tag 中
+type ImageSigning struct {
+	// Key 服务端签名密钥目录中的密钥名称
+	Key string `json:"key"`
+}
+
+// CodeBuildMatrix 按构建镜像与环境变量的所有组合并行执行构建脚本
+type CodeBuildMatrix struct {
+	// Images 构建镜像，为空时使用 CodeBuildImage
+	Images []PipelineResource `json:"images"`
+	// Envs 环境变量组，每组与每个镜像组合为一个矩阵单元
+	Envs []map[string]string `json:"envs"`
+	// FailFast 任一单元失败时终止其它正在执行的单元
+	FailFast bool `json:"fail_fast"`
+	// MaxParallel 同时执行的最大单元数，0 为不限制
+	MaxParallel int `json:"max_parallel"`
+}
+
+// BuildService 代码构建时启动的服务容器，如集成测试使用的 mysql、redis
+type BuildService struct {
+	Image string `json:"image"`
+	// Alias 构建容器访问服务使用的主机名，为空时使用镜像名称
+	Alias       string              `json:"alias"`
+	Env         map[string]string   `json:"env"`
+	Command     []string            `json:"command"`
+	HealthCheck *ServiceHealthCheck `json:"health_check"`
+}
+
+// ServiceHealthCheck 服务容器健康检查，时间单位为秒
+type ServiceHealthCheck struct {
+	Cmd         string `json:"cmd"`
+	Interval    int    `json:"interval"`
+	Timeout     int    `json:"timeout"`
+	Retries     int    `json:"retries"`
+	StartPeriod int    `json:"start_period"`
 }
 
 type ReleaseSerializer struct {
@@ -57,6 +224,22 @@ type ReleaseSerializer struct {
 
 	Version string `json:"version"`
 	Images  string `json:"images"`
+
+	// VerifyKeys 打标签前校验镜像签名使用的密钥名称，镜像需要有其中一个密钥的有效签名，为空时不校验
+	VerifyKeys []string `json:"verify_keys"`
+	// Signing 使用服务端密钥签名发布的镜像，为空时不签名
+	Signing *ImageSigning `json:"signing"`
+	// Versioning 语义化版本校验及自动升级，为空时版本号不校验格式
+	Versioning *ReleaseVersioning `json:"versioning"`
+}
+
+// ReleaseVersioning 发布版本需要符合语义化版本且大于空间已发布的最新版本，
+// Version 为空时根据 Bump 在最新版本的基础上自动计算版本号
+type ReleaseVersioning struct {
+	// Bump 版本升级部分：major、minor、patch，auto 根据上次发布以来的 conventional commits 提交信息计算
+	Bump string `json:"bump"`
+	// Prefix 自动计算的版本号前缀，如 v，为空时与最新版本一致
+	Prefix string `json:"prefix"`
 }
 
 type ExecShellSerializer struct {
@@ -67,4 +250,58 @@ type ExecShellSerializer struct {
 	Shell    string                 `json:"shell"`
 	Script   string                 `json:"script"`
 	Env      map[string]interface{} `json:"env"`
+
+	Resources ContainerResources `json:"resources"`
+	// ContainerRuntime 镜像类型资源执行脚本的容器运行时，为空时使用服务默认配置
+	ContainerRuntime string `json:"container_runtime"`
+	// Artifacts 脚本执行结束后归档的产物，路径相对于脚本的工作目录
+	Artifacts []Artifact `json:"artifacts"`
+	// Inputs 脚本执行前下载的之前任务的产物，解压到脚本的工作目录，主机资源通过 sftp 上传
+	Inputs []ArtifactInput `json:"inputs"`
+}
+
+type PromoteImageSerializer struct {
+	JobId uint `json:"job_id"`
+
+	SourceRegistry ImageRegistry `json:"source_registry"`
+	TargetRegistry ImageRegistry `json:"target_registry"`
+
+	Images     []string          `json:"images"`
+	TagMapping map[string]string `json:"tag_mapping"`
+	Platforms  []string          `json:"platforms"`
+}
+
+type CodeScanSerializer struct {
+	JobId uint `json:"job_id"`
+
+	CodeUrl      string `json:"code_url"`
+	CodeBranch   string `json:"code_branch"`
+	CodeCommitId string `json:"code_commit_id"`
+	CodeSecret   Secret `json:"code_secret"`
+
+	// AnalyzerImage 执行扫描的分析器镜像
+	AnalyzerImage PipelineResource `json:"analyzer_image"`
+	// AnalyzerScript 在分析器容器的代码目录中执行的扫描脚本，SARIF 结果写入 $SARIF_OUTPUT_DIR 目录
+	AnalyzerScript string            `json:"analyzer_script"`
+	AnalyzerExec   string            `json:"analyzer_exec"`
+	Env            map[string]string `json:"env"`
+	// SarifPaths 代码目录中 SARIF 结果文件的 glob 路径，为空时读取 $SARIF_OUTPUT_DIR 目录下的 .sarif 文件
+	SarifPaths []string `json:"sarif_paths"`
+
+	Resources ContainerResources `json:"resources"`
+	// ContainerRuntime 执行分析器容器的容器运行时，为空时使用服务默认配置
+	ContainerRuntime string `json:"container_runtime"`
+
+	// BaselineJobId 基线扫描任务 id，不为空时区分新增问题与已有问题
+	BaselineJobId uint `json:"baseline_job_id"`
+	// Gate 问题数门禁，为空时不检查
+	Gate *CodeScanGate `json:"gate"`
+}
+
+// CodeScanGate 代码扫描门禁，问题数超过级别上限时任务失败
+type CodeScanGate struct {
+	// Thresholds 各级别允许的最大问题数，级别为 error、warning、note，未配置的级别不限制
+	Thresholds map[string]int `json:"thresholds"`
+	// NewOnly 只统计相对基线任务新增的问题，没有基线任务时统计所有问题
+	NewOnly bool `json:"new_only"`
 }
@@ -0,0 +1,47 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+type TestReportViews struct {
+	Views []*View
+}
+
+func NewTestReportViews() *TestReportViews {
+	tv := &TestReportViews{}
+	tv.Views = []*View{
+		NewView(http.MethodGet, "/:jobId", tv.get),
+	}
+	return tv
+}
+
+// get 获取任务的测试报告汇总及失败的测试用例
+func (t *TestReportViews) get(c *Context) *utils.Response {
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "job id error: " + err.Error()}
+	}
+	report, err := models.Models.TestReportManager.Get(uint(jobId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &utils.Response{Code: code.DataNotExists, Msg: fmt.Sprintf("任务%d测试报告不存在", jobId)}
+	}
+	if err != nil {
+		return &utils.Response{Code: code.DBError, Msg: err.Error()}
+	}
+	cases, err := models.Models.TestReportManager.ListCases(uint(jobId))
+	if err != nil {
+		return &utils.Response{Code: code.DBError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Data: map[string]interface{}{
+		"report":       report,
+		"failed_cases": cases,
+	}}
+}
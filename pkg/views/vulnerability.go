@@ -0,0 +1,47 @@
+package views
+
+import (
+	"github.com/kubespace/pipeline-plugin/pkg/models"
+	"github.com/kubespace/pipeline-plugin/pkg/utils"
+	"github.com/kubespace/pipeline-plugin/pkg/utils/code"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type VulnerabilityViews struct {
+	Views []*View
+}
+
+func NewVulnerabilityViews() *VulnerabilityViews {
+	vv := &VulnerabilityViews{}
+	vv.Views = []*View{
+		NewView(http.MethodGet, "/:jobId", vv.list),
+	}
+	return vv
+}
+
+// list 获取镜像漏洞扫描结果，可以通过 severity、image 参数过滤
+func (v *VulnerabilityViews) list(c *Context) *utils.Response {
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "job id error: " + err.Error()}
+	}
+	vulns, err := models.Models.VulnerabilityManager.List(uint(jobId))
+	if err != nil {
+		return &utils.Response{Code: code.DBError, Msg: err.Error()}
+	}
+	severity := strings.ToUpper(c.Query("severity"))
+	image := c.Query("image")
+	filtered := vulns[:0]
+	for _, vuln := range vulns {
+		if severity != "" && vuln.Severity != severity {
+			continue
+		}
+		if image != "" && vuln.Image != image {
+			continue
+		}
+		filtered = append(filtered, vuln)
+	}
+	return &utils.Response{Code: code.Success, Data: filtered}
+}